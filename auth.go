@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// APIKeyKeyPrefix defines the prefix of the keyspace in which API keys are stored.
+	APIKeyKeyPrefix = "apikeys/"
+
+	// AdminOwner identifies the owner of links created with the static admin API key.
+	AdminOwner = "admin"
+
+	// principalContextKey is the key under which the authenticated principal is stored in the request context.
+	principalContextKey = "principal"
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is malformed, unknown or revoked.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyNotFound is returned when revoking an API key which does not exist.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKeyRecord represents an API key stored in the URL database. Only the hash of
+// the secret part of the key is stored, so that the key cannot be recovered from it.
+type APIKeyRecord struct {
+	// ID identifies the API key, and forms the first part of the key itself.
+	ID string `json:"id"`
+	// Owner identifies who the links created with the API key belong to.
+	Owner string `json:"owner"`
+	// Admin grants the holder of the API key access to every link and to the management of API keys.
+	Admin bool `json:"admin"`
+	// SecretHash contains the hex encoded SHA-256 hash of the secret part of the key.
+	SecretHash string `json:"secret_hash"`
+	// CreatedAt contains the time at which the API key was created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Principal represents the holder of the API key with which a request was authenticated.
+type Principal struct {
+	KeyID string
+	Owner string
+	Admin bool
+}
+
+// CanManage determines whether the principal may update or delete a link, which is
+// restricted to its owner and admins. Links created anonymously are only managed by admins.
+func (p *Principal) CanManage(record *LinkRecord) bool {
+	return p != nil && (p.Admin || (p.Owner != "" && p.Owner == record.Creator))
+}
+
+// CreateAPIKey generates and stores a new API key, returning the key to hand to its owner.
+func CreateAPIKey(urlDatabase URLDatabase, owner string, admin bool, now time.Time) (string, *APIKeyRecord, error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	record := &APIKeyRecord{
+		ID:         hex.EncodeToString(idBytes),
+		Owner:      owner,
+		Admin:      admin,
+		SecretHash: hashAPIKeySecret(secret),
+		CreatedAt:  now.UTC(),
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = urlDatabase.Put(apiKeyKey(record.ID), value); err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("%s.%s", record.ID, secret), record, nil
+}
+
+// RevokeAPIKey deletes an API key, so that it can no longer authenticate requests.
+func RevokeAPIKey(urlDatabase URLDatabase, ID string) error {
+	exists, err := urlDatabase.Has(apiKeyKey(ID))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrAPIKeyNotFound
+	}
+	return urlDatabase.Delete(apiKeyKey(ID))
+}
+
+// AuthenticateAPIKey looks up the stored API key matching a key presented by a client.
+func AuthenticateAPIKey(urlDatabase URLDatabase, key string) (*APIKeyRecord, error) {
+	ID, secret, found := strings.Cut(key, ".")
+	if !found || ID == "" || strings.Contains(ID, "/") {
+		return nil, ErrInvalidAPIKey
+	}
+
+	value, err := urlDatabase.Get(apiKeyKey(ID))
+	if err == storage.ErrNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &APIKeyRecord{}
+	if err = json.Unmarshal(value, record); err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(record.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return record, nil
+}
+
+// APIKeyAuthenticator authenticates requests carrying an API key, either as a bearer
+// token in the Authorization header or in the X-API-Key header.
+type APIKeyAuthenticator struct {
+	URLDatabase URLDatabase
+	AdminAPIKey string
+}
+
+// Authenticate is a middleware storing the principal of requests carrying a valid API key.
+// Requests without an API key proceed anonymously, whereas invalid API keys are rejected.
+func (a *APIKeyAuthenticator) Authenticate(context *gin.Context) {
+	key := requestAPIKey(context.Request)
+	if key == "" {
+		context.Next()
+		return
+	}
+
+	if a.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.AdminAPIKey)) == 1 {
+		context.Set(principalContextKey, &Principal{KeyID: AdminOwner, Owner: AdminOwner, Admin: true})
+		context.Next()
+		return
+	}
+
+	record, err := AuthenticateAPIKey(a.URLDatabase, key)
+	if err == ErrInvalidAPIKey {
+		abortUnauthorized(context)
+		return
+	}
+	if err != nil {
+		abortWithError(context, errInternal(err))
+		return
+	}
+
+	context.Set(principalContextKey, &Principal{KeyID: record.ID, Owner: record.Owner, Admin: record.Admin})
+	context.Next()
+}
+
+// RequireAPIKey is a middleware rejecting requests which were not authenticated with an API key.
+func RequireAPIKey(context *gin.Context) {
+	if requestPrincipal(context) == nil {
+		abortUnauthorized(context)
+		return
+	}
+	context.Next()
+}
+
+// RequireAdmin is a middleware rejecting requests which were not authenticated with an admin API key.
+func RequireAdmin(context *gin.Context) {
+	principal := requestPrincipal(context)
+	if principal == nil {
+		abortUnauthorized(context)
+		return
+	}
+	if !principal.Admin {
+		abortWithError(context, errForbidden())
+		return
+	}
+	context.Next()
+}
+
+// APIKeyRequest represents a request to create an API key.
+type APIKeyRequest struct {
+	// Owner identifies who the links created with the API key belong to.
+	Owner string `json:"owner" binding:"required"`
+	// Admin grants the holder of the API key administrative access.
+	Admin bool `json:"admin,omitempty"`
+}
+
+// APIKeyController contains logic and data related to the /api/keys route.
+type APIKeyController struct {
+	URLDatabase URLDatabase
+}
+
+// Create implements the logic for creating an API key. The key is only ever returned in this response.
+func (c *APIKeyController) Create(context *gin.Context) {
+	var keyRequest APIKeyRequest
+
+	if err := context.ShouldBindJSON(&keyRequest); err != nil {
+		respondWithError(context, errBinding(err, &keyRequest))
+		return
+	}
+
+	key, record, err := CreateAPIKey(c.URLDatabase, keyRequest.Owner, keyRequest.Admin, time.Now())
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.JSON(http.StatusCreated, gin.H{
+		"id":         record.ID,
+		"key":        key,
+		"owner":      record.Owner,
+		"admin":      record.Admin,
+		"created_at": record.CreatedAt,
+	})
+}
+
+// Revoke implements the logic for revoking an API key.
+func (c *APIKeyController) Revoke(context *gin.Context) {
+	err := RevokeAPIKey(c.URLDatabase, context.Param("id"))
+	if err == ErrAPIKeyNotFound {
+		respondWithError(context, errNotFound())
+		return
+	}
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+// requestPrincipal returns the principal with which a request was authenticated, or nil for anonymous requests.
+func requestPrincipal(context *gin.Context) *Principal {
+	if value, ok := context.Get(principalContextKey); ok {
+		return value.(*Principal)
+	}
+	return nil
+}
+
+// requestAPIKey extracts the API key presented by a request, which is empty when none is present.
+func requestAPIKey(request *http.Request) string {
+	if authorization := request.Header.Get("Authorization"); authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(request.Header.Get("X-API-Key"))
+}
+
+// abortUnauthorized rejects a request lacking valid credentials.
+func abortUnauthorized(context *gin.Context) {
+	context.Header("WWW-Authenticate", "Bearer")
+	abortWithError(context, NewAPIError(http.StatusUnauthorized, ErrorCodeUnauthorized, "A valid API key is required"))
+}
+
+// apiKeyKey returns the database key under which an API key is stored.
+func apiKeyKey(ID string) []byte {
+	return []byte(APIKeyKeyPrefix + ID)
+}
+
+// hashAPIKeySecret returns the hex encoded SHA-256 hash of the secret part of an API key.
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
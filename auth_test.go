@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("API key authentication", func() {
+	const adminAPIKey = "admin-secret"
+
+	var urlDatabase *storage.Memory
+	var config *Config
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		config = DefaultConfig()
+		config.AdminAPIKey = adminAPIKey
+	})
+
+	serve := func(method, path, apiKey string, body string) *httptest.ResponseRecorder {
+		router, err := initializeRouter(urlDatabase, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			request.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		return writer
+	}
+
+	Describe("AuthenticateAPIKey", func() {
+		var key string
+		var record *APIKeyRecord
+
+		BeforeEach(func() {
+			var err error
+			key, record, err = CreateAPIKey(urlDatabase, "alice", false, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("authenticates a created API key", func() {
+			authenticated, err := AuthenticateAPIKey(urlDatabase, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authenticated.Owner).To(Equal("alice"))
+			Expect(authenticated.Admin).To(BeFalse())
+		})
+
+		It("does not store the secret part of the API key", func() {
+			value, err := urlDatabase.Get(apiKeyKey(record.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(value)).NotTo(ContainSubstring(strings.SplitN(key, ".", 2)[1]))
+		})
+
+		It("rejects an API key with a wrong secret", func() {
+			_, err := AuthenticateAPIKey(urlDatabase, record.ID+".wrong")
+			Expect(err).To(Equal(ErrInvalidAPIKey))
+		})
+
+		It("rejects a malformed API key", func() {
+			_, err := AuthenticateAPIKey(urlDatabase, "malformed")
+			Expect(err).To(Equal(ErrInvalidAPIKey))
+		})
+
+		It("rejects a revoked API key", func() {
+			Expect(RevokeAPIKey(urlDatabase, record.ID)).To(Succeed())
+
+			_, err := AuthenticateAPIKey(urlDatabase, key)
+			Expect(err).To(Equal(ErrInvalidAPIKey))
+		})
+
+		It("reports revoking an unknown API key", func() {
+			Expect(RevokeAPIKey(urlDatabase, "unknown")).To(Equal(ErrAPIKeyNotFound))
+		})
+	})
+
+	Describe("POST /api/keys", func() {
+		It("rejects anonymous requests", func() {
+			writer := serve("POST", "/api/keys", "", `{"owner": "alice"}`)
+			Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects invalid API keys", func() {
+			writer := serve("POST", "/api/keys", "unknown.key", `{"owner": "alice"}`)
+			Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects API keys without admin access", func() {
+			key, _, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := serve("POST", "/api/keys", key, `{"owner": "bob"}`)
+			Expect(writer.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("rejects requests without an owner", func() {
+			writer := serve("POST", "/api/keys", adminAPIKey, `{}`)
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("creates an API key which authenticates requests", func() {
+			writer := serve("POST", "/api/keys", adminAPIKey, `{"owner": "alice"}`)
+			Expect(writer.Code).To(Equal(http.StatusCreated))
+
+			content := map[string]interface{}{}
+			Expect(json.Unmarshal(writer.Body.Bytes(), &content)).To(Succeed())
+			Expect(content["owner"]).To(Equal("alice"))
+
+			authenticated, err := AuthenticateAPIKey(urlDatabase, content["key"].(string))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authenticated.ID).To(Equal(content["id"]))
+		})
+	})
+
+	Describe("DELETE /api/keys/:id", func() {
+		It("revokes the API key", func() {
+			key, record, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := serve("DELETE", fmt.Sprintf("/api/keys/%s", record.ID), adminAPIKey, "")
+			Expect(writer.Code).To(Equal(http.StatusNoContent))
+
+			writer = serve("POST", "/shorten", key, `{"url": "https://example.com/"}`)
+			Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("returns a 404 for an unknown API key", func() {
+			writer := serve("DELETE", "/api/keys/unknown", adminAPIKey, "")
+			Expect(writer.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("POST /shorten", func() {
+		It("records the owner of the API key as the creator of the link", func() {
+			key, _, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := serve("POST", "/shorten", key, `{"key": "example", "url": "https://example.com/"}`)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+
+			record, _, err := readLinkRecord(urlDatabase, []byte("example"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.Creator).To(Equal("alice"))
+		})
+
+		It("allows anonymous requests by default", func() {
+			writer := serve("POST", "/shorten", "", `{"url": "https://example.com/"}`)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+		})
+
+		Context("and an API key is required", func() {
+			BeforeEach(func() {
+				config.RequireAPIKey = true
+			})
+
+			It("rejects anonymous requests", func() {
+				writer := serve("POST", "/shorten", "", `{"url": "https://example.com/"}`)
+				Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("accepts requests carrying an API key in the X-API-Key header", func() {
+				key, _, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+
+				router, _ := initializeRouter(urlDatabase, config)
+				request, _ := http.NewRequest("POST", "/shorten", bytes.NewBufferString(`{"url": "https://example.com/"}`))
+				request.Header.Set("X-API-Key", key)
+				writer := httptest.NewRecorder()
+				router.ServeHTTP(writer, request)
+
+				Expect(writer.Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Describe("Principal", func() {
+		record := &LinkRecord{Creator: "alice"}
+
+		DescribeTable("CanManage",
+			func(principal *Principal, expected bool) {
+				Expect(principal.CanManage(record)).To(Equal(expected))
+			},
+			Entry("anonymous", nil, false),
+			Entry("owner", &Principal{Owner: "alice"}, true),
+			Entry("another owner", &Principal{Owner: "bob"}, false),
+			Entry("admin", &Principal{Owner: AdminOwner, Admin: true}, true),
+		)
+
+		It("only lets admins manage links created anonymously", func() {
+			anonymousRecord := &LinkRecord{}
+			Expect((&Principal{}).CanManage(anonymousRecord)).To(BeFalse())
+			Expect((&Principal{Admin: true}).CanManage(anonymousRecord)).To(BeTrue())
+		})
+	})
+})
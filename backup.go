@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// A backup archive is a gzip stream holding the magic line below, followed by every key-value
+// pair of the URL database in key order, and a trailer. Pairs start with backupPairTag, then
+// hold the key and the value, each preceded by its length as an unsigned varint. The trailer
+// starts with backupEndTag, then holds the number of pairs as an unsigned varint and the
+// SHA-256 checksum of everything preceding the checksum.
+const (
+	backupMagic   = "bajo-backup 1\n"
+	backupPairTag = 'p'
+	backupEndTag  = 'e'
+
+	// backupMaxSize bounds the size of the keys and values read from an archive, so that a
+	// damaged length cannot exhaust memory before the checksum is verified.
+	backupMaxSize = 64 << 20
+
+	// restoreBatchSize is the number of pairs written to the URL database at once on restore.
+	restoreBatchSize = 1000
+)
+
+var (
+	// ErrBackupFormat is returned when a file is not a backup archive, or is truncated.
+	ErrBackupFormat = errors.New("not a complete bajo backup archive")
+	// ErrBackupChecksum is returned when the content of a backup archive does not match its checksum.
+	ErrBackupChecksum = errors.New("the backup archive does not match its checksum")
+	// ErrDatabaseNotEmpty is returned when restoring a backup into a database holding keys.
+	ErrDatabaseNotEmpty = errors.New("backups can only be restored into an empty database")
+)
+
+// BackupSummary describes the content of a backup archive.
+type BackupSummary struct {
+	// Pairs is the number of key-value pairs held by the archive.
+	Pairs int `json:"pairs"`
+	// Checksum is the hex-encoded SHA-256 checksum of the archive content.
+	Checksum string `json:"checksum"`
+}
+
+// WriteBackup writes an archive of every key-value pair of the URL database. The pairs are read
+// by a single iterator, which iterates over a consistent snapshot of the storage backends.
+func WriteBackup(urlDatabase URLDatabase, writer io.Writer) (*BackupSummary, error) {
+	compressor := gzip.NewWriter(writer)
+	checksum := sha256.New()
+	archive := bufio.NewWriter(io.MultiWriter(compressor, checksum))
+
+	iter := urlDatabase.NewIterator(nil)
+	defer iter.Release()
+
+	archive.WriteString(backupMagic)
+	pairs := 0
+	for iter.Next() {
+		archive.WriteByte(backupPairTag)
+		writeBackupBytes(archive, iter.Key())
+		writeBackupBytes(archive, iter.Value())
+		pairs++
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	archive.WriteByte(backupEndTag)
+	writeBackupUvarint(archive, uint64(pairs))
+	if err := archive.Flush(); err != nil {
+		return nil, err
+	}
+
+	sum := checksum.Sum(nil)
+	if _, err := compressor.Write(sum); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+
+	return &BackupSummary{Pairs: pairs, Checksum: hex.EncodeToString(sum)}, nil
+}
+
+// writeBackupUvarint writes an unsigned varint to an archive.
+func writeBackupUvarint(archive *bufio.Writer, value uint64) {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	archive.Write(buffer[:binary.PutUvarint(buffer, value)])
+}
+
+// writeBackupBytes writes a key or value to an archive, preceded by its length.
+func writeBackupBytes(archive *bufio.Writer, value []byte) {
+	writeBackupUvarint(archive, uint64(len(value)))
+	archive.Write(value)
+}
+
+// ReadBackup reads the key-value pairs of an archive, calling fn with each of them unless fn is
+// nil. The checksum is verified once every pair has been read, so fn may be called with pairs of
+// an archive which turns out to be damaged.
+func ReadBackup(reader io.Reader, fn func(key, value []byte) error) (*BackupSummary, error) {
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBackupFormat, err)
+	}
+	defer decompressor.Close()
+
+	checksum := sha256.New()
+	archive := &backupReader{reader: bufio.NewReader(decompressor), checksum: checksum}
+
+	magic := make([]byte, len(backupMagic))
+	if err = archive.readFull(magic); err != nil || string(magic) != backupMagic {
+		return nil, ErrBackupFormat
+	}
+
+	pairs := 0
+	for {
+		tag, err := archive.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if tag == backupEndTag {
+			break
+		}
+		if tag != backupPairTag {
+			return nil, ErrBackupFormat
+		}
+
+		key, err := archive.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := archive.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if fn != nil {
+			if err = fn(key, value); err != nil {
+				return nil, err
+			}
+		}
+		pairs++
+	}
+
+	count, err := archive.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	// The checksum itself is not part of the checksummed content.
+	expected := checksum.Sum(nil)
+	archive.checksum = nil
+	sum := make([]byte, sha256.Size)
+	if err = archive.readFull(sum); err != nil {
+		return nil, err
+	}
+	if string(sum) != string(expected) || count != uint64(pairs) {
+		return nil, ErrBackupChecksum
+	}
+	if _, err = archive.reader.ReadByte(); err != io.EOF {
+		return nil, ErrBackupFormat
+	}
+
+	return &BackupSummary{Pairs: pairs, Checksum: hex.EncodeToString(sum)}, nil
+}
+
+// RestoreBackup writes the key-value pairs of an archive to an empty URL database. The archive
+// is verified before anything is written, then read a second time to be restored. The pairs are
+// written in batches, so those already written are deleted should the restore fail, leaving the
+// database empty for the restore to be retried.
+func RestoreBackup(urlDatabase URLDatabase, archive io.ReadSeeker) (*BackupSummary, error) {
+	iter := urlDatabase.NewIterator(nil)
+	empty := !iter.Next()
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, ErrDatabaseNotEmpty
+	}
+
+	if _, err := ReadBackup(archive, nil); err != nil {
+		return nil, err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	batch := new(storage.Batch)
+	summary, err := ReadBackup(archive, func(key, value []byte) error {
+		batch.Put(key, value)
+		if batch.Len() < restoreBatchSize {
+			return nil
+		}
+		err := urlDatabase.Write(batch)
+		batch = new(storage.Batch)
+		return err
+	})
+	if err == nil {
+		err = urlDatabase.Write(batch)
+	}
+	if err != nil {
+		if clearErr := clearDatabase(urlDatabase); clearErr != nil {
+			return nil, fmt.Errorf("%w; the partially restored database could not be emptied (%v), so it must be deleted before restoring again", err, clearErr)
+		}
+		return nil, err
+	}
+	return summary, nil
+}
+
+// clearDatabase deletes every key of the URL database, in batches.
+func clearDatabase(urlDatabase URLDatabase) error {
+	iter := urlDatabase.NewIterator(nil)
+	defer iter.Release()
+
+	batch := new(storage.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+		if batch.Len() < restoreBatchSize {
+			continue
+		}
+		if err := urlDatabase.Write(batch); err != nil {
+			return err
+		}
+		batch = new(storage.Batch)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return urlDatabase.Write(batch)
+}
+
+// backupReader reads the content of an archive, adding it to a checksum.
+type backupReader struct {
+	reader   *bufio.Reader
+	checksum hash.Hash
+}
+
+// readFull reads exactly enough bytes to fill a buffer.
+func (r *backupReader) readFull(buffer []byte) error {
+	if _, err := io.ReadFull(r.reader, buffer); err != nil {
+		return backupReadError(err)
+	}
+	if r.checksum != nil {
+		r.checksum.Write(buffer)
+	}
+	return nil
+}
+
+// readByte reads a single byte.
+func (r *backupReader) readByte() (byte, error) {
+	buffer := []byte{0}
+	err := r.readFull(buffer)
+	return buffer[0], err
+}
+
+// readUvarint reads an unsigned varint.
+func (r *backupReader) readUvarint() (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+	return 0, ErrBackupFormat
+}
+
+// readBytes reads a key or value preceded by its length.
+func (r *backupReader) readBytes() ([]byte, error) {
+	size, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if size > backupMaxSize {
+		return nil, ErrBackupFormat
+	}
+	buffer := make([]byte, size)
+	return buffer, r.readFull(buffer)
+}
+
+// backupReadError reports the end of an archive read too early as a format error.
+func backupReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBackupFormat
+	}
+	return fmt.Errorf("%w: %s", ErrBackupFormat, err)
+}
+
+// BackupController contains logic and data related to the /api/backup route.
+type BackupController struct {
+	URLDatabase URLDatabase
+}
+
+// Backup implements the logic for the /api/backup route, which streams a backup archive of the
+// URL database. A failure once the archive has started to be sent is logged, and leaves the
+// client with a truncated archive which fails verification on restore.
+func (c *BackupController) Backup(context *gin.Context) {
+	filename := fmt.Sprintf("bajo-%s.backup", time.Now().UTC().Format("20060102T150405Z"))
+	context.Header("Content-Type", "application/octet-stream")
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	summary, err := WriteBackup(c.URLDatabase, context.Writer)
+	if err != nil {
+		if !context.Writer.Written() {
+			context.Writer.Header().Del("Content-Disposition")
+			respondWithError(context, errInternal(err))
+			return
+		}
+		requestLogger(context).Error("unable to complete backup", "error", err)
+		addLogFields(context, "outcome", ErrorCodeInternal)
+		return
+	}
+	addLogFields(context, "pairs", summary.Pairs, "checksum", summary.Checksum)
+}
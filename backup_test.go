@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+// databaseContent returns every key-value pair of a database.
+func databaseContent(database URLDatabase) map[string]string {
+	content := map[string]string{}
+	iter := database.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		content[string(iter.Key())] = string(iter.Value())
+	}
+	Expect(iter.Error()).NotTo(HaveOccurred())
+	return content
+}
+
+var _ = Describe("Backup", func() {
+	var urlDatabase *storage.Memory
+	var archive *bytes.Buffer
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		// The database may already have been closed by the spec.
+		DeferCleanup(func() { urlDatabase.Close() })
+
+		createdAt := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+		Expect(writeLinkRecord(urlDatabase, []byte("docs"), NewLinkRecord("https://example.com/", createdAt, time.Time{}))).To(Succeed())
+		Expect(RecordClick(urlDatabase, ClickEvent{Key: "docs", Timestamp: createdAt})).To(Succeed())
+		Expect(urlDatabase.Put([]byte("empty"), []byte{})).To(Succeed())
+
+		archive = new(bytes.Buffer)
+		summary, err := WriteBackup(urlDatabase, archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Pairs).To(Equal(3))
+		Expect(summary.Checksum).To(HaveLen(64))
+	})
+
+	// rewrite decompresses the archive, modifies its content and compresses it again, so that
+	// only the checksum of the archive can tell.
+	rewrite := func(modify func(content []byte) []byte) {
+		decompressor, err := gzip.NewReader(archive)
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(decompressor)
+		Expect(err).NotTo(HaveOccurred())
+
+		archive = new(bytes.Buffer)
+		compressor := gzip.NewWriter(archive)
+		compressor.Write(modify(content))
+		Expect(compressor.Close()).To(Succeed())
+	}
+
+	It("should restore every pair into an empty database", func() {
+		restoredDatabase := storage.NewMemory()
+		DeferCleanup(restoredDatabase.Close)
+
+		summary, err := RestoreBackup(restoredDatabase, bytes.NewReader(archive.Bytes()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Pairs).To(Equal(3))
+		Expect(databaseContent(restoredDatabase)).To(Equal(databaseContent(urlDatabase)))
+	})
+
+	It("should not restore into a database holding keys", func() {
+		_, err := RestoreBackup(urlDatabase, bytes.NewReader(archive.Bytes()))
+		Expect(err).To(MatchError(ErrDatabaseNotEmpty))
+	})
+
+	It("should not restore anything from an archive which does not match its checksum", func() {
+		rewrite(func(content []byte) []byte {
+			return bytes.Replace(content, []byte("example.com"), []byte("example.org"), 1)
+		})
+
+		restoredDatabase := storage.NewMemory()
+		DeferCleanup(restoredDatabase.Close)
+
+		_, err := RestoreBackup(restoredDatabase, bytes.NewReader(archive.Bytes()))
+		Expect(err).To(MatchError(ErrBackupChecksum))
+		Expect(databaseContent(restoredDatabase)).To(BeEmpty())
+	})
+
+	It("should delete the pairs already restored when the restore fails", func() {
+		for index := 0; index < restoreBatchSize; index++ {
+			Expect(urlDatabase.Put([]byte(fmt.Sprintf("key%04d", index)), []byte("https://example.com/"))).To(Succeed())
+		}
+		archive = new(bytes.Buffer)
+		_, err := WriteBackup(urlDatabase, archive)
+		Expect(err).NotTo(HaveOccurred())
+
+		restoredDatabase := storage.NewMemory()
+		DeferCleanup(restoredDatabase.Close)
+
+		ctrl := gomock.NewController(GinkgoT())
+		mockURLDatabase := mocks.NewMockURLDatabase(ctrl)
+		mockURLDatabase.EXPECT().NewIterator(gomock.Any()).DoAndReturn(restoredDatabase.NewIterator).AnyTimes()
+		gomock.InOrder(
+			mockURLDatabase.EXPECT().Write(gomock.Any()).DoAndReturn(restoredDatabase.Write),
+			mockURLDatabase.EXPECT().Write(gomock.Any()).Return(errors.New("disk full")),
+			mockURLDatabase.EXPECT().Write(gomock.Any()).DoAndReturn(restoredDatabase.Write).AnyTimes(),
+		)
+
+		_, err = RestoreBackup(mockURLDatabase, bytes.NewReader(archive.Bytes()))
+		Expect(err).To(MatchError("disk full"))
+		Expect(databaseContent(restoredDatabase)).To(BeEmpty())
+	})
+
+	It("should reject a truncated archive", func() {
+		rewrite(func(content []byte) []byte {
+			return content[:len(content)-40]
+		})
+
+		_, err := ReadBackup(archive, nil)
+		Expect(err).To(MatchError(ErrBackupFormat))
+	})
+
+	It("should reject a file which is not an archive", func() {
+		_, err := ReadBackup(bytes.NewReader([]byte("docs,https://example.com/\n")), nil)
+		Expect(err).To(MatchError(ErrBackupFormat))
+	})
+
+	Describe("/api/backup", func() {
+		const adminAPIKey = "admin-secret"
+
+		send := func(apiKey string) *httptest.ResponseRecorder {
+			config := DefaultConfig()
+			config.AdminAPIKey = adminAPIKey
+			router, err := initializeRouter(urlDatabase, config)
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/backup", nil)
+			if apiKey != "" {
+				request.Header.Set("Authorization", "Bearer "+apiKey)
+			}
+			router.ServeHTTP(writer, request)
+			return writer
+		}
+
+		It("should stream an archive of the database to admins", func() {
+			writer := send(adminAPIKey)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Header().Get("Content-Type")).To(Equal("application/octet-stream"))
+			Expect(writer.Header().Get("Content-Disposition")).To(MatchRegexp(`^attachment; filename="bajo-\d{8}T\d{6}Z\.backup"$`))
+
+			summary, err := ReadBackup(writer.Body, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Pairs).To(Equal(3))
+		})
+
+		It("should reject other clients", func() {
+			Expect(send("").Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should report a database which cannot be read", func() {
+			Expect(urlDatabase.Close()).To(Succeed())
+
+			writer := send(adminAPIKey)
+			Expect(writer.Code).To(Equal(http.StatusInternalServerError))
+			Expect(writer.Header().Get("Content-Disposition")).To(BeEmpty())
+		})
+	})
+
+	Describe("commands", func() {
+		var databasePath, archivePath string
+
+		BeforeEach(func() {
+			databasePath = GinkgoT().TempDir()
+			archivePath = filepath.Join(GinkgoT().TempDir(), "bajo.backup")
+
+			levelDB, err := storage.OpenLevelDB(databasePath, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(levelDB.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+			Expect(levelDB.Close()).To(Succeed())
+		})
+
+		It("should back up a database and restore it into another backend", func() {
+			Expect(run([]string{"backup", "-database-path", databasePath, archivePath})).To(Equal(0))
+
+			restoredPath := filepath.Join(GinkgoT().TempDir(), "bajo.db")
+			Expect(run([]string{"restore", "-database-backend", "sqlite", "-database-path", restoredPath, archivePath})).To(Equal(0))
+
+			restoredDatabase, err := storage.OpenSQLite(restoredPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer restoredDatabase.Close()
+			Expect(databaseContent(restoredDatabase)).To(Equal(map[string]string{"docs": "https://example.com/"}))
+		})
+
+		Context("when sent to a server", func() {
+			const adminAPIKey = "admin-secret"
+
+			It("should back up the database held by the server", func() {
+				levelDB, err := storage.OpenLevelDB(databasePath, nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer levelDB.Close()
+
+				config := DefaultConfig()
+				config.AdminAPIKey = adminAPIKey
+				router, err := initializeRouter(levelDB, config)
+				Expect(err).NotTo(HaveOccurred())
+				server := httptest.NewServer(router)
+				defer server.Close()
+
+				Expect(run([]string{"backup", "-database-path", databasePath, archivePath})).To(Equal(1))
+				Expect(run([]string{"backup", "-server", server.URL, "-api-key", adminAPIKey, archivePath})).To(Equal(0))
+
+				file, err := os.Open(archivePath)
+				Expect(err).NotTo(HaveOccurred())
+				defer file.Close()
+				summary, err := ReadBackup(file, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(summary.Pairs).To(Equal(1))
+			})
+
+			It("should not keep an archive cut short by the server", func() {
+				server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+					writer.Write(archive.Bytes()[:archive.Len()/2])
+				}))
+				defer server.Close()
+
+				Expect(run([]string{"backup", "-server", server.URL, archivePath})).To(Equal(1))
+				Expect(archivePath).NotTo(BeAnExistingFile())
+				Expect(archivePath + ".tmp").NotTo(BeAnExistingFile())
+			})
+		})
+
+		It("should exit with status 1 when the archive cannot be written", func() {
+			archivePath = filepath.Join(GinkgoT().TempDir(), "missing", "bajo.backup")
+			Expect(run([]string{"backup", "-database-path", databasePath, archivePath})).To(Equal(1))
+		})
+
+		It("should exit with status 1 when restoring into a database holding keys", func() {
+			Expect(run([]string{"backup", "-database-path", databasePath, archivePath})).To(Equal(0))
+			Expect(run([]string{"restore", "-database-path", databasePath, archivePath})).To(Equal(1))
+		})
+
+		It("should exit with status 1 when the archive is missing", func() {
+			Expect(run([]string{"restore", "-database-path", GinkgoT().TempDir(), archivePath})).To(Equal(1))
+		})
+
+		It("should exit with status 2 without an archive", func() {
+			Expect(run([]string{"backup", "-database-path", databasePath})).To(Equal(2))
+		})
+	})
+})
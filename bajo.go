@@ -1,28 +1,556 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
 	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
 )
 
 func main() {
-	databaseManager := &LevelDBDatabaseManager{}
-	urlDatabase := GetURLDatabase(databaseManager)
-	defer urlDatabase.Close()
-	router := initializeRouter(urlDatabase)
-	router.Run(":8080")
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the service, or one of its commands, returning the exit code of the process.
+// Deferred functions run before the process exits, so that the URL database is closed cleanly.
+func run(args []string) int {
+	// The service is served by default, or by the serve command. Other commands operate on the
+	// URL database, then exit; those listed in clientCommands may instead be sent to a running
+	// server through its HTTP API.
+	command := ""
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	} else if _, ok := commandUsages[firstArg(args)]; ok {
+		command, args = args[0], args[1:]
+	}
+
+	flagSet := flag.NewFlagSet("bajo", flag.ContinueOnError)
+	flags := &commandFlags{}
+	flags.define(flagSet, command)
+
+	config, err := loadConfig(flagSet, args, os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		logger.Error("invalid configuration", "error", err)
+		return 2
+	}
+	if command == "" && len(config.Args) > 0 {
+		logger.Error("invalid arguments", "error", fmt.Sprintf("unknown command %q", config.Args[0]), "usage", serveUsage())
+		return 2
+	}
+	if usage := commandUsages[command]; command != "" && len(config.Args) != len(strings.Fields(usage)) {
+		logger.Error("invalid arguments", "error", fmt.Sprintf("usage: bajo %s [flags] %s", command, usage))
+		return 2
+	}
+	if err = flags.transfer.Validate(); err != nil {
+		logger.Error("invalid arguments", "error", err)
+		return 2
+	}
+
+	// The level has been validated along with the configuration.
+	logLevel, _ := ParseLogLevel(config.LogLevel)
+	logger = NewLogger(os.Stderr, logLevel, config.LogFormat)
+
+	// Commands print their results on the standard output, which the debug messages of gin
+	// would otherwise be mixed with.
+	if command != "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	if flags.server != "" {
+		client, err := NewRemoteAPIClient(flags.server, flags.apiKey)
+		if err != nil {
+			logger.Error("invalid arguments", "error", err)
+			return 2
+		}
+		return runClientCommand(client, command, config, flags)
+	}
+
+	databaseManager, err := NewDatabaseManager(config)
+	if err != nil {
+		logger.Error("unable to start", "error", err)
+		return 1
+	}
+	urlDatabase, err := GetURLDatabase(databaseManager, config.DatabasePath)
+	if errors.Is(err, ErrDatabaseLocked) && clientCommands[command] {
+		logger.Error("unable to start", "error", err, "hint", "send the command to the running server with -server")
+		return 1
+	}
+	if err != nil {
+		logger.Error("unable to start", "error", err)
+		return 1
+	}
+	defer func() {
+		if err := urlDatabase.Close(); err != nil {
+			logger.Error("unable to close URL database", "error", err)
+		}
+	}()
+
+	switch command {
+	case "migrate":
+		return runMigrate(urlDatabase)
+	case "backup":
+		return runBackup(config.Args[0], func(writer io.Writer) error {
+			_, err := WriteBackup(urlDatabase, writer)
+			return err
+		})
+	case "restore":
+		return runRestore(urlDatabase, config.Args[0])
+	case "export":
+		format := transferFormat(flags.transfer.Format, config.Args[0])
+		return runExport(config.Args[0], func(writer io.Writer) error {
+			_, err := ExportLinks(urlDatabase, writer, format)
+			return err
+		})
+	case "import":
+		options := flags.transfer
+		options.Format = transferFormat(options.Format, config.Args[0])
+		return runImport(config.Args[0], func(reader io.Reader) (*ImportSummary, error) {
+			keyPolicy, err := newCommandKeyPolicy(config)
+			if err != nil {
+				return nil, err
+			}
+			importer := LinkImporter{URLDatabase: urlDatabase, Config: config, KeyPolicy: keyPolicy}
+			return importer.Import(reader, options)
+		})
+	case "shorten", "get", "delete", "list", "stats", "compact":
+		// The commands operating on links go through the routes, served in process.
+		client, err := NewLocalAPIClient(urlDatabase, config)
+		if err != nil {
+			logger.Error("unable to initialize router", "error", err)
+			return 1
+		}
+		return runAPICommand(client, command, config.Args, flags)
+	}
+
+	// The read cache is shared by the expiry sweeper and the routes, so that links deleted or
+	// updated by either are invalidated.
+	if config.CacheSize > 0 {
+		urlDatabase = NewCachedURLDatabase(urlDatabase, config.CacheSize, config.CacheTTL, config.CacheNegativeTTL)
+	}
+
+	router, err := initializeRouter(urlDatabase, config)
+	if err != nil {
+		logger.Error("unable to initialize router", "error", err)
+		return 1
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		logger.Error("unable to start", "error", fmt.Errorf("unable to listen on %s: %w", config.ListenAddress, err))
+		return 1
+	}
+
+	// The service shuts down on SIGINT or SIGTERM, stopping background work along with the server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Background work is stopped and waited for before the URL database is closed, also when
+	// serving fails rather than being interrupted.
+	var background sync.WaitGroup
+	defer func() {
+		stop()
+		background.Wait()
+	}()
+
+	// Expired links cannot be deleted from a read-only database.
+	if config.ExpirySweepInterval > 0 && !config.LevelDBReadOnly {
+		expirySweeper := &ExpirySweeper{
+			URLDatabase: urlDatabase,
+			Interval:    config.ExpirySweepInterval,
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			expirySweeper.Run(ctx)
+		}()
+	}
+
+	logger.Info("listening", "address", listener.Addr().String())
+	if err = Serve(ctx, listener, router, config.ShutdownTimeout); err != nil {
+		logger.Error("unable to serve", "error", err)
+		return 1
+	}
+
+	logger.Info("stopped")
+	return 0
+}
+
+// firstArg returns the first command-line argument, if any.
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// serveUsage describes how the service and its commands are run.
+func serveUsage() string {
+	commands := make([]string, 0, len(commandUsages))
+	for command := range commandUsages {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return fmt.Sprintf("bajo [serve|%s] [flags] [arguments]", strings.Join(commands, "|"))
+}
+
+// runClientCommand runs a command by sending it to a server, and returns the exit code of the
+// process.
+func runClientCommand(client *APIClient, command string, config *Config, flags *commandFlags) int {
+	switch command {
+	case "backup":
+		return runBackup(config.Args[0], client.Backup)
+	case "export":
+		format := transferFormat(flags.transfer.Format, config.Args[0])
+		return runExport(config.Args[0], func(writer io.Writer) error {
+			return client.ExportLinks(writer, format)
+		})
+	case "import":
+		options := flags.transfer
+		options.Format = transferFormat(options.Format, config.Args[0])
+		return runImport(config.Args[0], func(reader io.Reader) (*ImportSummary, error) {
+			return client.ImportLinks(reader, options)
+		})
+	default:
+		return runAPICommand(client, command, config.Args, flags)
+	}
+}
+
+// runMigrate runs the migrate command, returning the exit code of the process.
+func runMigrate(urlDatabase URLDatabase) int {
+	migrated, err := MigrateLinkRecords(urlDatabase)
+	if err != nil {
+		logger.Error("unable to migrate legacy values", "error", err)
+		return 1
+	}
+	logger.Info("migrated legacy values to link records", "count", migrated)
+	return 0
+}
+
+// runBackup runs the backup command, writing the archive written by backup to a file, and
+// returns the exit code of the process. The archive is written under a temporary name and
+// verified, so that an incomplete archive, such as one cut short by a server, is never left at
+// the path.
+func runBackup(path string, backup func(writer io.Writer) error) int {
+	temporaryPath := path + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		logger.Error("unable to back up URL database", "error", err)
+		return 1
+	}
+
+	var summary *BackupSummary
+	err = backup(file)
+	if err == nil {
+		if _, err = file.Seek(0, io.SeekStart); err == nil {
+			summary, err = ReadBackup(file, nil)
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		logger.Error("unable to back up URL database", "error", err)
+		return 1
+	}
+
+	logger.Info("backed up URL database", "path", path, "pairs", summary.Pairs, "checksum", summary.Checksum)
+	return 0
+}
+
+// runRestore runs the restore command, writing the pairs of the archive in a file to the URL
+// database, and returns the exit code of the process.
+func runRestore(urlDatabase URLDatabase, path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Error("unable to restore URL database", "error", err)
+		return 1
+	}
+	defer file.Close()
+
+	summary, err := RestoreBackup(urlDatabase, file)
+	if err != nil {
+		logger.Error("unable to restore URL database", "path", path, "error", err)
+		return 1
+	}
+
+	logger.Info("restored URL database", "path", path, "pairs", summary.Pairs, "checksum", summary.Checksum)
+	return 0
+}
+
+// transferFormat returns the format of a file to export or import, which is inferred from its
+// name unless given.
+func transferFormat(format, path string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return TransferFormatCSV
+	}
+	return TransferFormatJSONL
+}
+
+// runExport runs the export command, writing the links exported by export to a file, or to the
+// standard output when the path is "-", and returns the exit code of the process. The file is
+// written under a temporary name, so that an incomplete export is never left at the path.
+func runExport(path string, export func(writer io.Writer) error) int {
+	if path == "-" {
+		if err := export(commandOutput); err != nil {
+			logger.Error("unable to export links", "error", err)
+			return 1
+		}
+		logger.Info("exported links")
+		return 0
+	}
+
+	temporaryPath := path + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		logger.Error("unable to export links", "error", err)
+		return 1
+	}
+
+	err = export(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		logger.Error("unable to export links", "error", err)
+		return 1
+	}
+
+	logger.Info("exported links", "path", path)
+	return 0
+}
+
+// runImport runs the import command, passing the links of a file, or of the standard input when
+// the path is "-", to importLinks, and returns the exit code of the process. The summary of the
+// import is logged along with the invalid lines it describes, which do not fail the import.
+func runImport(path string, importLinks func(reader io.Reader) (*ImportSummary, error)) int {
+	reader := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			logger.Error("unable to import links", "error", err)
+			return 1
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	summary, err := importLinks(reader)
+	if summary == nil {
+		logger.Error("unable to import links", "path", path, "error", err)
+		return 1
+	}
+
+	for _, lineError := range summary.Errors {
+		logger.Warn("invalid line", "line", lineError.Line, "key", lineError.Key, "error", lineError.Error)
+	}
+	if summary.Invalid > len(summary.Errors) {
+		logger.Warn("further invalid lines omitted", "count", summary.Invalid-len(summary.Errors))
+	}
+
+	// Links preceding a failure have been imported, so they are reported either way.
+	fields := []interface{}{"path", path, "links", summary.Links, "created", summary.Created,
+		"overwritten", summary.Overwritten, "skipped", summary.Skipped, "invalid", summary.Invalid, "dry_run", summary.DryRun}
+	if err != nil {
+		logger.Error("unable to import links", append(fields, "error", err)...)
+		return 1
+	}
+	logger.Info("imported links", fields...)
+	return 0
+}
+
+// newCommandKeyPolicy creates the key policy with which commands validate custom keys, which
+// reserves the keys shadowed by the routes of the service as the router does.
+func newCommandKeyPolicy(config *Config) (*KeyPolicy, error) {
+	keyPolicy, err := NewKeyPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := initializeRouter(storage.NewMemory(), config)
+	if err != nil {
+		return nil, err
+	}
+	keyPolicy.Reserve(routeKeys(routePaths(router))...)
+
+	return keyPolicy, nil
 }
 
-func initializeRouter(urlDatabase URLDatabase) *gin.Engine {
+func initializeRouter(urlDatabase URLDatabase, config *Config) (*gin.Engine, error) {
+	urlPrefixResolver, err := NewURLPrefixResolver(config)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPolicy, err := NewKeyPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Database operations are measured by wrapping the storage database, underneath the read
+	// cache if any, so that cache hits are not counted as storage operations.
+	metrics := NewMetrics()
+	storageDatabase := urlDatabase
+	cache, cached := urlDatabase.(*CachedURLDatabase)
+	if cached {
+		metrics.RegisterCache(cache)
+		storageDatabase = cache.URLDatabase
+	}
+	if instrumented, ok := storageDatabase.(*InstrumentedURLDatabase); ok {
+		storageDatabase = instrumented.URLDatabase
+	}
+	if levelDB, ok := storageDatabase.(*storage.LevelDB); ok {
+		metrics.RegisterLevelDB(levelDB.DB)
+	}
+
+	instrumentedDatabase := &InstrumentedURLDatabase{URLDatabase: storageDatabase, Metrics: metrics}
+	if cached {
+		// The cache is shared with the expiry sweeper, whose reads of storage are thus measured too.
+		cache.URLDatabase = instrumentedDatabase
+	} else {
+		urlDatabase = instrumentedDatabase
+	}
+
 	shortenController := ShortenController{
-		URLDatabase: urlDatabase,
+		URLDatabase:       urlDatabase,
+		Config:            config,
+		URLPrefixResolver: urlPrefixResolver,
+		KeyPolicy:         keyPolicy,
+		Metrics:           metrics,
 	}
 
 	redirectController := RedirectController{
 		URLDatabase: urlDatabase,
+		KeyPolicy:   keyPolicy,
+		Metrics:     metrics,
+		ReadOnly:    config.LevelDBReadOnly,
 	}
 
-	router := gin.Default()
-	router.POST("/shorten", shortenController.Shorten)
-	router.GET("/:key", redirectController.Redirect)
-	return router
+	statsController := StatsController{
+		URLDatabase: urlDatabase,
+		KeyPolicy:   keyPolicy,
+	}
+
+	linkController := LinkController{
+		URLDatabase:       urlDatabase,
+		Config:            config,
+		URLPrefixResolver: urlPrefixResolver,
+		KeyPolicy:         keyPolicy,
+	}
+
+	apiKeyAuthenticator := APIKeyAuthenticator{
+		URLDatabase: urlDatabase,
+		AdminAPIKey: config.AdminAPIKey,
+	}
+
+	apiKeyController := APIKeyController{
+		URLDatabase: urlDatabase,
+	}
+
+	healthController := HealthController{
+		URLDatabase: urlDatabase,
+	}
+
+	backupController := BackupController{
+		URLDatabase: urlDatabase,
+	}
+
+	compactController := CompactController{
+		URLDatabase: storageDatabase,
+	}
+
+	transferController := TransferController{
+		URLDatabase: urlDatabase,
+		Config:      config,
+		KeyPolicy:   keyPolicy,
+	}
+
+	router := gin.New()
+
+	// Requests are identified before they are logged, and logged even when their handler panics.
+	router.Use(RequestID, LogRequests, metrics.Instrument, gin.CustomRecoveryWithWriter(nil, recoverPanic))
+
+	// The client IP reported by gin is only taken from forwarded headers of trusted proxies.
+	if err = router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	router.Use(apiKeyAuthenticator.Authenticate)
+
+	// Single and batch shorten requests share the same rate limit.
+	shortenMiddleware := []gin.HandlerFunc{}
+	if config.ShortenRateLimit > 0 {
+		shortenMiddleware = append(shortenMiddleware, RateLimit(NewMemoryRateLimiter(config.ShortenRateLimit, config.ShortenRateBurst)))
+	}
+	if config.RequireAPIKey {
+		shortenMiddleware = append(shortenMiddleware, RequireAPIKey)
+	}
+
+	redirectMiddleware := []gin.HandlerFunc{}
+	if config.RedirectRateLimit > 0 {
+		redirectMiddleware = append(redirectMiddleware, RateLimit(NewMemoryRateLimiter(config.RedirectRateLimit, config.RedirectRateBurst)))
+	}
+
+	router.POST("/shorten", withMiddleware(shortenMiddleware, shortenController.Shorten)...)
+	router.GET("/shorten", withMiddleware(shortenMiddleware, shortenController.Shorten)...)
+	router.POST("/shorten/batch", withMiddleware(shortenMiddleware, shortenController.ShortenBatch)...)
+	router.GET("/:key", withMiddleware(redirectMiddleware, redirectController.Redirect)...)
+	router.GET("/:key/stats", statsController.Stats)
+	router.GET("/api/links", RequireAdmin, linkController.List)
+	router.GET("/api/links/:key", linkController.Get)
+	router.PATCH("/api/links/:key", RequireAPIKey, linkController.Update)
+	router.DELETE("/api/links/:key", RequireAPIKey, linkController.Delete)
+	router.POST("/api/keys", RequireAdmin, apiKeyController.Create)
+	router.DELETE("/api/keys/:id", RequireAdmin, apiKeyController.Revoke)
+	router.GET("/api/backup", RequireAdmin, backupController.Backup)
+	router.GET("/api/export", RequireAdmin, transferController.Export)
+	router.POST("/api/import", RequireAdmin, transferController.Import)
+	router.POST("/api/compact", RequireAdmin, compactController.Compact)
+	router.GET("/metrics", metrics.Handler())
+	router.GET("/healthz", healthController.Healthz)
+	router.GET("/readyz", healthController.Readyz)
+
+	// Custom keys named after a route would be shadowed by it, so they are reserved.
+	keyPolicy.Reserve(routeKeys(routePaths(router))...)
+
+	return router, nil
+}
+
+// routePaths returns the paths of the routes of a router.
+func routePaths(router *gin.Engine) []string {
+	paths := []string{}
+	for _, route := range router.Routes() {
+		paths = append(paths, route.Path)
+	}
+	return paths
+}
+
+// withMiddleware returns the handler chain running the middleware before a handler.
+func withMiddleware(middleware []gin.HandlerFunc, handler gin.HandlerFunc) []gin.HandlerFunc {
+	return append(append([]gin.HandlerFunc{}, middleware...), handler)
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// MaxShortenBatchSize determines the maximum number of URLs shortened by a single batch request.
+	MaxShortenBatchSize = 10000
+
+	// MaxShortenBatchBodySize determines the maximum size in bytes of the body of a batch request.
+	MaxShortenBatchBodySize = 32 << 20
+)
+
+var (
+	// ErrBatchTooLarge is returned when a batch request holds more than MaxShortenBatchSize items.
+	ErrBatchTooLarge = fmt.Errorf("a batch may hold at most %d items", MaxShortenBatchSize)
+	// ErrBatchBodyTooLarge is returned when the body of a batch request exceeds MaxShortenBatchBodySize.
+	ErrBatchBodyTooLarge = fmt.Errorf("the body of a batch may hold at most %d bytes", MaxShortenBatchBodySize)
+)
+
+// ShortenBatchResult represents the outcome of shortening one item of a batch request.
+type ShortenBatchResult struct {
+	// Index is the position of the item in the batch.
+	Index int `json:"index"`
+	// Status is the HTTP status code with which the item would have been answered on its own.
+	Status int `json:"status"`
+	// Key contains the URL key of the shortened URL, when the item succeeded.
+	Key string `json:"key,omitempty"`
+	// ShortenedURL contains the shortened URL, when the item succeeded.
+	ShortenedURL string `json:"shortened_url,omitempty"`
+	// Code identifies the reason the item failed, as in error responses.
+	Code string `json:"code,omitempty"`
+	// Error describes the reason the item failed.
+	Error string `json:"error,omitempty"`
+	// Field names the field of the item which caused it to fail.
+	Field string `json:"field,omitempty"`
+	// ExistingURL contains the URL which a requested key is already in use by.
+	ExistingURL string `json:"existing_url,omitempty"`
+}
+
+// linkBatch accumulates link records to be written to the URL database in a single batch.
+// Keys claimed by earlier items of the batch are taken into account by later ones.
+type linkBatch struct {
+	urlDatabase URLDatabase
+	batch       *storage.Batch
+	pending     map[string]*LinkRecord
+}
+
+// newLinkBatch creates an empty link batch.
+func newLinkBatch(urlDatabase URLDatabase) *linkBatch {
+	return &linkBatch{
+		urlDatabase: urlDatabase,
+		batch:       new(storage.Batch),
+		pending:     map[string]*LinkRecord{},
+	}
+}
+
+// claim adds the record of a URL key to the batch unless the key is already present, either in
+// the batch or in the database, and has not expired. The caller must hold urlKeyMutex.
+func (b *linkBatch) claim(URLKey []byte, record *LinkRecord) (*LinkRecord, error) {
+	if pendingRecord, ok := b.pending[string(URLKey)]; ok {
+		return pendingRecord, nil
+	}
+
+	storedRecord, _, err := readLinkRecord(b.urlDatabase, URLKey)
+	if err == nil {
+		// An expired key which has not been swept yet is free to be reused, once the entries
+		// of its link are deleted along with it.
+		if !storedRecord.IsExpired(time.Now()) {
+			return storedRecord, nil
+		}
+		if err = deleteLinkEntries(b.urlDatabase, b.batch, URLKey); err != nil {
+			return nil, err
+		}
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+
+	value, err := record.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	b.batch.Put(URLKey, value)
+	b.pending[string(URLKey)] = record
+	return record, nil
+}
+
+// ShortenBatch implements the logic for the /shorten/batch route. The request body holds either
+// a JSON array of shorten requests or a stream of newline delimited JSON shorten requests.
+// Items are shortened independently, so that a failing item does not fail the others, and the
+// links are written to the URL database at once.
+func (c *ShortenController) ShortenBatch(context *gin.Context) {
+	body := http.MaxBytesReader(context.Writer, context.Request.Body, MaxShortenBatchBodySize)
+	shortenRequests, err := decodeShortenBatch(body)
+	if isBodyTooLarge(err) {
+		respondWithError(context, NewAPIError(http.StatusRequestEntityTooLarge, ErrorCodeBatchTooLarge, ErrBatchBodyTooLarge.Error()))
+		return
+	}
+	if err == ErrBatchTooLarge {
+		respondWithError(context, NewAPIError(http.StatusBadRequest, ErrorCodeBatchTooLarge, err.Error()))
+		return
+	}
+	if err != nil {
+		respondWithError(context, errBinding(err, nil))
+		return
+	}
+
+	prefix := c.URLPrefixResolver.Prefix(context.Request)
+	principal := requestPrincipal(context)
+	now := time.Now()
+	results := make([]ShortenBatchResult, len(shortenRequests))
+	outcomes := make([]string, len(shortenRequests))
+
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	batch := newLinkBatch(c.URLDatabase)
+
+	for index, shortenRequest := range shortenRequests {
+		results[index], outcomes[index] = c.shortenBatchItem(context, batch, shortenRequest, principal, now)
+		results[index].Index = index
+		if results[index].Key != "" {
+			results[index].ShortenedURL = fmt.Sprintf("%s/%s", prefix, results[index].Key)
+		}
+	}
+
+	// Outcomes are only counted once the batch is written, as none of its links are otherwise.
+	if err = c.URLDatabase.Write(batch.batch); err != nil {
+		for range outcomes {
+			c.Metrics.RecordShorten(ShortenOutcomeError)
+		}
+		respondWithError(context, errInternal(err))
+		return
+	}
+	for _, outcome := range outcomes {
+		c.Metrics.RecordShorten(outcome)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Key == "" {
+			failed++
+		}
+	}
+	addLogFields(context, "items", len(results), "failed", failed)
+
+	context.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+// shortenBatchItem shortens one item of a batch request, adding its link to the batch, and
+// returns its result along with its outcome.
+func (c *ShortenController) shortenBatchItem(context *gin.Context, batch *linkBatch, shortenRequest json.RawMessage, principal *Principal, now time.Time) (ShortenBatchResult, string) {
+	var item ShortenRequest
+
+	err := json.Unmarshal(shortenRequest, &item)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&item)
+	}
+	if err != nil {
+		return c.failedBatchItem(context, errBinding(err, &item))
+	}
+
+	record, err := c.newLinkRecord(&item, principal, now)
+	if err != nil {
+		return c.failedBatchItem(context, shortenError(err))
+	}
+
+	outcome := ShortenOutcomeExisting
+	URLKey, err := c.claimURLKey(&item, record, func(URLKey []byte, record *LinkRecord) (*LinkRecord, error) {
+		claimedRecord, err := batch.claim(URLKey, record)
+		if claimedRecord == record {
+			outcome = ShortenOutcomeCreated
+		}
+		return claimedRecord, err
+	})
+	if err != nil {
+		return c.failedBatchItem(context, shortenError(err))
+	}
+
+	return ShortenBatchResult{Status: http.StatusOK, Key: URLKey}, outcome
+}
+
+// failedBatchItem describes the failure of an item of a batch request, along with its outcome,
+// logging server errors, as they do not fail the request itself.
+func (c *ShortenController) failedBatchItem(context *gin.Context, apiErr *APIError) (ShortenBatchResult, string) {
+	if apiErr.Status >= http.StatusInternalServerError {
+		requestLogger(context).Error("unable to shorten batch item", "error", apiErr)
+	}
+
+	existingURL, _ := apiErr.Details["existing_url"].(string)
+	return ShortenBatchResult{
+		Status:      apiErr.Status,
+		Code:        apiErr.Code,
+		Error:       apiErr.Message,
+		Field:       apiErr.Field,
+		ExistingURL: existingURL,
+	}, shortenOutcome(apiErr)
+}
+
+// decodeShortenBatch splits a batch request body into its items, which are decoded separately
+// so that a malformed item only fails itself. The body holds either a JSON array of items or
+// newline delimited JSON items. Splitting stops as soon as the batch holds too many items.
+func decodeShortenBatch(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	items := &batchItems{}
+
+	var err error
+	if isJSONArray(reader) {
+		err = splitJSONArray(reader, items)
+	} else {
+		err = splitJSONLines(reader, items)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return items.items, nil
+}
+
+// batchItems accumulates the items of a batch request, up to MaxShortenBatchSize of them.
+type batchItems struct {
+	items []json.RawMessage
+}
+
+// add appends an item, stripped of surrounding whitespace, to the batch.
+func (b *batchItems) add(item []byte) error {
+	if len(b.items) == MaxShortenBatchSize {
+		return ErrBatchTooLarge
+	}
+	b.items = append(b.items, bytes.TrimSpace(item))
+	return nil
+}
+
+// splitJSONLines splits newline delimited JSON into its lines, skipping blank lines.
+func splitJSONLines(reader *bufio.Reader, items *batchItems) error {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if addErr := items.add(line); addErr != nil {
+				return addErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitJSONArray splits a JSON array into its elements without decoding them, by finding the
+// commas which are neither nested in an element nor in a string. An element which is not valid
+// JSON is thus left for its own decoding to fail. The array must be terminated.
+func splitJSONArray(reader *bufio.Reader, items *batchItems) error {
+	// The opening bracket has been found by isJSONArray, past any leading whitespace.
+	if _, err := reader.ReadBytes('['); err != nil {
+		return err
+	}
+
+	var item []byte
+	depth := 0
+	separated := false
+	inString, escaped := false, false
+
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			item = append(item, c)
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == ',' && depth == 0:
+			if err = items.add(item); err != nil {
+				return err
+			}
+			item, separated = nil, true
+			continue
+		case c == ']' && depth == 0:
+			// An empty array holds no items, whereas an empty element is malformed.
+			if separated || len(bytes.TrimSpace(item)) > 0 {
+				return items.add(item)
+			}
+			return nil
+		case (c == '}' || c == ']') && depth > 0:
+			depth--
+		}
+		item = append(item, c)
+	}
+}
+
+// isBodyTooLarge determines whether reading a request body failed because it exceeded the limit
+// of http.MaxBytesReader, whose error can only be told apart by its message before Go 1.19.
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+// isJSONArray determines whether the JSON held by a reader starts with an array, without consuming it.
+func isJSONArray(reader *bufio.Reader) bool {
+	for offset := 1; ; offset++ {
+		peeked, err := reader.Peek(offset)
+		if err != nil {
+			return false
+		}
+		switch peeked[offset-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("/shorten/batch", func() {
+	const (
+		exampleUrl     = "https://en.wikipedia.org/wiki/URL_shortening"
+		computedUrlKey = "oROh-p8o"
+	)
+
+	var urlDatabase *storage.Memory
+	var writer *httptest.ResponseRecorder
+	var requestBody string
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		writer = httptest.NewRecorder()
+		requestBody = "[]"
+	})
+
+	JustBeforeEach(func() {
+		router, _ := initializeRouter(urlDatabase, DefaultConfig())
+		request, _ := http.NewRequest("POST", "/shorten/batch", strings.NewReader(requestBody))
+		router.ServeHTTP(writer, request)
+	})
+
+	results := func() []ShortenBatchResult {
+		content := struct {
+			Results []ShortenBatchResult `json:"results"`
+		}{}
+		Expect(json.Unmarshal(writer.Body.Bytes(), &content)).To(Succeed())
+		return content.Results
+	}
+
+	storedURL := func(key string) string {
+		record, _, err := readLinkRecord(urlDatabase, []byte(key))
+		Expect(err).NotTo(HaveOccurred())
+		return record.URL
+	}
+
+	Context("and the items are given as a JSON array", func() {
+		BeforeEach(func() {
+			requestBody = `[
+				{"url": "` + exampleUrl + `"},
+				{"url": "https://example.com/", "key": "example"}
+			]`
+		})
+
+		It("returns a 200", func() {
+			Expect(writer.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns the shortened URL of every item", func() {
+			Expect(results()).To(Equal([]ShortenBatchResult{
+				{Index: 0, Status: http.StatusOK, Key: computedUrlKey, ShortenedURL: DefaultURLPrefix + "/" + computedUrlKey},
+				{Index: 1, Status: http.StatusOK, Key: "example", ShortenedURL: DefaultURLPrefix + "/example"},
+			}))
+		})
+
+		It("stores every item", func() {
+			Expect(storedURL(computedUrlKey)).To(Equal(exampleUrl))
+			Expect(storedURL("example")).To(Equal("https://example.com/"))
+		})
+	})
+
+	Context("and the items are given as newline delimited JSON", func() {
+		BeforeEach(func() {
+			requestBody = `{"url": "` + exampleUrl + `"}` + "\n" + `{"url": "https://example.com/", "key": "example"}` + "\n"
+		})
+
+		It("shortens every item", func() {
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(results()).To(HaveLen(2))
+			Expect(storedURL("example")).To(Equal("https://example.com/"))
+		})
+	})
+
+	Context("and some items are invalid", func() {
+		BeforeEach(func() {
+			Expect(writeLinkRecord(urlDatabase, []byte("taken"), NewLinkRecord("https://taken.example/", time.Now(), time.Time{}))).To(Succeed())
+
+			requestBody = `[
+				{"url": "javascript:alert(1)"},
+				{"url": "https://example.com/", "key": "sh/orten"},
+				{"url": "https://example.com/", "key": "taken"},
+				{"url": "https://example.com/", "redirect_type": 303},
+				{"url": 5},
+				{"url": "https://example.com/", "key": "example"}
+			]`
+		})
+
+		It("reports the failure of each invalid item", func() {
+			itemResults := results()
+			Expect(itemResults).To(HaveLen(6))
+
+			Expect(itemResults[0].Status).To(Equal(http.StatusBadRequest))
+			Expect(itemResults[1].Status).To(Equal(http.StatusBadRequest))
+			Expect(itemResults[1].Field).To(Equal("key"))
+			Expect(itemResults[1].Code).To(Equal(ErrorCodeKeyInvalidCharacters))
+			Expect(itemResults[2].Status).To(Equal(http.StatusConflict))
+			Expect(itemResults[2].Code).To(Equal(ErrorCodeKeyInUse))
+			Expect(itemResults[2].ExistingURL).To(Equal("https://taken.example/"))
+			Expect(itemResults[3].Status).To(Equal(http.StatusBadRequest))
+			Expect(itemResults[3].Code).To(Equal(ErrorCodeInvalidField))
+			Expect(itemResults[3].Field).To(Equal("redirect_type"))
+			Expect(itemResults[4].Status).To(Equal(http.StatusBadRequest))
+			Expect(itemResults[4].Code).To(Equal(ErrorCodeMalformedRequest))
+
+			for _, result := range itemResults[:5] {
+				Expect(result.Error).NotTo(BeEmpty())
+				Expect(result.Key).To(BeEmpty())
+			}
+		})
+
+		It("still shortens the valid items", func() {
+			Expect(results()[5].Status).To(Equal(http.StatusOK))
+			Expect(storedURL("example")).To(Equal("https://example.com/"))
+			Expect(storedURL("taken")).To(Equal("https://taken.example/"))
+		})
+	})
+
+	Context("and some items are not valid JSON", func() {
+		BeforeEach(func() {
+			requestBody = `[
+				{"url": "https://example.com/", "key": "first"},
+				{"url": },
+				{"url": "https://example.com/", "key": "a,]b"},
+				{"url": "https://example.com/"} x,
+			]`
+		})
+
+		It("reports the failure of each malformed item and shortens the others", func() {
+			Expect(writer.Code).To(Equal(http.StatusOK))
+
+			itemResults := results()
+			Expect(itemResults).To(HaveLen(5))
+			Expect(itemResults[0].Status).To(Equal(http.StatusOK))
+			Expect(itemResults[1].Code).To(Equal(ErrorCodeMalformedRequest))
+			Expect(itemResults[2].Code).To(Equal(ErrorCodeKeyInvalidCharacters))
+			Expect(itemResults[3].Code).To(Equal(ErrorCodeMalformedRequest))
+			Expect(itemResults[4].Code).To(Equal(ErrorCodeMalformedRequest))
+			Expect(storedURL("first")).To(Equal("https://example.com/"))
+		})
+	})
+
+	Context("and some lines of newline delimited JSON are not valid JSON", func() {
+		BeforeEach(func() {
+			requestBody = `{"url": "https://example.com/", "key": "first"}` + "\n" +
+				`{"url": ` + "\n\n" +
+				`{"url": "https://example.com/", "key": "second"}`
+		})
+
+		It("reports the failure of each malformed line and shortens the others", func() {
+			Expect(writer.Code).To(Equal(http.StatusOK))
+
+			itemResults := results()
+			Expect(itemResults).To(HaveLen(3))
+			Expect(itemResults[1].Status).To(Equal(http.StatusBadRequest))
+			Expect(itemResults[1].Code).To(Equal(ErrorCodeMalformedRequest))
+			Expect(storedURL("first")).To(Equal("https://example.com/"))
+			Expect(storedURL("second")).To(Equal("https://example.com/"))
+		})
+	})
+
+	Context("and items of the batch claim the same key", func() {
+		BeforeEach(func() {
+			requestBody = `[
+				{"url": "https://example.com/", "key": "example"},
+				{"url": "https://example.org/", "key": "example"},
+				{"url": "https://example.com/", "key": "example"}
+			]`
+		})
+
+		It("takes earlier items of the batch into account", func() {
+			itemResults := results()
+			Expect(itemResults[0].Status).To(Equal(http.StatusOK))
+			Expect(itemResults[1].Status).To(Equal(http.StatusConflict))
+			Expect(itemResults[1].ExistingURL).To(Equal("https://example.com/"))
+			Expect(itemResults[2].Status).To(Equal(http.StatusOK))
+			Expect(storedURL("example")).To(Equal("https://example.com/"))
+		})
+	})
+
+	Context("and an item reuses the key of an expired link", func() {
+		BeforeEach(func() {
+			expiresAt := time.Now().Add(-time.Minute)
+			Expect(writeLinkRecord(urlDatabase, []byte("example"), NewLinkRecord("https://old.example/", expiresAt, expiresAt))).To(Succeed())
+			Expect(urlDatabase.Put(legacyExpiryKey([]byte("example")), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+			Expect(RecordClick(urlDatabase, ClickEvent{Key: "example", Timestamp: expiresAt})).To(Succeed())
+
+			requestBody = `[{"url": "https://example.com/", "key": "example"}]`
+		})
+
+		It("does not let the new link inherit the entries of the expired link", func() {
+			Expect(results()[0].Status).To(Equal(http.StatusOK))
+			Expect(storedURL("example")).To(Equal("https://example.com/"))
+
+			statistics, err := GetClickStatistics(urlDatabase, "example")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(statistics.TotalClicks).To(Equal(0))
+			Expect(urlDatabase.Has(legacyExpiryKey([]byte("example")))).To(BeFalse())
+		})
+	})
+
+	Context("and the body is malformed", func() {
+		BeforeEach(func() {
+			requestBody = `[{"url": "https://example.com/"}`
+		})
+
+		It("returns a 400", func() {
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("and the batch holds too many items", func() {
+		BeforeEach(func() {
+			requestBody = "[" + strings.Repeat(`{"url": "https://example.com/"},`, MaxShortenBatchSize) + `{"url": "https://example.com/"}]`
+		})
+
+		It("returns a 400", func() {
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("and the body is too large", func() {
+		BeforeEach(func() {
+			requestBody = `[{"url": "https://example.com/` + strings.Repeat("a", MaxShortenBatchBodySize) + `"}]`
+		})
+
+		It("returns a 413", func() {
+			Expect(writer.Code).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeBatchTooLarge))
+		})
+	})
+
+	Context("and writing the batch fails", func() {
+		It("returns a 500", func() {
+			ctrl := gomock.NewController(GinkgoT())
+			mockURLDatabase := mocks.NewMockURLDatabase(ctrl)
+			mockURLDatabase.EXPECT().Get(gomock.Any()).Return(nil, storage.ErrNotFound).AnyTimes()
+			mockURLDatabase.EXPECT().Write(gomock.Any()).Return(errors.New("failed to write batch"))
+
+			router, _ := initializeRouter(mockURLDatabase, DefaultConfig())
+			request, _ := http.NewRequest("POST", "/shorten/batch", strings.NewReader(`[{"url": "https://example.com/"}]`))
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// DefaultCacheSize defines the number of keys held by the read cache by default.
+	DefaultCacheSize = 10000
+	// DefaultCacheTTL defines how long values are cached by default.
+	DefaultCacheTTL = time.Minute
+	// DefaultCacheNegativeTTL defines how long missing keys are cached by default.
+	DefaultCacheNegativeTTL = 10 * time.Second
+)
+
+// CacheStatistics summarizes the use of a read cache.
+type CacheStatistics struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// cacheEntry is the cached value of a key, or the fact that the key is missing.
+type cacheEntry struct {
+	key       string
+	value     []byte
+	found     bool
+	expiresAt time.Time
+}
+
+// CachedURLDatabase is a URLDatabase caching the values read from another URLDatabase, so that
+// frequently read keys, such as those of popular links, are seldom read from storage. The least
+// recently used keys are evicted once the cache is full, and cached values expire after a TTL.
+//
+// Keys written through the cache are invalidated, so the cache is only stale when the
+// underlying database is written to by another process, for at most the TTL.
+type CachedURLDatabase struct {
+	URLDatabase URLDatabase
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	recency *list.List
+	// loading counts the reads of each key in progress, and stale holds the keys written
+	// during those reads, whose results are then not cached.
+	loading    map[string]int
+	stale      map[string]bool
+	statistics CacheStatistics
+}
+
+// NewCachedURLDatabase creates a cache of at most size keys in front of a URL database. Values
+// are cached for the TTL and missing keys for the negative TTL, zero disabling negative caching.
+func NewCachedURLDatabase(urlDatabase URLDatabase, size int, ttl, negativeTTL time.Duration) *CachedURLDatabase {
+	return &CachedURLDatabase{
+		URLDatabase: urlDatabase,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     map[string]*list.Element{},
+		recency:     list.New(),
+		loading:     map[string]int{},
+		stale:       map[string]bool{},
+	}
+}
+
+// Statistics returns the number of hits, misses and evictions of the cache so far, along with
+// the number of keys it holds.
+func (d *CachedURLDatabase) Statistics() CacheStatistics {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	statistics := d.statistics
+	statistics.Entries = d.recency.Len()
+	return statistics
+}
+
+// Close closes the underlying database.
+func (d *CachedURLDatabase) Close() error {
+	return d.URLDatabase.Close()
+}
+
+// Delete removes a key from the underlying database and the cache.
+func (d *CachedURLDatabase) Delete(key []byte) error {
+	err := d.URLDatabase.Delete(key)
+	d.invalidate(string(key))
+	return err
+}
+
+// Get retrieves the value of a key from the cache or, failing that, the underlying database.
+func (d *CachedURLDatabase) Get(key []byte) ([]byte, error) {
+	cacheKey := string(key)
+
+	d.mutex.Lock()
+	if element, ok := d.entries[cacheKey]; ok {
+		entry := element.Value.(*cacheEntry)
+		if d.now().Before(entry.expiresAt) {
+			d.recency.MoveToFront(element)
+			d.statistics.Hits++
+			d.mutex.Unlock()
+
+			if !entry.found {
+				return nil, storage.ErrNotFound
+			}
+			return append([]byte{}, entry.value...), nil
+		}
+		d.remove(element)
+	}
+	d.statistics.Misses++
+	d.loading[cacheKey]++
+	d.mutex.Unlock()
+
+	value, err := d.URLDatabase.Get(key)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.stale[cacheKey] {
+		if err == nil {
+			d.store(cacheKey, append([]byte{}, value...), true, d.ttl)
+		} else if err == storage.ErrNotFound {
+			d.store(cacheKey, nil, false, d.negativeTTL)
+		}
+	}
+	d.loading[cacheKey]--
+	if d.loading[cacheKey] == 0 {
+		delete(d.loading, cacheKey)
+		delete(d.stale, cacheKey)
+	}
+
+	return value, err
+}
+
+// Has determines whether a key is present in the underlying database, which is always queried
+// so that readiness checks reach storage.
+func (d *CachedURLDatabase) Has(key []byte) (bool, error) {
+	return d.URLDatabase.Has(key)
+}
+
+// NewIterator creates an iterator over the underlying database.
+func (d *CachedURLDatabase) NewIterator(prefix []byte) storage.Iterator {
+	return d.URLDatabase.NewIterator(prefix)
+}
+
+// Put stores the value of a key in the underlying database, invalidating its cached value.
+func (d *CachedURLDatabase) Put(key, value []byte) error {
+	err := d.URLDatabase.Put(key, value)
+	d.invalidate(string(key))
+	return err
+}
+
+// Write applies a batch to the underlying database, invalidating the cached values of its keys.
+func (d *CachedURLDatabase) Write(batch *storage.Batch) error {
+	err := d.URLDatabase.Write(batch)
+	batch.Replay(func(key, _ []byte) {
+		d.invalidate(string(key))
+	}, func(key []byte) {
+		d.invalidate(string(key))
+	})
+	return err
+}
+
+// invalidate removes a key from the cache once it has been written. A read of the key in
+// progress may have returned the previous value, so its result is not cached either.
+func (d *CachedURLDatabase) invalidate(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if element, ok := d.entries[key]; ok {
+		d.remove(element)
+	}
+	if d.loading[key] > 0 {
+		d.stale[key] = true
+	}
+}
+
+// store caches the value of a key for a TTL, evicting the least recently used keys once the
+// cache is full. The caller must hold the lock.
+func (d *CachedURLDatabase) store(key string, value []byte, found bool, ttl time.Duration) {
+	if ttl <= 0 || d.size <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{key: key, value: value, found: found, expiresAt: d.now().Add(ttl)}
+	if element, ok := d.entries[key]; ok {
+		element.Value = entry
+		d.recency.MoveToFront(element)
+		return
+	}
+	d.entries[key] = d.recency.PushFront(entry)
+
+	for d.recency.Len() > d.size {
+		d.remove(d.recency.Back())
+		d.statistics.Evictions++
+	}
+}
+
+// remove removes an entry from the cache. The caller must hold the lock.
+func (d *CachedURLDatabase) remove(element *list.Element) {
+	d.recency.Remove(element)
+	delete(d.entries, element.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("CachedURLDatabase", func() {
+	var mockURLDatabase *mocks.MockURLDatabase
+	var cache *CachedURLDatabase
+	var now time.Time
+
+	key := []byte("docs")
+	value := []byte(`{"url":"https://example.com/"}`)
+
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockURLDatabase = mocks.NewMockURLDatabase(ctrl)
+		cache = NewCachedURLDatabase(mockURLDatabase, 2, time.Minute, 10*time.Second)
+
+		now = time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+	})
+
+	It("should read a key from the underlying database once", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(value, nil).Times(1)
+
+		for i := 0; i < 3; i++ {
+			Expect(cache.Get(key)).To(Equal(value))
+		}
+		Expect(cache.Statistics()).To(Equal(CacheStatistics{Hits: 2, Misses: 1, Entries: 1}))
+	})
+
+	It("should not share cached values with callers", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(append([]byte{}, value...), nil)
+
+		readValue, _ := cache.Get(key)
+		readValue[0] = 'X'
+		Expect(cache.Get(key)).To(Equal(value))
+	})
+
+	It("should cache missing keys for the negative TTL", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(nil, storage.ErrNotFound).Times(2)
+
+		_, err := cache.Get(key)
+		Expect(err).To(MatchError(storage.ErrNotFound))
+		_, err = cache.Get(key)
+		Expect(err).To(MatchError(storage.ErrNotFound))
+
+		now = now.Add(10 * time.Second)
+		_, err = cache.Get(key)
+		Expect(err).To(MatchError(storage.ErrNotFound))
+	})
+
+	It("should not cache missing keys without a negative TTL", func() {
+		cache = NewCachedURLDatabase(mockURLDatabase, 2, time.Minute, 0)
+		mockURLDatabase.EXPECT().Get(key).Return(nil, storage.ErrNotFound).Times(2)
+
+		cache.Get(key)
+		cache.Get(key)
+	})
+
+	It("should not cache failed reads", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(nil, storage.ErrClosed).Times(2)
+
+		cache.Get(key)
+		_, err := cache.Get(key)
+		Expect(err).To(MatchError(storage.ErrClosed))
+	})
+
+	It("should read values again once their TTL has elapsed", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(value, nil).Times(2)
+
+		cache.Get(key)
+		now = now.Add(59 * time.Second)
+		cache.Get(key)
+		now = now.Add(time.Second)
+		cache.Get(key)
+	})
+
+	It("should evict the least recently used key once full", func() {
+		for _, otherKey := range []string{"a", "b", "c"} {
+			mockURLDatabase.EXPECT().Get([]byte(otherKey)).Return(value, nil).Times(1)
+		}
+		mockURLDatabase.EXPECT().Get([]byte("b")).Return(value, nil).Times(1)
+
+		cache.Get([]byte("a"))
+		cache.Get([]byte("b"))
+		cache.Get([]byte("a"))
+		cache.Get([]byte("c"))
+		cache.Get([]byte("a"))
+		cache.Get([]byte("b"))
+
+		Expect(cache.Statistics()).To(Equal(CacheStatistics{Hits: 2, Misses: 4, Evictions: 2, Entries: 2}))
+	})
+
+	Context("when a key is written", func() {
+		BeforeEach(func() {
+			mockURLDatabase.EXPECT().Get(key).Return(value, nil).Times(2)
+			cache.Get(key)
+		})
+
+		AfterEach(func() {
+			cache.Get(key)
+		})
+
+		It("should invalidate the key when it is put", func() {
+			mockURLDatabase.EXPECT().Put(key, value).Return(nil)
+			Expect(cache.Put(key, value)).To(Succeed())
+		})
+
+		It("should invalidate the key when it is deleted", func() {
+			mockURLDatabase.EXPECT().Delete(key).Return(nil)
+			Expect(cache.Delete(key)).To(Succeed())
+		})
+
+		It("should invalidate the keys of a batch", func() {
+			batch := new(storage.Batch)
+			batch.Delete(key)
+			mockURLDatabase.EXPECT().Write(batch).Return(nil)
+			Expect(cache.Write(batch)).To(Succeed())
+		})
+	})
+
+	It("should not cache a value read while the key is written", func() {
+		mockURLDatabase.EXPECT().Put(key, value).Return(nil)
+		mockURLDatabase.EXPECT().Get(key).DoAndReturn(func(key []byte) ([]byte, error) {
+			Expect(cache.Put(key, value)).To(Succeed())
+			return nil, storage.ErrNotFound
+		})
+		mockURLDatabase.EXPECT().Get(key).Return(value, nil).Times(1)
+
+		cache.Get(key)
+		Expect(cache.Get(key)).To(Equal(value))
+		Expect(cache.Get(key)).To(Equal(value))
+	})
+
+	It("should query the underlying database for the presence of keys", func() {
+		mockURLDatabase.EXPECT().Has(key).Return(true, nil).Times(2)
+
+		Expect(cache.Has(key)).To(BeTrue())
+		Expect(cache.Has(key)).To(BeTrue())
+	})
+
+	It("should expose its statistics as metrics", func() {
+		mockURLDatabase.EXPECT().Get(key).Return(value, nil)
+		cache.Get(key)
+		cache.Get(key)
+
+		router, err := initializeRouter(cache, DefaultConfig())
+		Expect(err).NotTo(HaveOccurred())
+
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/metrics", nil)
+		router.ServeHTTP(writer, request)
+
+		Expect(writer.Body.String()).To(ContainSubstring(`bajo_cache_requests_total{result="hit"} 1`))
+		Expect(writer.Body.String()).To(ContainSubstring(`bajo_cache_requests_total{result="miss"} 1`))
+		Expect(writer.Body.String()).To(ContainSubstring(`bajo_cache_entries 1`))
+	})
+
+	It("should serve redirects of links invalidated through the API", func() {
+		urlDatabase := storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+		cache = NewCachedURLDatabase(urlDatabase, 10, time.Minute, time.Minute)
+
+		config := DefaultConfig()
+		config.AdminAPIKey = "admin-secret"
+		router, err := initializeRouter(cache, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		send := func(method, path string) int {
+			writer := httptest.NewRecorder()
+			request, _ := http.NewRequest(method, path, nil)
+			request.Header.Set("Authorization", "Bearer admin-secret")
+			router.ServeHTTP(writer, request)
+			return writer.Code
+		}
+
+		Expect(send("GET", "/docs")).To(Equal(http.StatusNotFound))
+		Expect(send("GET", "/shorten?key=docs&url="+url.QueryEscape("https://example.com/"))).To(Equal(http.StatusOK))
+		Expect(send("GET", "/docs")).To(Equal(http.StatusFound))
+		Expect(send("DELETE", "/api/links/docs")).To(Equal(http.StatusNoContent))
+		Expect(send("GET", "/docs")).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandUsages maps the commands other than serving to the arguments they take.
+var commandUsages = map[string]string{
+	"migrate": "",
+	"backup":  "FILE",
+	"restore": "FILE",
+	"export":  "FILE",
+	"import":  "FILE",
+	"shorten": "URL",
+	"get":     "KEY",
+	"delete":  "KEY",
+	"list":    "",
+	"stats":   "KEY",
+	"compact": "",
+}
+
+// clientCommands lists the commands which can be sent to a server through its HTTP API, instead
+// of operating on the URL database, which a running server holds locked.
+var clientCommands = map[string]bool{
+	"backup":  true,
+	"export":  true,
+	"import":  true,
+	"shorten": true,
+	"get":     true,
+	"delete":  true,
+	"list":    true,
+	"stats":   true,
+	"compact": true,
+}
+
+// commandOutput receives the results printed by commands.
+var commandOutput io.Writer = os.Stdout
+
+// commandFlags holds the flags specific to commands, which are defined along with those of
+// the configuration.
+type commandFlags struct {
+	// server is the URL of the server to which the command is sent, if any.
+	server string
+	// apiKey authenticates the command with the server.
+	apiKey string
+	// transfer holds the options of the export and import commands.
+	transfer ImportOptions
+	// shorten holds the request of the shorten command.
+	shorten ShortenRequest
+	ttl     time.Duration
+	tags    string
+}
+
+// define defines the flags of a command on a flag set.
+func (f *commandFlags) define(flagSet *flag.FlagSet, command string) {
+	if clientCommands[command] {
+		flagSet.StringVar(&f.server, "server", "", "URL of a running server to send the command to, instead of opening the URL database")
+		flagSet.StringVar(&f.apiKey, "api-key", "", "API key with which the command is sent to the server")
+	}
+
+	switch command {
+	case "export":
+		flagSet.StringVar(&f.transfer.Format, "format", "", "format of the file: csv or jsonl, which is the default unless the file name ends in .csv")
+	case "import":
+		flagSet.StringVar(&f.transfer.Format, "format", "", "format of the file: csv or jsonl, which is the default unless the file name ends in .csv")
+		flagSet.StringVar(&f.transfer.Conflict, "conflict", ConflictFail, "handling of links whose key is already in use: skip, overwrite or fail")
+		flagSet.BoolVar(&f.transfer.DryRun, "dry-run", false, "report the outcome of the import without writing anything")
+	case "shorten":
+		flagSet.StringVar(&f.shorten.Key, "key", "", "custom key of the link")
+		flagSet.DurationVar(&f.ttl, "ttl", 0, "how long the link redirects, 0 meaning forever")
+		flagSet.IntVar(&f.shorten.RedirectType, "redirect-type", 0, "HTTP status code with which the link redirects: 301, 302, 307 or 308")
+		flagSet.StringVar(&f.tags, "tags", "", "comma-separated labels attached to the link")
+	}
+}
+
+// shortenRequest returns the request of the shorten command for a URL.
+func (f *commandFlags) shortenRequest(URL string) *ShortenRequest {
+	shortenRequest := f.shorten
+	shortenRequest.URL = URL
+	shortenRequest.Tags = splitList(f.tags)
+	if f.ttl > 0 {
+		ttlSeconds := int64(f.ttl.Round(time.Second) / time.Second)
+		shortenRequest.TTLSeconds = &ttlSeconds
+	}
+	return &shortenRequest
+}
+
+// APIClientError is returned by an APIClient when the service responds with an error.
+type APIClientError struct {
+	// Status is the HTTP status code of the response.
+	Status int `json:"-"`
+	// Code identifies the error, as in error responses.
+	Code string `json:"code"`
+	// Message describes the error.
+	Message string `json:"message"`
+	// Body holds the error response, which may hold further members specific to the error.
+	Body []byte `json:"-"`
+}
+
+// Error describes the error as the service did.
+func (e *APIClientError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// APIClient sends requests to the HTTP API of the service, served either by a running server or
+// by a router in process.
+type APIClient struct {
+	// BaseURL is the URL of the service, to which the paths of routes are appended.
+	BaseURL string
+	// APIKey authenticates the requests, unless empty.
+	APIKey string
+	// HTTPClient sends the requests.
+	HTTPClient *http.Client
+}
+
+// NewRemoteAPIClient creates a client of the server at an HTTP or HTTPS URL.
+func NewRemoteAPIClient(serverURL, apiKey string) (*APIClient, error) {
+	parsedURL, err := url.Parse(serverURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q: the URL must be an absolute HTTP or HTTPS URL", serverURL)
+	}
+	return &APIClient{
+		BaseURL:    strings.TrimSuffix(serverURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+// NewLocalAPIClient creates a client of a router serving a URL database in process, so that
+// commands operating on the URL database go through the same routes as those sent to a server.
+// The client authenticates as an admin with an API key generated for the router.
+func NewLocalAPIClient(urlDatabase URLDatabase, config *Config) (*APIClient, error) {
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+	apiKey := hex.EncodeToString(keyBytes)
+
+	localConfig := *config
+	localConfig.AdminAPIKey = apiKey
+	router, err := initializeRouter(urlDatabase, &localConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIClient{
+		BaseURL:    "http://bajo",
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Transport: handlerTransport{handler: router}},
+	}, nil
+}
+
+// handlerTransport is an http.RoundTripper serving requests with a handler in process.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip serves a request, returning the recorded response.
+func (t handlerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	response.Request = request
+	return response, nil
+}
+
+// Do sends a request to a route, returning the response when it is successful, which the caller
+// must close. Error responses are returned as an *APIClientError.
+func (c *APIClient) Do(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+
+	apiErr := &APIClientError{Status: response.StatusCode}
+	apiErr.Body, _ = io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if json.Unmarshal(apiErr.Body, apiErr) != nil || apiErr.Code == "" {
+		apiErr.Code = strconv.Itoa(response.StatusCode)
+		apiErr.Message = http.StatusText(response.StatusCode)
+	}
+	return nil, apiErr
+}
+
+// call sends a request to a route, encoding the request as JSON unless nil, and decodes the
+// JSON response into result unless the response is empty.
+func (c *APIClient) call(method, path string, request, result interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if request != nil {
+		content, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+		contentType = "application/json"
+	}
+
+	response, err := c.Do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Backup streams a backup archive of the URL database of the service.
+func (c *APIClient) Backup(writer io.Writer) error {
+	response, err := c.Do("GET", "/api/backup", "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// ExportLinks streams the export of the links of the service in a format.
+func (c *APIClient) ExportLinks(writer io.Writer, format string) error {
+	response, err := c.Do("GET", "/api/export?"+url.Values{"format": {format}}.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// ImportLinks streams links to the service, returning the summary of the import, which is also
+// returned along with the errors of imports which failed after links were read.
+func (c *APIClient) ImportLinks(reader io.Reader, options ImportOptions) (*ImportSummary, error) {
+	query := url.Values{"format": {options.Format}, "conflict": {options.Conflict}, "dry_run": {strconv.FormatBool(options.DryRun)}}
+	response, err := c.Do("POST", "/api/import?"+query.Encode(), transferContentTypes[options.Format], reader)
+
+	var apiErr *APIClientError
+	if errors.As(err, &apiErr) {
+		var errorBody struct {
+			Summary *ImportSummary `json:"summary"`
+		}
+		json.Unmarshal(apiErr.Body, &errorBody)
+		return errorBody.Summary, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	summary := &ImportSummary{}
+	if err = json.NewDecoder(response.Body).Decode(summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// runAPICommand runs a command operating on links through the HTTP API, printing the response
+// as JSON, and returns the exit code of the process. The list command prints a link per line.
+func runAPICommand(client *APIClient, command string, args []string, flags *commandFlags) int {
+	var result json.RawMessage
+	var err error
+
+	switch command {
+	case "shorten":
+		err = client.call("POST", "/shorten", flags.shortenRequest(args[0]), &result)
+	case "get":
+		err = client.call("GET", "/api/links/"+url.PathEscape(args[0]), nil, &result)
+	case "delete":
+		if err = client.call("DELETE", "/api/links/"+url.PathEscape(args[0]), nil, nil); err == nil {
+			logger.Info("deleted link", "key", args[0])
+		}
+	case "stats":
+		err = client.call("GET", "/"+url.PathEscape(args[0])+"/stats", nil, &result)
+	case "list":
+		err = listLinks(client)
+	case "compact":
+		start := time.Now()
+		if err = client.call("POST", "/api/compact", nil, nil); err == nil {
+			logger.Info("compacted URL database", "duration", time.Since(start).String())
+		}
+	}
+
+	if err != nil {
+		logger.Error(fmt.Sprintf("unable to %s", command), "error", err)
+		return 1
+	}
+
+	if result != nil {
+		printed := new(bytes.Buffer)
+		json.Indent(printed, result, "", "  ")
+		printed.WriteByte('\n')
+		commandOutput.Write(printed.Bytes())
+	}
+	return 0
+}
+
+// listLinks prints every link of the service as a line of JSON, fetching them a page at a time.
+func listLinks(client *APIClient) error {
+	after := ""
+	for {
+		query := url.Values{"limit": {strconv.Itoa(MaxLinkListLimit)}}
+		if after != "" {
+			query.Set("after", after)
+		}
+
+		var page struct {
+			Links []json.RawMessage `json:"links"`
+			Next  string            `json:"next"`
+		}
+		if err := client.call("GET", "/api/links?"+query.Encode(), nil, &page); err != nil {
+			return err
+		}
+
+		for _, link := range page.Links {
+			printed := new(bytes.Buffer)
+			json.Compact(printed, link)
+			printed.WriteByte('\n')
+			if _, err := commandOutput.Write(printed.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		after = page.Next
+	}
+}
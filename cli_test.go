@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Commands", func() {
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		commandOutput = output
+		DeferCleanup(func() { commandOutput = os.Stdout })
+	})
+
+	// printed decodes the JSON object printed by a command, then resets the output.
+	printed := func() map[string]interface{} {
+		content := map[string]interface{}{}
+		Expect(json.Unmarshal(output.Bytes(), &content)).To(Succeed())
+		output.Reset()
+		return content
+	}
+
+	Context("when operating on the URL database", func() {
+		var databasePath string
+
+		BeforeEach(func() {
+			databasePath = GinkgoT().TempDir()
+		})
+
+		command := func(args ...string) int {
+			return run(append([]string{args[0], "-database-path", databasePath}, args[1:]...))
+		}
+
+		It("should shorten, read, list and delete links", func() {
+			Expect(command("shorten", "-key", "docs", "-tags", "a,b", "-ttl", "1h", "https://example.com/docs")).To(Equal(0))
+			Expect(printed()).To(HaveKeyWithValue("shortened_url", DefaultURLPrefix+"/docs"))
+
+			Expect(command("shorten", "https://example.com/other")).To(Equal(0))
+			otherKey := strings.TrimPrefix(printed()["shortened_url"].(string), DefaultURLPrefix+"/")
+
+			Expect(command("get", "docs")).To(Equal(0))
+			link := printed()
+			Expect(link).To(HaveKeyWithValue("url", "https://example.com/docs"))
+			Expect(link).To(HaveKeyWithValue("tags", ConsistOf("a", "b")))
+			Expect(link).To(HaveKey("expires_at"))
+
+			Expect(command("stats", "docs")).To(Equal(0))
+			Expect(printed()).To(HaveKeyWithValue("total_clicks", BeNumerically("==", 0)))
+
+			Expect(command("list")).To(Equal(0))
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+			Expect(lines).To(ContainElement(ContainSubstring(`"key":"docs"`)))
+			Expect(lines).To(ContainElement(ContainSubstring(`"key":"` + otherKey + `"`)))
+			output.Reset()
+
+			Expect(command("delete", "docs")).To(Equal(0))
+			Expect(output.String()).To(BeEmpty())
+			Expect(command("get", "docs")).To(Equal(1))
+
+			Expect(command("compact")).To(Equal(0))
+		})
+
+		It("should exit with status 1 when the request fails", func() {
+			Expect(command("shorten", "-key", "api", "https://example.com/")).To(Equal(1))
+			Expect(command("shorten", "ftp://example.com/")).To(Equal(1))
+		})
+
+		It("should exit with status 1 while the URL database is held by a server", func() {
+			levelDB, err := storage.OpenLevelDB(databasePath, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer levelDB.Close()
+
+			Expect(command("get", "docs")).To(Equal(1))
+		})
+
+		It("should exit with status 2 with missing or extra arguments", func() {
+			Expect(command("get")).To(Equal(2))
+			Expect(command("list", "docs")).To(Equal(2))
+			Expect(command("migrate", "docs")).To(Equal(2))
+		})
+
+		It("should exit with status 2 with an unknown command", func() {
+			Expect(run([]string{"exprot", "-database-path", databasePath, "out.csv"})).To(Equal(2))
+			Expect(run([]string{"-database-path", databasePath, "out.csv"})).To(Equal(2))
+			Expect(run([]string{"serve", "-database-path", databasePath, "out.csv"})).To(Equal(2))
+		})
+	})
+
+	Context("when sent to a server", func() {
+		const adminAPIKey = "admin-secret"
+
+		var urlDatabase *storage.Memory
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+			DeferCleanup(urlDatabase.Close)
+
+			config := DefaultConfig()
+			config.AdminAPIKey = adminAPIKey
+			router, err := initializeRouter(urlDatabase, config)
+			Expect(err).NotTo(HaveOccurred())
+			server = httptest.NewServer(router)
+			DeferCleanup(server.Close)
+		})
+
+		command := func(args ...string) int {
+			return run(append([]string{args[0], "-server", server.URL, "-api-key", adminAPIKey}, args[1:]...))
+		}
+
+		It("should operate on the links of the server", func() {
+			Expect(command("shorten", "-key", "docs", "https://example.com/docs")).To(Equal(0))
+			Expect(printed()).To(HaveKeyWithValue("shortened_url", DefaultURLPrefix+"/docs"))
+
+			Expect(command("get", "docs")).To(Equal(0))
+			Expect(printed()).To(HaveKeyWithValue("url", "https://example.com/docs"))
+
+			Expect(command("list")).To(Equal(0))
+			Expect(output.String()).To(HavePrefix(`{"key":"docs",`))
+			output.Reset()
+
+			Expect(command("compact")).To(Equal(0))
+			Expect(command("delete", "docs")).To(Equal(0))
+			Expect(urlDatabase.Has([]byte("docs"))).To(BeFalse())
+		})
+
+		It("should export and import links through the server", func() {
+			Expect(writeLinkRecord(urlDatabase, []byte("docs"), NewLinkRecord("https://example.com/docs", time.Now(), time.Time{}))).To(Succeed())
+
+			exportPath := filepath.Join(GinkgoT().TempDir(), "links.csv")
+			Expect(command("export", exportPath)).To(Equal(0))
+			exported, err := os.ReadFile(exportPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(exported)).To(HavePrefix("key,url,"))
+
+			Expect(command("import", "-dry-run", exportPath)).To(Equal(1))
+			Expect(command("import", "-conflict", "overwrite", exportPath)).To(Equal(0))
+		})
+
+		It("should list links a page at a time", func() {
+			for i := 0; i < MaxLinkListLimit+1; i++ {
+				Expect(urlDatabase.Put([]byte(strings.Repeat("k", 1+i/26)+string(rune('a'+i%26))), []byte("https://example.com/"))).To(Succeed())
+			}
+
+			Expect(command("list")).To(Equal(0))
+			Expect(strings.Count(output.String(), "\n")).To(Equal(MaxLinkListLimit + 1))
+		})
+
+		It("should exit with status 1 when the server rejects the API key", func() {
+			Expect(run([]string{"list", "-server", server.URL})).To(Equal(1))
+			Expect(run([]string{"list", "-server", server.URL, "-api-key", "invalid"})).To(Equal(1))
+		})
+
+		It("should exit with status 2 with an invalid server URL", func() {
+			Expect(run([]string{"list", "-server", "localhost:8080"})).To(Equal(2))
+		})
+	})
+})
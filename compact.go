@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// ErrCompactionUnsupported is returned when compacting a database which cannot be compacted.
+var ErrCompactionUnsupported = errors.New("the URL database cannot be compacted")
+
+// CompactDatabase reclaims the space held by the deleted and replaced values of a database, such
+// as those of swept links. The database must be a storage database, rather than a wrapper of one.
+func CompactDatabase(urlDatabase URLDatabase) error {
+	compacter, ok := urlDatabase.(storage.Compacter)
+	if !ok {
+		return ErrCompactionUnsupported
+	}
+	return compacter.Compact()
+}
+
+// CompactController contains logic and data related to the /api/compact route.
+type CompactController struct {
+	// URLDatabase is the storage database, which is not measured nor cached, as compaction
+	// rewrites it without changing its content.
+	URLDatabase URLDatabase
+}
+
+// Compact implements the logic for the /api/compact route, which responds once the URL database
+// has been compacted.
+func (c *CompactController) Compact(context *gin.Context) {
+	start := time.Now()
+	if err := CompactDatabase(c.URLDatabase); err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	addLogFields(context, "compaction_duration", time.Since(start).String())
+	context.Status(http.StatusNoContent)
+}
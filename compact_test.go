@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Compaction", func() {
+	It("should not compact databases which cannot be compacted", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		Expect(CompactDatabase(mocks.NewMockURLDatabase(ctrl))).To(MatchError(ErrCompactionUnsupported))
+	})
+
+	Describe("/api/compact", func() {
+		var urlDatabase *storage.Memory
+		var cache *CachedURLDatabase
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+			DeferCleanup(func() { urlDatabase.Close() })
+			cache = NewCachedURLDatabase(urlDatabase, 10, DefaultCacheTTL, DefaultCacheNegativeTTL)
+		})
+
+		send := func(apiKey string) *httptest.ResponseRecorder {
+			config := DefaultConfig()
+			config.AdminAPIKey = "admin-secret"
+			router, err := initializeRouter(cache, config)
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/api/compact", nil)
+			request.Header.Set("Authorization", "Bearer "+apiKey)
+			router.ServeHTTP(writer, request)
+			return writer
+		}
+
+		It("should compact the database underlying the cache", func() {
+			Expect(send("admin-secret").Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("should report a failure to compact the database", func() {
+			Expect(urlDatabase.Close()).To(Succeed())
+			Expect(send("admin-secret").Code).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("should reject other clients", func() {
+			Expect(send("").Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
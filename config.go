@@ -0,0 +1,493 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ConfigFileEnvVar names the environment variable pointing to a configuration file.
+	ConfigFileEnvVar = "BAJO_CONFIG"
+
+	// DefaultListenAddress defines the address on which the service listens by default.
+	DefaultListenAddress = ":8080"
+
+	// DefaultMaxURLLength defines the default maximum length of a shortened URL.
+	DefaultMaxURLLength = 2048
+
+	// maxURLKeySize is the length of a Base 64 encoded SHA-256 hash, from which keys are generated.
+	maxURLKeySize = 43
+)
+
+// Config contains the settings of the service.
+//
+// Settings are resolved with the following precedence, from lowest to highest:
+// built-in defaults, the YAML configuration file, BAJO_* environment variables
+// and command-line flags.
+type Config struct {
+	// ListenAddress defines the address on which the HTTP server listens.
+	ListenAddress string `yaml:"listen_address"`
+	// DatabasePath defines the filepath of the URL database.
+	DatabasePath string `yaml:"database_path"`
+	// DatabaseBackend defines the storage backend of the URL database: leveldb, sqlite or memory.
+	DatabaseBackend string `yaml:"database_backend"`
+	// URLPrefix defines the prefix for shortened URLs.
+	URLPrefix string `yaml:"url_prefix"`
+	// URLPrefixFromRequest enables deriving the prefix for shortened URLs from requests,
+	// in which case URLPrefix is only used when the request carries no usable host.
+	URLPrefixFromRequest bool `yaml:"url_prefix_from_request"`
+	// TrustedProxies lists the IP addresses and CIDR networks of the reverse proxies
+	// whose X-Forwarded-* headers are honored.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// URLKeySize defines the number of characters in a generated URL key.
+	URLKeySize int `yaml:"url_key_size"`
+	// CustomKeySizeLimit determines the maximum size of a custom key.
+	CustomKeySizeLimit int `yaml:"custom_key_size_limit"`
+	// MinCustomKeySize determines the minimum size of a custom key.
+	MinCustomKeySize int `yaml:"min_custom_key_size"`
+	// CustomKeyCharacters describes the characters allowed in custom keys, using the
+	// syntax of a regular expression bracket expression such as "A-Za-z0-9_-".
+	CustomKeyCharacters string `yaml:"custom_key_characters"`
+	// ReservedKeys lists words which may not be used as custom keys, in addition to
+	// the names of the routes of the service.
+	ReservedKeys []string `yaml:"reserved_keys"`
+	// CaseInsensitiveKeys folds custom keys to lowercase when stored and looked up.
+	CaseInsensitiveKeys bool `yaml:"case_insensitive_keys"`
+	// AllowedSchemes lists the URL schemes which may be shortened.
+	AllowedSchemes []string `yaml:"allowed_schemes"`
+	// MaxURLLength determines the maximum length of a URL which may be shortened.
+	MaxURLLength int `yaml:"max_url_length"`
+	// ExpirySweepInterval defines how often expired URL keys are deleted, zero disabling deletion.
+	ExpirySweepInterval time.Duration `yaml:"expiry_sweep_interval"`
+	// RequireAPIKey restricts the creation of links to requests carrying a valid API key.
+	RequireAPIKey bool `yaml:"require_api_key"`
+	// AdminAPIKey defines a static API key granting administrative access, with which
+	// further API keys can be created. An empty value disables it.
+	AdminAPIKey string `yaml:"admin_api_key"`
+	// ShortenRateLimit defines how many requests per second each client may sustain on the
+	// /shorten route, zero disabling rate limiting.
+	ShortenRateLimit float64 `yaml:"shorten_rate_limit"`
+	// ShortenRateBurst defines how many requests each client may make at once on the /shorten route.
+	ShortenRateBurst int `yaml:"shorten_rate_burst"`
+	// RedirectRateLimit defines how many requests per second each client may sustain on the
+	// /:key route, zero disabling rate limiting.
+	RedirectRateLimit float64 `yaml:"redirect_rate_limit"`
+	// RedirectRateBurst defines how many requests each client may make at once on the /:key route.
+	RedirectRateBurst int `yaml:"redirect_rate_burst"`
+	// LevelDBBlockCacheSize defines the size in bytes of the cache of LevelDB table blocks,
+	// zero using the LevelDB default of 8 MiB.
+	LevelDBBlockCacheSize int `yaml:"leveldb_block_cache_size"`
+	// LevelDBWriteBuffer defines the size in bytes of the LevelDB memory table, which is
+	// written to disk once full, zero using the LevelDB default of 4 MiB.
+	LevelDBWriteBuffer int `yaml:"leveldb_write_buffer"`
+	// LevelDBBloomFilterBits defines the number of bits per key of the LevelDB bloom filter,
+	// which spares disk reads when looking up missing keys, zero disabling the filter.
+	LevelDBBloomFilterBits int `yaml:"leveldb_bloom_filter_bits"`
+	// LevelDBCompression defines the compression of LevelDB tables: snappy or none.
+	LevelDBCompression string `yaml:"leveldb_compression"`
+	// LevelDBReadOnly opens the URL database in read-only mode, in which links can be
+	// followed but not created, updated or deleted.
+	LevelDBReadOnly bool `yaml:"leveldb_read_only"`
+	// CacheSize defines the number of keys held by the read cache of the URL database, zero
+	// disabling the cache.
+	CacheSize int `yaml:"cache_size"`
+	// CacheTTL defines how long values are held by the read cache.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheNegativeTTL defines how long missing keys are held by the read cache, zero disabling
+	// the caching of missing keys.
+	CacheNegativeTTL time.Duration `yaml:"cache_negative_ttl"`
+	// ShutdownTimeout defines how long in-flight requests are given to complete on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// LogLevel defines the minimum level of logged entries: debug, info, warn or error.
+	LogLevel string `yaml:"log_level"`
+	// LogFormat defines the format of log entries: text or json.
+	LogFormat string `yaml:"log_format"`
+
+	// Args holds the arguments following the flags, such as the archive of the backup command.
+	Args []string `yaml:"-"`
+}
+
+// DefaultConfig returns the configuration used when no settings are provided.
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddress:       DefaultListenAddress,
+		DatabasePath:        DefaultDatabasePath,
+		DatabaseBackend:     DefaultDatabaseBackend,
+		URLPrefix:           DefaultURLPrefix,
+		URLKeySize:          DefaultURLKeySize,
+		CustomKeySizeLimit:  DefaultCustomKeySizeLimit,
+		MinCustomKeySize:    DefaultMinCustomKeySize,
+		CustomKeyCharacters: DefaultCustomKeyCharacters,
+		ReservedKeys:        []string{},
+		AllowedSchemes:      []string{"http", "https"},
+		MaxURLLength:        DefaultMaxURLLength,
+		ExpirySweepInterval: DefaultExpirySweepInterval,
+		ShortenRateBurst:    DefaultRateBurst,
+		RedirectRateBurst:   DefaultRateBurst,
+		LevelDBCompression:  LevelDBCompressionSnappy,
+		CacheSize:           DefaultCacheSize,
+		CacheTTL:            DefaultCacheTTL,
+		CacheNegativeTTL:    DefaultCacheNegativeTTL,
+		ShutdownTimeout:     DefaultShutdownTimeout,
+		LogLevel:            DefaultLogLevel,
+		LogFormat:           DefaultLogFormat,
+	}
+}
+
+// LoadConfig resolves the configuration from a configuration file, environment
+// variables and command-line arguments. The configuration file is given by the
+// -config flag or, failing that, the BAJO_CONFIG environment variable.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	return loadConfig(flag.NewFlagSet("bajo", flag.ContinueOnError), args, lookupEnv)
+}
+
+// loadConfig resolves the configuration as LoadConfig does, parsing the command-line arguments
+// with a flag set on which commands may have defined flags of their own.
+func loadConfig(flagSet *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := DefaultConfig()
+
+	configFile := flagSet.String("config", "", "path to a YAML configuration file")
+	listenAddress := flagSet.String("listen-address", config.ListenAddress, "address on which the HTTP server listens")
+	databasePath := flagSet.String("database-path", config.DatabasePath, "filepath of the URL database")
+	databaseBackend := flagSet.String("database-backend", config.DatabaseBackend, "storage backend of the URL database: leveldb, sqlite or memory")
+	urlPrefix := flagSet.String("url-prefix", config.URLPrefix, "prefix for shortened URLs")
+	urlPrefixFromRequest := flagSet.Bool("url-prefix-from-request", config.URLPrefixFromRequest, "derive the prefix for shortened URLs from requests")
+	trustedProxies := flagSet.String("trusted-proxies", "", "comma-separated IP addresses and CIDR networks of trusted reverse proxies")
+	urlKeySize := flagSet.Int("url-key-size", config.URLKeySize, "number of characters in a generated URL key")
+	customKeySizeLimit := flagSet.Int("custom-key-size-limit", config.CustomKeySizeLimit, "maximum size of a custom key")
+	minCustomKeySize := flagSet.Int("min-custom-key-size", config.MinCustomKeySize, "minimum size of a custom key")
+	customKeyCharacters := flagSet.String("custom-key-characters", config.CustomKeyCharacters, "characters allowed in custom keys, as a regular expression bracket expression")
+	reservedKeys := flagSet.String("reserved-keys", "", "comma-separated words which may not be used as custom keys")
+	caseInsensitiveKeys := flagSet.Bool("case-insensitive-keys", config.CaseInsensitiveKeys, "fold custom keys to lowercase")
+	allowedSchemes := flagSet.String("allowed-schemes", strings.Join(config.AllowedSchemes, ","), "comma-separated URL schemes which may be shortened")
+	maxURLLength := flagSet.Int("max-url-length", config.MaxURLLength, "maximum length of a URL which may be shortened")
+	expirySweepInterval := flagSet.Duration("expiry-sweep-interval", config.ExpirySweepInterval, "how often expired URL keys are deleted, 0 disabling deletion")
+	requireAPIKey := flagSet.Bool("require-api-key", config.RequireAPIKey, "restrict the creation of links to requests carrying a valid API key")
+	adminAPIKey := flagSet.String("admin-api-key", config.AdminAPIKey, "static API key granting administrative access")
+	shortenRateLimit := flagSet.Float64("shorten-rate-limit", config.ShortenRateLimit, "requests per second each client may sustain on /shorten, 0 disabling rate limiting")
+	shortenRateBurst := flagSet.Int("shorten-rate-burst", config.ShortenRateBurst, "requests each client may make at once on /shorten")
+	redirectRateLimit := flagSet.Float64("redirect-rate-limit", config.RedirectRateLimit, "requests per second each client may sustain on /:key, 0 disabling rate limiting")
+	redirectRateBurst := flagSet.Int("redirect-rate-burst", config.RedirectRateBurst, "requests each client may make at once on /:key")
+	levelDBBlockCacheSize := flagSet.Int("leveldb-block-cache-size", config.LevelDBBlockCacheSize, "size in bytes of the LevelDB block cache, 0 using the LevelDB default")
+	levelDBWriteBuffer := flagSet.Int("leveldb-write-buffer", config.LevelDBWriteBuffer, "size in bytes of the LevelDB write buffer, 0 using the LevelDB default")
+	levelDBBloomFilterBits := flagSet.Int("leveldb-bloom-filter-bits", config.LevelDBBloomFilterBits, "bits per key of the LevelDB bloom filter, 0 disabling the filter")
+	levelDBCompression := flagSet.String("leveldb-compression", config.LevelDBCompression, "compression of LevelDB tables: snappy or none")
+	levelDBReadOnly := flagSet.Bool("leveldb-read-only", config.LevelDBReadOnly, "open the URL database in read-only mode")
+	cacheSize := flagSet.Int("cache-size", config.CacheSize, "number of keys held by the read cache, 0 disabling the cache")
+	cacheTTL := flagSet.Duration("cache-ttl", config.CacheTTL, "how long values are held by the read cache")
+	cacheNegativeTTL := flagSet.Duration("cache-negative-ttl", config.CacheNegativeTTL, "how long missing keys are held by the read cache, 0 disabling their caching")
+	shutdownTimeout := flagSet.Duration("shutdown-timeout", config.ShutdownTimeout, "how long in-flight requests are given to complete on shutdown")
+	logLevel := flagSet.String("log-level", config.LogLevel, "minimum level of logged entries: debug, info, warn or error")
+	logFormat := flagSet.String("log-format", config.LogFormat, "format of log entries: text or json")
+
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	if flagSet.NArg() > 0 {
+		config.Args = flagSet.Args()
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(ConfigFileEnvVar)
+	}
+
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.loadEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+
+	// Only flags which were explicitly set override the other sources.
+	flagSet.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen-address":
+			config.ListenAddress = *listenAddress
+		case "database-path":
+			config.DatabasePath = *databasePath
+		case "database-backend":
+			config.DatabaseBackend = *databaseBackend
+		case "url-prefix":
+			config.URLPrefix = *urlPrefix
+		case "url-prefix-from-request":
+			config.URLPrefixFromRequest = *urlPrefixFromRequest
+		case "trusted-proxies":
+			config.TrustedProxies = splitList(*trustedProxies)
+		case "url-key-size":
+			config.URLKeySize = *urlKeySize
+		case "custom-key-size-limit":
+			config.CustomKeySizeLimit = *customKeySizeLimit
+		case "min-custom-key-size":
+			config.MinCustomKeySize = *minCustomKeySize
+		case "custom-key-characters":
+			config.CustomKeyCharacters = *customKeyCharacters
+		case "reserved-keys":
+			config.ReservedKeys = splitList(*reservedKeys)
+		case "case-insensitive-keys":
+			config.CaseInsensitiveKeys = *caseInsensitiveKeys
+		case "allowed-schemes":
+			config.AllowedSchemes = splitList(*allowedSchemes)
+		case "max-url-length":
+			config.MaxURLLength = *maxURLLength
+		case "expiry-sweep-interval":
+			config.ExpirySweepInterval = *expirySweepInterval
+		case "require-api-key":
+			config.RequireAPIKey = *requireAPIKey
+		case "admin-api-key":
+			config.AdminAPIKey = *adminAPIKey
+		case "shorten-rate-limit":
+			config.ShortenRateLimit = *shortenRateLimit
+		case "shorten-rate-burst":
+			config.ShortenRateBurst = *shortenRateBurst
+		case "redirect-rate-limit":
+			config.RedirectRateLimit = *redirectRateLimit
+		case "redirect-rate-burst":
+			config.RedirectRateBurst = *redirectRateBurst
+		case "leveldb-block-cache-size":
+			config.LevelDBBlockCacheSize = *levelDBBlockCacheSize
+		case "leveldb-write-buffer":
+			config.LevelDBWriteBuffer = *levelDBWriteBuffer
+		case "leveldb-bloom-filter-bits":
+			config.LevelDBBloomFilterBits = *levelDBBloomFilterBits
+		case "leveldb-compression":
+			config.LevelDBCompression = *levelDBCompression
+		case "leveldb-read-only":
+			config.LevelDBReadOnly = *levelDBReadOnly
+		case "cache-size":
+			config.CacheSize = *cacheSize
+		case "cache-ttl":
+			config.CacheTTL = *cacheTTL
+		case "cache-negative-ttl":
+			config.CacheNegativeTTL = *cacheNegativeTTL
+		case "shutdown-timeout":
+			config.ShutdownTimeout = *shutdownTimeout
+		case "log-level":
+			config.LogLevel = *logLevel
+		case "log-format":
+			config.LogFormat = *logFormat
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks that the configuration settings are usable.
+func (c *Config) Validate() error {
+	if c.URLKeySize < 1 || c.URLKeySize > maxURLKeySize {
+		return fmt.Errorf("url_key_size must be between 1 and %d, got %d", maxURLKeySize, c.URLKeySize)
+	}
+	if c.CustomKeySizeLimit < 1 {
+		return fmt.Errorf("custom_key_size_limit must be positive, got %d", c.CustomKeySizeLimit)
+	}
+	if c.MinCustomKeySize < 1 || c.MinCustomKeySize > c.CustomKeySizeLimit {
+		return fmt.Errorf("min_custom_key_size must be between 1 and %d, got %d", c.CustomKeySizeLimit, c.MinCustomKeySize)
+	}
+	if _, err := compileKeyCharset(c.CustomKeyCharacters); err != nil {
+		return fmt.Errorf("custom_key_characters: %w", err)
+	}
+	if len(c.AllowedSchemes) == 0 {
+		return fmt.Errorf("allowed_schemes must not be empty")
+	}
+	if c.MaxURLLength < 1 {
+		return fmt.Errorf("max_url_length must be positive, got %d", c.MaxURLLength)
+	}
+	if c.ExpirySweepInterval < 0 {
+		return fmt.Errorf("expiry_sweep_interval must not be negative, got %s", c.ExpirySweepInterval)
+	}
+	if c.DatabasePath == "" {
+		return fmt.Errorf("database_path must not be empty")
+	}
+	switch c.DatabaseBackend {
+	case DatabaseBackendLevelDB, DatabaseBackendSQLite, DatabaseBackendMemory:
+	default:
+		return fmt.Errorf("database_backend must be %s, %s or %s, got %q", DatabaseBackendLevelDB, DatabaseBackendSQLite, DatabaseBackendMemory, c.DatabaseBackend)
+	}
+	if c.ShortenRateLimit < 0 {
+		return fmt.Errorf("shorten_rate_limit must not be negative, got %g", c.ShortenRateLimit)
+	}
+	if c.RedirectRateLimit < 0 {
+		return fmt.Errorf("redirect_rate_limit must not be negative, got %g", c.RedirectRateLimit)
+	}
+	if c.ShortenRateBurst < 1 {
+		return fmt.Errorf("shorten_rate_burst must be positive, got %d", c.ShortenRateBurst)
+	}
+	if c.RedirectRateBurst < 1 {
+		return fmt.Errorf("redirect_rate_burst must be positive, got %d", c.RedirectRateBurst)
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	if c.LevelDBBlockCacheSize < 0 {
+		return fmt.Errorf("leveldb_block_cache_size must not be negative, got %d", c.LevelDBBlockCacheSize)
+	}
+	if c.LevelDBWriteBuffer < 0 {
+		return fmt.Errorf("leveldb_write_buffer must not be negative, got %d", c.LevelDBWriteBuffer)
+	}
+	if c.LevelDBBloomFilterBits < 0 {
+		return fmt.Errorf("leveldb_bloom_filter_bits must not be negative, got %d", c.LevelDBBloomFilterBits)
+	}
+	if _, ok := levelDBCompressions[c.LevelDBCompression]; !ok {
+		return fmt.Errorf("leveldb_compression must be %s or %s, got %q", LevelDBCompressionSnappy, LevelDBCompressionNone, c.LevelDBCompression)
+	}
+	if c.LevelDBReadOnly && c.DatabaseBackend != DatabaseBackendLevelDB {
+		return fmt.Errorf("leveldb_read_only requires the %s database_backend, got %q", DatabaseBackendLevelDB, c.DatabaseBackend)
+	}
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache_size must not be negative, got %d", c.CacheSize)
+	}
+	if c.CacheSize > 0 && c.CacheTTL <= 0 {
+		return fmt.Errorf("cache_ttl must be positive, got %s", c.CacheTTL)
+	}
+	if c.CacheNegativeTTL < 0 {
+		return fmt.Errorf("cache_negative_ttl must not be negative, got %s", c.CacheNegativeTTL)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown_timeout must be positive, got %s", c.ShutdownTimeout)
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %w", err)
+	}
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		return fmt.Errorf("log_format must be %s or %s, got %q", LogFormatText, LogFormatJSON, c.LogFormat)
+	}
+	return nil
+}
+
+// loadFile overrides settings with those present in a YAML configuration file.
+func (c *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read configuration file: %w", err)
+	}
+
+	if err = yaml.UnmarshalStrict(content, c); err != nil {
+		return fmt.Errorf("unable to parse configuration file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv overrides settings with those present in BAJO_* environment variables.
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error {
+	stringSettings := map[string]*string{
+		"BAJO_LISTEN_ADDRESS":        &c.ListenAddress,
+		"BAJO_DATABASE_PATH":         &c.DatabasePath,
+		"BAJO_DATABASE_BACKEND":      &c.DatabaseBackend,
+		"BAJO_URL_PREFIX":            &c.URLPrefix,
+		"BAJO_CUSTOM_KEY_CHARACTERS": &c.CustomKeyCharacters,
+		"BAJO_ADMIN_API_KEY":         &c.AdminAPIKey,
+		"BAJO_LOG_LEVEL":             &c.LogLevel,
+		"BAJO_LOG_FORMAT":            &c.LogFormat,
+		"BAJO_LEVELDB_COMPRESSION":   &c.LevelDBCompression,
+	}
+	for name, setting := range stringSettings {
+		if value, ok := lookupEnv(name); ok {
+			*setting = value
+		}
+	}
+
+	intSettings := map[string]*int{
+		"BAJO_URL_KEY_SIZE":              &c.URLKeySize,
+		"BAJO_CUSTOM_KEY_SIZE_LIMIT":     &c.CustomKeySizeLimit,
+		"BAJO_MAX_URL_LENGTH":            &c.MaxURLLength,
+		"BAJO_MIN_CUSTOM_KEY_SIZE":       &c.MinCustomKeySize,
+		"BAJO_SHORTEN_RATE_BURST":        &c.ShortenRateBurst,
+		"BAJO_REDIRECT_RATE_BURST":       &c.RedirectRateBurst,
+		"BAJO_LEVELDB_BLOCK_CACHE_SIZE":  &c.LevelDBBlockCacheSize,
+		"BAJO_LEVELDB_WRITE_BUFFER":      &c.LevelDBWriteBuffer,
+		"BAJO_LEVELDB_BLOOM_FILTER_BITS": &c.LevelDBBloomFilterBits,
+		"BAJO_CACHE_SIZE":                &c.CacheSize,
+	}
+	for name, setting := range intSettings {
+		if value, ok := lookupEnv(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			*setting = parsed
+		}
+	}
+
+	boolSettings := map[string]*bool{
+		"BAJO_URL_PREFIX_FROM_REQUEST": &c.URLPrefixFromRequest,
+		"BAJO_CASE_INSENSITIVE_KEYS":   &c.CaseInsensitiveKeys,
+		"BAJO_REQUIRE_API_KEY":         &c.RequireAPIKey,
+		"BAJO_LEVELDB_READ_ONLY":       &c.LevelDBReadOnly,
+	}
+	for name, setting := range boolSettings {
+		if value, ok := lookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			*setting = parsed
+		}
+	}
+
+	floatSettings := map[string]*float64{
+		"BAJO_SHORTEN_RATE_LIMIT":  &c.ShortenRateLimit,
+		"BAJO_REDIRECT_RATE_LIMIT": &c.RedirectRateLimit,
+	}
+	for name, setting := range floatSettings {
+		if value, ok := lookupEnv(name); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			*setting = parsed
+		}
+	}
+
+	durationSettings := map[string]*time.Duration{
+		"BAJO_EXPIRY_SWEEP_INTERVAL": &c.ExpirySweepInterval,
+		"BAJO_CACHE_TTL":             &c.CacheTTL,
+		"BAJO_CACHE_NEGATIVE_TTL":    &c.CacheNegativeTTL,
+		"BAJO_SHUTDOWN_TIMEOUT":      &c.ShutdownTimeout,
+	}
+	for name, setting := range durationSettings {
+		if value, ok := lookupEnv(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			*setting = parsed
+		}
+	}
+
+	listSettings := map[string]*[]string{
+		"BAJO_TRUSTED_PROXIES": &c.TrustedProxies,
+		"BAJO_ALLOWED_SCHEMES": &c.AllowedSchemes,
+		"BAJO_RESERVED_KEYS":   &c.ReservedKeys,
+	}
+	for name, setting := range listSettings {
+		if value, ok := lookupEnv(name); ok {
+			*setting = splitList(value)
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma-separated list, ignoring surrounding whitespace and empty entries.
+func splitList(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
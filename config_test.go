@@ -0,0 +1,287 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Describe("LoadConfig", func() {
+		var args []string
+		var env map[string]string
+		var config *Config
+		var err error
+
+		lookupEnv := func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}
+
+		writeConfigFile := func(content string) string {
+			path := filepath.Join(GinkgoT().TempDir(), "bajo.yaml")
+			Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+			return path
+		}
+
+		BeforeEach(func() {
+			args = []string{}
+			env = map[string]string{}
+		})
+
+		JustBeforeEach(func() {
+			config, err = LoadConfig(args, lookupEnv)
+		})
+
+		When("no settings are provided", func() {
+			It("returns the default configuration", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config).To(Equal(DefaultConfig()))
+			})
+		})
+
+		When("a configuration file is provided", func() {
+			BeforeEach(func() {
+				path := writeConfigFile("url_prefix: https://file.example\ndatabase_path: /var/lib/bajo\n")
+				args = []string{"-config", path}
+			})
+
+			It("applies the settings of the file", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.URLPrefix).To(Equal("https://file.example"))
+				Expect(config.DatabasePath).To(Equal("/var/lib/bajo"))
+				Expect(config.ListenAddress).To(Equal(DefaultListenAddress))
+			})
+
+			Context("and environment variables are provided", func() {
+				BeforeEach(func() {
+					env["BAJO_URL_PREFIX"] = "https://env.example"
+					env["BAJO_URL_KEY_SIZE"] = "10"
+				})
+
+				It("prefers the environment variables", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(config.URLPrefix).To(Equal("https://env.example"))
+					Expect(config.URLKeySize).To(Equal(10))
+					Expect(config.DatabasePath).To(Equal("/var/lib/bajo"))
+				})
+
+				Context("and flags are provided", func() {
+					BeforeEach(func() {
+						args = append(args, "-url-prefix", "https://flag.example")
+					})
+
+					It("prefers the flags", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(config.URLPrefix).To(Equal("https://flag.example"))
+						Expect(config.URLKeySize).To(Equal(10))
+					})
+				})
+			})
+		})
+
+		When("the configuration file is given by environment variable", func() {
+			BeforeEach(func() {
+				env[ConfigFileEnvVar] = writeConfigFile("listen_address: :9090\n")
+			})
+
+			It("applies the settings of the file", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.ListenAddress).To(Equal(":9090"))
+			})
+		})
+
+		When("the configuration file contains an unknown setting", func() {
+			BeforeEach(func() {
+				args = []string{"-config", writeConfigFile("unknown_setting: true\n")}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("the configuration file does not exist", func() {
+			BeforeEach(func() {
+				args = []string{"-config", filepath.Join(GinkgoT().TempDir(), "missing.yaml")}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("an environment variable holds an invalid number", func() {
+			BeforeEach(func() {
+				env["BAJO_CUSTOM_KEY_SIZE_LIMIT"] = "many"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("BAJO_CUSTOM_KEY_SIZE_LIMIT")))
+			})
+		})
+
+		When("trusted proxies are given by environment variable", func() {
+			BeforeEach(func() {
+				env["BAJO_TRUSTED_PROXIES"] = "10.0.0.0/8, 192.168.1.1"
+				env["BAJO_URL_PREFIX_FROM_REQUEST"] = "true"
+			})
+
+			It("splits the list of trusted proxies", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.TrustedProxies).To(Equal([]string{"10.0.0.0/8", "192.168.1.1"}))
+				Expect(config.URLPrefixFromRequest).To(BeTrue())
+			})
+		})
+
+		When("a trusted proxy is invalid", func() {
+			BeforeEach(func() {
+				args = []string{"-trusted-proxies", "10.0.0.0/99"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("trusted_proxies")))
+			})
+		})
+
+		When("rate limits are given by environment variable", func() {
+			BeforeEach(func() {
+				env["BAJO_SHORTEN_RATE_LIMIT"] = "0.5"
+				env["BAJO_SHORTEN_RATE_BURST"] = "5"
+			})
+
+			It("applies the rate limits", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.ShortenRateLimit).To(Equal(0.5))
+				Expect(config.ShortenRateBurst).To(Equal(5))
+				Expect(config.RedirectRateLimit).To(BeZero())
+			})
+		})
+
+		When("a rate limit is negative", func() {
+			BeforeEach(func() {
+				args = []string{"-redirect-rate-limit", "-1"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("redirect_rate_limit")))
+			})
+		})
+
+		When("LevelDB options are given by environment variable", func() {
+			BeforeEach(func() {
+				env["BAJO_LEVELDB_BLOCK_CACHE_SIZE"] = "67108864"
+				env["BAJO_LEVELDB_BLOOM_FILTER_BITS"] = "10"
+				env["BAJO_LEVELDB_COMPRESSION"] = "none"
+				env["BAJO_LEVELDB_READ_ONLY"] = "true"
+			})
+
+			It("applies the options", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.LevelDBBlockCacheSize).To(Equal(67108864))
+				Expect(config.LevelDBBloomFilterBits).To(Equal(10))
+				Expect(config.LevelDBCompression).To(Equal(LevelDBCompressionNone))
+				Expect(config.LevelDBReadOnly).To(BeTrue())
+			})
+		})
+
+		When("the LevelDB compression is unknown", func() {
+			BeforeEach(func() {
+				args = []string{"-leveldb-compression", "zstd"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("leveldb_compression")))
+			})
+		})
+
+		When("the database backend is given by environment variable", func() {
+			BeforeEach(func() {
+				env["BAJO_DATABASE_BACKEND"] = "sqlite"
+			})
+
+			It("applies the backend", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.DatabaseBackend).To(Equal(DatabaseBackendSQLite))
+			})
+		})
+
+		When("the database backend is unknown", func() {
+			BeforeEach(func() {
+				args = []string{"-database-backend", "redis"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("database_backend")))
+			})
+		})
+
+		When("read-only mode is requested for another backend than LevelDB", func() {
+			BeforeEach(func() {
+				args = []string{"-database-backend", "memory", "-leveldb-read-only"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("leveldb_read_only")))
+			})
+		})
+
+		When("the cache is configured by environment variable", func() {
+			BeforeEach(func() {
+				env["BAJO_CACHE_SIZE"] = "500"
+				env["BAJO_CACHE_TTL"] = "5m"
+				env["BAJO_CACHE_NEGATIVE_TTL"] = "0s"
+			})
+
+			It("applies the settings", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.CacheSize).To(Equal(500))
+				Expect(config.CacheTTL).To(Equal(5 * time.Minute))
+				Expect(config.CacheNegativeTTL).To(BeZero())
+			})
+		})
+
+		When("the cache TTL is not positive", func() {
+			BeforeEach(func() {
+				args = []string{"-cache-ttl", "0s"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("cache_ttl")))
+			})
+		})
+
+		When("the log level is unknown", func() {
+			BeforeEach(func() {
+				env["BAJO_LOG_LEVEL"] = "verbose"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("log_level")))
+			})
+		})
+
+		When("the log format is unknown", func() {
+			BeforeEach(func() {
+				args = []string{"-log-format", "xml"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("log_format")))
+			})
+		})
+
+		When("a setting is out of range", func() {
+			BeforeEach(func() {
+				args = []string{"-url-key-size", "0"}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("url_key_size")))
+			})
+		})
+	})
+})
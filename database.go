@@ -1,45 +1,140 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 
-	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/upsideon/bajo/storage"
 )
 
-const defaultDatabasePath = "url_database"
+const (
+	// DefaultDatabasePath defines the default filepath of the URL database.
+	DefaultDatabasePath = "url_database"
+
+	// DatabaseBackendLevelDB stores the URL database in a LevelDB database directory.
+	DatabaseBackendLevelDB = "leveldb"
+	// DatabaseBackendSQLite stores the URL database in an SQLite database file.
+	DatabaseBackendSQLite = "sqlite"
+	// DatabaseBackendMemory holds the URL database in memory, losing links on shutdown.
+	DatabaseBackendMemory = "memory"
+	// DefaultDatabaseBackend defines the backend of the URL database by default.
+	DefaultDatabaseBackend = DatabaseBackendLevelDB
+
+	// LevelDBCompressionSnappy compresses LevelDB tables with Snappy.
+	LevelDBCompressionSnappy = "snappy"
+	// LevelDBCompressionNone leaves LevelDB tables uncompressed.
+	LevelDBCompressionNone = "none"
+)
+
+// levelDBCompressions maps the names of LevelDB compressions to their options.
+var levelDBCompressions = map[string]opt.Compression{
+	LevelDBCompressionSnappy: opt.SnappyCompression,
+	LevelDBCompressionNone:   opt.NoCompression,
+}
+
+var (
+	// ErrDatabaseLocked is returned when the URL database is already opened by another process.
+	ErrDatabaseLocked = errors.New("the database is locked by another process, which may be another instance of bajo")
 
-// URLDatabase is an interface ressembling leveldb.DB, which is
-// used to facilitate dependency injection of mocks in tests.
+	// ErrDatabaseCorrupted is returned when the URL database cannot be read.
+	ErrDatabaseCorrupted = errors.New("the database is corrupted")
+)
+
+// URLDatabase is the ordered key-value store holding links, click events and API keys. It
+// matches storage.Database, so that any storage backend can be used, and is declared here to
+// facilitate dependency injection of mocks in tests.
 type URLDatabase interface {
 	Close() error
-	Delete(key []byte, wo *opt.WriteOptions) error
-	Get(key []byte, ro *opt.ReadOptions) (value []byte, err error)
-	Has(key []byte, ro *opt.ReadOptions) (ret bool, err error)
-	Put(key, value []byte, wo *opt.WriteOptions) error
+	Delete(key []byte) error
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	NewIterator(prefix []byte) storage.Iterator
+	Put(key, value []byte) error
+	Write(batch *storage.Batch) error
 }
 
-// DatabaseManager is an interface ressembling the top level functions
-// of leveldb which is used to faciliate dependency injection of mocks
-// in tests.
+// DatabaseManager opens the URL database of a storage backend, which is used to faciliate
+// dependency injection of mocks in tests.
 type DatabaseManager interface {
-	OpenFile(path string, o *opt.Options) (db *leveldb.DB, err error)
+	Open(path string) (storage.Database, error)
+}
+
+// LevelDBDatabaseManager opens URL databases stored in LevelDB.
+type LevelDBDatabaseManager struct {
+	// Options are the options with which databases are opened, nil using the defaults.
+	Options *opt.Options
 }
 
-// LevelDBDatabaseManager implements logic specific to the LevelDB database manager.
-type LevelDBDatabaseManager struct{}
+// Open opens a LevelDB database at a given filepath.
+func (m *LevelDBDatabaseManager) Open(path string) (storage.Database, error) {
+	database, err := storage.OpenLevelDB(path, m.Options)
+	if err != nil {
+		return nil, err
+	}
+	return database, nil
+}
 
-// OpenFile opens a LevelDB database at a given filepath.
-func (m *LevelDBDatabaseManager) OpenFile(path string, o *opt.Options) (*leveldb.DB, error) {
-	return leveldb.OpenFile(path, nil)
+// SQLiteDatabaseManager opens URL databases stored in SQLite.
+type SQLiteDatabaseManager struct{}
+
+// Open opens an SQLite database at a given filepath.
+func (m *SQLiteDatabaseManager) Open(path string) (storage.Database, error) {
+	database, err := storage.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return database, nil
+}
+
+// MemoryDatabaseManager creates URL databases held in memory.
+type MemoryDatabaseManager struct{}
+
+// Open creates an empty in-memory database, ignoring the filepath.
+func (m *MemoryDatabaseManager) Open(path string) (storage.Database, error) {
+	return storage.NewMemory(), nil
+}
+
+// NewDatabaseManager creates the database manager of the configured storage backend.
+func NewDatabaseManager(config *Config) (DatabaseManager, error) {
+	switch config.DatabaseBackend {
+	case DatabaseBackendLevelDB:
+		return &LevelDBDatabaseManager{Options: NewLevelDBOptions(config)}, nil
+	case DatabaseBackendSQLite:
+		return &SQLiteDatabaseManager{}, nil
+	case DatabaseBackendMemory:
+		return &MemoryDatabaseManager{}, nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", config.DatabaseBackend)
+	}
+}
+
+// NewLevelDBOptions creates the options with which the URL database is opened from the configuration.
+func NewLevelDBOptions(config *Config) *opt.Options {
+	options := &opt.Options{
+		BlockCacheCapacity: config.LevelDBBlockCacheSize,
+		WriteBuffer:        config.LevelDBWriteBuffer,
+		Compression:        levelDBCompressions[config.LevelDBCompression],
+		ReadOnly:           config.LevelDBReadOnly,
+	}
+	if config.LevelDBBloomFilterBits > 0 {
+		options.Filter = filter.NewBloomFilter(config.LevelDBBloomFilterBits)
+	}
+	return options
 }
 
-// GetURLDatabase retrieves the URL database.
-func GetURLDatabase(databaseManager DatabaseManager) URLDatabase {
-	urlDatabase, err := databaseManager.OpenFile(defaultDatabasePath, nil)
+// GetURLDatabase retrieves the URL database located at a given filepath.
+func GetURLDatabase(databaseManager DatabaseManager, path string) (URLDatabase, error) {
+	urlDatabase, err := databaseManager.Open(path)
 	if err != nil {
-		errorMessage := fmt.Sprintf("Error: Unable to access URL database: %s", err)
-		panic(errorMessage)
+		if errors.Is(err, storage.ErrLocked) {
+			err = ErrDatabaseLocked
+		} else if storage.IsCorrupted(err) {
+			err = fmt.Errorf("%w: %s", ErrDatabaseCorrupted, err)
+		}
+		return nil, fmt.Errorf("unable to open URL database at %s: %w", path, err)
 	}
-	return urlDatabase
+	return urlDatabase, nil
 }
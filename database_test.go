@@ -2,13 +2,16 @@ package main
 
 import (
 	"errors"
+	"path/filepath"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 
 	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
 )
 
 var _ = Describe("Database", func() {
@@ -26,41 +29,160 @@ var _ = Describe("Database", func() {
 
 		Context("and an error occurs retrieving URL database", func() {
 			BeforeEach(func() {
-				mockDatabaseManager.EXPECT().OpenFile(
-					defaultDatabasePath, nil,
+				mockDatabaseManager.EXPECT().Open(
+					DefaultDatabasePath,
 				).Return(nil, errors.New("failed to retrieve database"))
 			})
 
-			It("should panic with an error message", func() {
-				defer func() {
-					if r := recover(); r == nil {
-						Fail("did not panic when unable to access database")
-					} else {
-						Expect(r).To(
-							Equal("Error: Unable to access URL database: failed to retrieve database"),
-						)
-					}
-				}()
-
-				GetURLDatabase(mockDatabaseManager)
+			It("should return an error", func() {
+				returnedDatabase, err := GetURLDatabase(mockDatabaseManager, DefaultDatabasePath)
+				Expect(returnedDatabase).To(BeNil())
+				Expect(err).To(MatchError(
+					"unable to open URL database at url_database: failed to retrieve database",
+				))
+			})
+		})
+
+		Context("and the URL database is locked by another process", func() {
+			var path string
+
+			BeforeEach(func() {
+				path = GinkgoT().TempDir()
+				lockingDatabase, err := storage.OpenLevelDB(path, nil)
+				Expect(err).NotTo(HaveOccurred())
+				DeferCleanup(lockingDatabase.Close)
+			})
+
+			It("should report the lock", func() {
+				_, err := GetURLDatabase(&LevelDBDatabaseManager{}, path)
+				Expect(err).To(MatchError(ErrDatabaseLocked))
 			})
 		})
 
 		Context("and the URL database is retrieved successfully", func() {
-			var urlDatabase *leveldb.DB
+			var urlDatabase *storage.Memory
 
 			BeforeEach(func() {
-				urlDatabase = &leveldb.DB{}
+				urlDatabase = storage.NewMemory()
 
-				mockDatabaseManager.EXPECT().OpenFile(
-					defaultDatabasePath, nil,
+				mockDatabaseManager.EXPECT().Open(
+					DefaultDatabasePath,
 				).Return(urlDatabase, nil)
 			})
 
 			It("should return the URL database", func() {
-				returnedDatabase := GetURLDatabase(mockDatabaseManager)
+				returnedDatabase, err := GetURLDatabase(mockDatabaseManager, DefaultDatabasePath)
+				Expect(err).NotTo(HaveOccurred())
 				Expect(returnedDatabase).To(Equal(urlDatabase))
 			})
 		})
 	})
+
+	Describe("LevelDBDatabaseManager", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = GinkgoT().TempDir()
+		})
+
+		It("should honor the options", func() {
+			_, err := (&LevelDBDatabaseManager{Options: &opt.Options{ErrorIfMissing: true}}).Open(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should open the database in read-only mode", func() {
+			writableDatabase, err := (&LevelDBDatabaseManager{}).Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writableDatabase.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+			Expect(writableDatabase.Close()).To(Succeed())
+
+			readOnlyDatabase, err := (&LevelDBDatabaseManager{Options: &opt.Options{ReadOnly: true}}).Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			defer readOnlyDatabase.Close()
+
+			value, err := readOnlyDatabase.Get([]byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(value)).To(Equal("https://example.com/"))
+			Expect(readOnlyDatabase.Put([]byte("other"), []byte("https://example.org/"))).To(MatchError(storage.ErrReadOnly))
+		})
+	})
+
+	Describe("NewDatabaseManager", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = DefaultConfig()
+		})
+
+		It("should open LevelDB databases by default", func() {
+			databaseManager, err := NewDatabaseManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(databaseManager).To(BeAssignableToTypeOf(&LevelDBDatabaseManager{}))
+		})
+
+		It("should open the configured backend", func() {
+			config.DatabaseBackend = DatabaseBackendSQLite
+			databaseManager, err := NewDatabaseManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			urlDatabase, err := GetURLDatabase(databaseManager, filepath.Join(GinkgoT().TempDir(), "bajo.db"))
+			Expect(err).NotTo(HaveOccurred())
+			defer urlDatabase.Close()
+			Expect(urlDatabase).To(BeAssignableToTypeOf(&storage.SQLite{}))
+
+			config.DatabaseBackend = DatabaseBackendMemory
+			databaseManager, err = NewDatabaseManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(databaseManager).To(BeAssignableToTypeOf(&MemoryDatabaseManager{}))
+		})
+
+		It("should reject an unknown backend", func() {
+			config.DatabaseBackend = "redis"
+			_, err := NewDatabaseManager(config)
+			Expect(err).To(MatchError(`unknown database backend "redis"`))
+		})
+	})
+
+	Describe("NewLevelDBOptions", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = DefaultConfig()
+		})
+
+		It("should leave the LevelDB defaults in place by default", func() {
+			options := NewLevelDBOptions(config)
+			Expect(options.GetBlockCacheCapacity()).To(Equal(opt.DefaultBlockCacheCapacity))
+			Expect(options.GetWriteBuffer()).To(Equal(opt.DefaultWriteBuffer))
+			Expect(options.GetCompression()).To(Equal(opt.SnappyCompression))
+			Expect(options.GetFilter()).To(BeNil())
+			Expect(options.GetReadOnly()).To(BeFalse())
+		})
+
+		It("should apply the configured settings", func() {
+			config.LevelDBBlockCacheSize = 64 * opt.MiB
+			config.LevelDBWriteBuffer = 16 * opt.MiB
+			config.LevelDBBloomFilterBits = 10
+			config.LevelDBCompression = LevelDBCompressionNone
+			config.LevelDBReadOnly = true
+
+			options := NewLevelDBOptions(config)
+			Expect(options.GetBlockCacheCapacity()).To(Equal(64 * opt.MiB))
+			Expect(options.GetWriteBuffer()).To(Equal(16 * opt.MiB))
+			Expect(options.GetFilter()).To(Equal(filter.NewBloomFilter(10)))
+			Expect(options.GetCompression()).To(Equal(opt.NoCompression))
+			Expect(options.GetReadOnly()).To(BeTrue())
+		})
+
+		It("should be usable to open a database", func() {
+			config.LevelDBBloomFilterBits = 10
+			config.LevelDBCompression = LevelDBCompressionNone
+
+			urlDatabase, err := GetURLDatabase(&LevelDBDatabaseManager{Options: NewLevelDBOptions(config)}, GinkgoT().TempDir())
+			Expect(err).NotTo(HaveOccurred())
+			defer urlDatabase.Close()
+
+			Expect(urlDatabase.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+		})
+	})
 })
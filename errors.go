@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// Stable, machine-readable codes identifying the errors returned by the API.
+const (
+	ErrorCodeMalformedRequest     = "malformed_request"
+	ErrorCodeMissingField         = "missing_field"
+	ErrorCodeInvalidField         = "invalid_field"
+	ErrorCodeURLInvalid           = "url_invalid"
+	ErrorCodeURLTooLong           = "url_too_long"
+	ErrorCodeURLNotAbsolute       = "url_not_absolute"
+	ErrorCodeURLSchemeNotAllowed  = "url_scheme_not_allowed"
+	ErrorCodeURLInvalidHost       = "url_invalid_host"
+	ErrorCodeExpiryConflict       = "expiry_conflict"
+	ErrorCodeExpiryInPast         = "expiry_in_past"
+	ErrorCodeKeyTooShort          = "key_too_short"
+	ErrorCodeKeyTooLong           = "key_too_long"
+	ErrorCodeKeyInvalidCharacters = "key_invalid_characters"
+	ErrorCodeKeyReserved          = "key_reserved"
+	ErrorCodeKeyInUse             = "key_in_use"
+	ErrorCodeBatchTooLarge        = "batch_too_large"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodeForbidden            = "forbidden"
+	ErrorCodeNotFound             = "not_found"
+	ErrorCodeGone                 = "gone"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeInternal             = "internal_error"
+	ErrorCodeUnavailable          = "unavailable"
+)
+
+const (
+	// MIMEProblemJSON is the media type of RFC 7807 problem details.
+	MIMEProblemJSON = "application/problem+json"
+
+	// problemTypePrefix prefixes the error code to form the type URI of problem details.
+	problemTypePrefix = "urn:bajo:error:"
+)
+
+// keyRuleErrorCodes maps the rules of the key validation policy to error codes.
+var keyRuleErrorCodes = map[string]string{
+	KeyRuleMinLength: ErrorCodeKeyTooShort,
+	KeyRuleMaxLength: ErrorCodeKeyTooLong,
+	KeyRuleCharset:   ErrorCodeKeyInvalidCharacters,
+	KeyRuleReserved:  ErrorCodeKeyReserved,
+}
+
+// APIError represents an error returned to API clients.
+type APIError struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Code identifies the error for clients, and never changes for a given kind of error.
+	Code string
+	// Message describes the error to humans.
+	Message string
+	// Field names the request field which caused the error, if any.
+	Field string
+	// Details holds further members of the error body specific to the error.
+	Details gin.H
+	// Err contains the underlying cause, which is logged but not returned to clients.
+	Err error
+}
+
+// NewAPIError creates an API error.
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// Error describes the error along with its cause.
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// WithField returns a copy of the error naming the request field which caused it.
+func (e *APIError) WithField(field string) *APIError {
+	copied := *e
+	copied.Field = field
+	return &copied
+}
+
+// WithDetail returns a copy of the error holding a further member in its body.
+func (e *APIError) WithDetail(name string, value interface{}) *APIError {
+	copied := *e
+	copied.Details = gin.H{}
+	for existingName, existingValue := range e.Details {
+		copied.Details[existingName] = existingValue
+	}
+	copied.Details[name] = value
+	return &copied
+}
+
+// errNotFound is returned when the requested resource does not exist.
+func errNotFound() *APIError {
+	return NewAPIError(http.StatusNotFound, ErrorCodeNotFound, "The requested resource does not exist")
+}
+
+// errForbidden is returned when the client may not perform the requested operation.
+func errForbidden() *APIError {
+	return NewAPIError(http.StatusForbidden, ErrorCodeForbidden, "The API key does not grant access to this resource")
+}
+
+// errInternal is returned when a request fails for a reason which the client cannot remedy.
+// Writes to a database opened in read-only mode are reported as unavailable.
+func errInternal(err error) *APIError {
+	if errors.Is(err, storage.ErrReadOnly) {
+		apiErr := NewAPIError(http.StatusServiceUnavailable, ErrorCodeUnavailable, "The URL database is read-only")
+		apiErr.Err = err
+		return apiErr
+	}
+
+	apiErr := NewAPIError(http.StatusInternalServerError, ErrorCodeInternal, "An internal error occurred")
+	apiErr.Err = err
+	return apiErr
+}
+
+// errBinding converts the failure to decode or validate a request into an API error, naming
+// the offending field by its JSON name.
+func errBinding(err error, request interface{}) *APIError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) || len(validationErrors) == 0 {
+		apiErr := NewAPIError(http.StatusBadRequest, ErrorCodeMalformedRequest, "The request could not be decoded")
+		apiErr.Err = err
+		return apiErr
+	}
+
+	fieldError := validationErrors[0]
+	field := jsonFieldName(request, fieldError.StructField())
+
+	if fieldError.Tag() == "required" {
+		return NewAPIError(http.StatusBadRequest, ErrorCodeMissingField, fmt.Sprintf("The %s field is required", field)).WithField(field)
+	}
+	return NewAPIError(http.StatusBadRequest, ErrorCodeInvalidField, fmt.Sprintf("The %s field is invalid", field)).WithField(field)
+}
+
+// errURL converts the failure to normalize a URL into an API error.
+func errURL(err error, field string) *APIError {
+	code := ErrorCodeURLInvalid
+	switch {
+	case errors.Is(err, ErrURLTooLong):
+		code = ErrorCodeURLTooLong
+	case errors.Is(err, ErrURLNotAbsolute):
+		code = ErrorCodeURLNotAbsolute
+	case errors.Is(err, ErrURLSchemeNotAllowed):
+		code = ErrorCodeURLSchemeNotAllowed
+	case errors.Is(err, ErrURLInvalidHost):
+		code = ErrorCodeURLInvalidHost
+	}
+	return NewAPIError(http.StatusBadRequest, code, err.Error()).WithField(field)
+}
+
+// errExpiry converts the failure to determine a requested expiry into an API error.
+func errExpiry(err error) *APIError {
+	if errors.Is(err, ErrExpiryConflict) {
+		return NewAPIError(http.StatusBadRequest, ErrorCodeExpiryConflict, err.Error())
+	}
+	return NewAPIError(http.StatusBadRequest, ErrorCodeExpiryInPast, err.Error()).WithField("expires_at")
+}
+
+// errKey converts a violation of the key validation policy into an API error.
+func errKey(err *KeyValidationError) *APIError {
+	return NewAPIError(http.StatusBadRequest, keyRuleErrorCodes[err.Rule], err.Message).WithField("key")
+}
+
+// respondWithError responds with an API error, in JSON by default, as RFC 7807 problem details
+// or as plain text, depending on the Accept header of the request. The code of the error is
+// logged as the outcome of the request, along with its cause, as it is not returned to the client.
+func respondWithError(context *gin.Context, apiErr *APIError) {
+	addLogFields(context, "outcome", apiErr.Code)
+	if apiErr.Err != nil {
+		addLogFields(context, "error", apiErr.Err)
+	}
+
+	requestID := requestID(context)
+
+	switch context.NegotiateFormat(binding.MIMEJSON, MIMEProblemJSON, binding.MIMEPlain) {
+	case binding.MIMEPlain:
+		context.String(apiErr.Status, apiErr.Message)
+	case MIMEProblemJSON:
+		body := gin.H{
+			"type":       problemTypePrefix + apiErr.Code,
+			"title":      http.StatusText(apiErr.Status),
+			"status":     apiErr.Status,
+			"detail":     apiErr.Message,
+			"code":       apiErr.Code,
+			"request_id": requestID,
+		}
+		writeErrorBody(context, apiErr, body, MIMEProblemJSON)
+	default:
+		body := gin.H{
+			"error":      http.StatusText(apiErr.Status),
+			"code":       apiErr.Code,
+			"message":    apiErr.Message,
+			"request_id": requestID,
+		}
+		writeErrorBody(context, apiErr, body, binding.MIMEJSON)
+	}
+}
+
+// writeErrorBody completes an error body with the field and details of an error and writes it.
+func writeErrorBody(context *gin.Context, apiErr *APIError, body gin.H, contentType string) {
+	if apiErr.Field != "" {
+		body["field"] = apiErr.Field
+	}
+	for name, value := range apiErr.Details {
+		body[name] = value
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		requestLogger(context).Error("unable to encode error response", "error", err)
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+	context.Data(apiErr.Status, contentType+"; charset=utf-8", content)
+}
+
+// abortWithError responds with an API error and stops the remaining handlers of the request.
+func abortWithError(context *gin.Context, apiErr *APIError) {
+	respondWithError(context, apiErr)
+	context.Abort()
+}
+
+// jsonFieldName returns the JSON name of a field of a request struct.
+func jsonFieldName(request interface{}, structField string) string {
+	requestType := reflect.TypeOf(request)
+	for requestType.Kind() == reflect.Pointer {
+		requestType = requestType.Elem()
+	}
+
+	if field, ok := requestType.FieldByName(structField); ok {
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return structField
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Error responses", func() {
+	var router http.Handler
+
+	BeforeEach(func() {
+		urlDatabase := storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		router, _ = initializeRouter(urlDatabase, DefaultConfig())
+	})
+
+	send := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		return writer
+	}
+
+	responseContent := func(writer *httptest.ResponseRecorder) map[string]interface{} {
+		var content map[string]interface{}
+		Expect(json.Unmarshal(writer.Body.Bytes(), &content)).To(Succeed())
+		return content
+	}
+
+	It("returns the code, message and field of an error as JSON", func() {
+		writer := send("POST", "/shorten", `{"url": "https://example.com/", "key": "shorten"}`, nil)
+
+		Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		Expect(writer.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+		content := responseContent(writer)
+		Expect(content).To(HaveKeyWithValue("error", "Bad Request"))
+		Expect(content).To(HaveKeyWithValue("code", ErrorCodeKeyReserved))
+		Expect(content).To(HaveKeyWithValue("field", "key"))
+		Expect(content["message"]).To(ContainSubstring("reserved"))
+		Expect(content["request_id"]).NotTo(BeEmpty())
+	})
+
+	It("returns problem details when they are preferred", func() {
+		writer := send("GET", "/missing/stats", "", map[string]string{"Accept": MIMEProblemJSON})
+
+		Expect(writer.Code).To(Equal(http.StatusNotFound))
+		Expect(writer.Header().Get("Content-Type")).To(HavePrefix(MIMEProblemJSON))
+		content := responseContent(writer)
+		Expect(content).To(HaveKeyWithValue("type", "urn:bajo:error:not_found"))
+		Expect(content).To(HaveKeyWithValue("title", "Not Found"))
+		Expect(content).To(HaveKeyWithValue("status", float64(http.StatusNotFound)))
+		Expect(content).To(HaveKeyWithValue("code", ErrorCodeNotFound))
+		Expect(content).To(HaveKey("detail"))
+	})
+
+	It("returns the message as plain text when it is preferred", func() {
+		writer := send("GET", "/missing/stats", "", map[string]string{"Accept": "text/plain"})
+
+		Expect(writer.Code).To(Equal(http.StatusNotFound))
+		Expect(writer.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(writer.Body.String()).To(Equal(errNotFound().Message))
+	})
+
+	It("echoes the identifier of the request", func() {
+		writer := send("GET", "/missing/stats", "", map[string]string{RequestIDHeader: "request-1"})
+
+		Expect(responseContent(writer)).To(HaveKeyWithValue("request_id", "request-1"))
+	})
+
+	DescribeTable("binding errors",
+		func(body, code, field string) {
+			writer := send("POST", "/shorten", body, nil)
+
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+			content := responseContent(writer)
+			Expect(content).To(HaveKeyWithValue("code", code))
+			if field == "" {
+				Expect(content).NotTo(HaveKey("field"))
+			} else {
+				Expect(content).To(HaveKeyWithValue("field", field))
+			}
+		},
+		Entry("missing field", `{}`, ErrorCodeMissingField, "url"),
+		Entry("invalid field", `{"url": "https://example.com/", "redirect_type": 303}`, ErrorCodeInvalidField, "redirect_type"),
+		Entry("malformed body", `{"url":`, ErrorCodeMalformedRequest, ""),
+	)
+
+	It("reports writes to a read-only database as unavailable", func() {
+		apiErr := errInternal(fmt.Errorf("wrapped: %w", storage.ErrReadOnly))
+
+		Expect(apiErr.Status).To(Equal(http.StatusServiceUnavailable))
+		Expect(apiErr.Code).To(Equal(ErrorCodeUnavailable))
+	})
+
+	DescribeTable("URL errors",
+		func(err error, code string) {
+			apiErr := errURL(fmt.Errorf("wrapped: %w", err), "url")
+
+			Expect(apiErr.Status).To(Equal(http.StatusBadRequest))
+			Expect(apiErr.Code).To(Equal(code))
+			Expect(apiErr.Field).To(Equal("url"))
+		},
+		Entry("too long", ErrURLTooLong, ErrorCodeURLTooLong),
+		Entry("not absolute", ErrURLNotAbsolute, ErrorCodeURLNotAbsolute),
+		Entry("scheme not allowed", ErrURLSchemeNotAllowed, ErrorCodeURLSchemeNotAllowed),
+		Entry("invalid host", ErrURLInvalidHost, ErrorCodeURLInvalidHost),
+		Entry("other", errors.New("unparsable"), ErrorCodeURLInvalid),
+	)
+})
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// DefaultExpirySweepInterval defines how often expired URL keys are deleted by default.
+const DefaultExpirySweepInterval = time.Minute
+
+var (
+	// ErrExpiryConflict is returned when both an absolute expiry and a TTL are requested.
+	ErrExpiryConflict = errors.New("only one of expires_at and ttl_seconds may be provided")
+	// ErrExpiryInPast is returned when the requested expiry has already passed.
+	ErrExpiryInPast = errors.New("expiry must be in the future")
+)
+
+// isExpired determines whether an expiry has passed, the zero time meaning no expiry.
+func isExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// requestedExpiry returns the expiry requested by either an absolute time or a TTL in
+// seconds, which is the zero time when neither was provided.
+func requestedExpiry(expiresAt *time.Time, TTLSeconds *int64, now time.Time) (time.Time, error) {
+	var expiry time.Time
+
+	switch {
+	case expiresAt != nil && TTLSeconds != nil:
+		return time.Time{}, ErrExpiryConflict
+	case expiresAt != nil:
+		expiry = *expiresAt
+	case TTLSeconds != nil:
+		expiry = now.Add(time.Duration(*TTLSeconds) * time.Second)
+	default:
+		return time.Time{}, nil
+	}
+
+	if !expiry.After(now) {
+		return time.Time{}, ErrExpiryInPast
+	}
+	return expiry.UTC(), nil
+}
+
+// ExpirySweeper periodically deletes expired URL keys from the URL database.
+type ExpirySweeper struct {
+	URLDatabase URLDatabase
+	Interval    time.Duration
+}
+
+// Run sweeps expired URL keys at every interval until the context is cancelled.
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := s.Sweep(now)
+			if err != nil {
+				logger.Error("unable to sweep expired links", "error", err)
+			} else if deleted > 0 {
+				logger.Info("swept expired links", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// Sweep deletes the URL keys which have expired at the given time, returning how many were deleted.
+func (s *ExpirySweeper) Sweep(now time.Time) (int, error) {
+	expiredKeys := [][]byte{}
+
+	err := forEachLink(s.URLDatabase, func(URLKey, value []byte) error {
+		// Values which cannot be decoded are left for an operator to inspect.
+		if record, err := decodeStoredLink(s.URLDatabase, URLKey, value); err == nil && record.IsExpired(now) {
+			expiredKeys = append(expiredKeys, append([]byte{}, URLKey...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, URLKey := range expiredKeys {
+		wasDeleted, err := s.deleteIfExpired(URLKey, now)
+		if err != nil {
+			return deleted, err
+		}
+		if wasDeleted {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteIfExpired deletes a URL key along with its legacy expiry and click events, unless it
+// was stored again with a new expiry since the database was scanned.
+func (s *ExpirySweeper) deleteIfExpired(URLKey []byte, now time.Time) (bool, error) {
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	record, _, err := readLinkRecord(s.URLDatabase, URLKey)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !record.IsExpired(now) {
+		return false, nil
+	}
+
+	// The link is deleted atomically with the entries of its key, so that a link later created
+	// with the same key does not inherit them.
+	batch := new(storage.Batch)
+	if err = deleteLinkEntries(s.URLDatabase, batch, URLKey); err != nil {
+		return false, err
+	}
+	batch.Delete(URLKey)
+
+	if err = s.URLDatabase.Write(batch); err != nil {
+		return false, err
+	}
+	return true, nil
+}
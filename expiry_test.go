@@ -0,0 +1,172 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Expiry", func() {
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	Describe("ShortenRequest.Expiry", func() {
+		var shortenRequest ShortenRequest
+
+		BeforeEach(func() {
+			shortenRequest = ShortenRequest{URL: "https://duckduckgo.com/"}
+		})
+
+		It("returns no expiry when none is requested", func() {
+			expiresAt, err := shortenRequest.Expiry(now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expiresAt.IsZero()).To(BeTrue())
+		})
+
+		It("returns the requested absolute expiry", func() {
+			requested := now.Add(time.Hour)
+			shortenRequest.ExpiresAt = &requested
+
+			Expect(shortenRequest.Expiry(now)).To(Equal(requested))
+		})
+
+		It("computes the expiry from the requested TTL", func() {
+			ttl := int64(90)
+			shortenRequest.TTLSeconds = &ttl
+
+			Expect(shortenRequest.Expiry(now)).To(Equal(now.Add(90 * time.Second)))
+		})
+
+		It("rejects expiries which are not in the future", func() {
+			ttl := int64(0)
+			shortenRequest.TTLSeconds = &ttl
+
+			_, err := shortenRequest.Expiry(now)
+			Expect(err).To(MatchError(ErrExpiryInPast))
+		})
+
+		It("rejects requests with both an absolute expiry and a TTL", func() {
+			requested := now.Add(time.Hour)
+			ttl := int64(90)
+			shortenRequest.ExpiresAt = &requested
+			shortenRequest.TTLSeconds = &ttl
+
+			_, err := shortenRequest.Expiry(now)
+			Expect(err).To(MatchError(ErrExpiryConflict))
+		})
+	})
+
+	Context("with a URL database", func() {
+		var urlDatabase *storage.Memory
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+		})
+
+		AfterEach(func() {
+			urlDatabase.Close()
+		})
+
+		Describe("storeLinkRecord", func() {
+			It("reuses keys which have expired", func() {
+				expiredRecord := NewLinkRecord("https://old.example/", now, time.Now().Add(-time.Minute))
+				Expect(writeLinkRecord(urlDatabase, []byte("docs"), expiredRecord)).To(Succeed())
+
+				record := NewLinkRecord("https://new.example/", time.Now(), time.Time{})
+				storedRecord, err := storeLinkRecord(urlDatabase, []byte("docs"), record)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(storedRecord.URL).To(Equal("https://new.example/"))
+				Expect(storedRecord.ExpiresAt).To(BeNil())
+			})
+
+			It("deletes the click events and legacy expiry of the expired link", func() {
+				expiresAt := now.Add(-time.Minute)
+				Expect(urlDatabase.Put([]byte("docs"), []byte("https://old.example/"))).To(Succeed())
+				Expect(urlDatabase.Put(legacyExpiryKey([]byte("docs")), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+				for index := 0; index < 3; index++ {
+					Expect(RecordClick(urlDatabase, ClickEvent{Key: "docs", Timestamp: expiresAt})).To(Succeed())
+				}
+
+				record := NewLinkRecord("https://new.example/", time.Now(), time.Time{})
+				_, err := storeLinkRecord(urlDatabase, []byte("docs"), record)
+				Expect(err).NotTo(HaveOccurred())
+
+				statistics, err := GetClickStatistics(urlDatabase, "docs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+				Expect(urlDatabase.Has(legacyExpiryKey([]byte("docs")))).To(BeFalse())
+			})
+
+			It("keeps keys which have not expired", func() {
+				currentRecord := NewLinkRecord("https://old.example/", now, time.Now().Add(time.Hour))
+				Expect(writeLinkRecord(urlDatabase, []byte("docs"), currentRecord)).To(Succeed())
+
+				record := NewLinkRecord("https://new.example/", time.Now(), time.Time{})
+				storedRecord, err := storeLinkRecord(urlDatabase, []byte("docs"), record)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(storedRecord.URL).To(Equal("https://old.example/"))
+			})
+		})
+
+		Describe("ExpirySweeper.Sweep", func() {
+			var sweeper *ExpirySweeper
+
+			BeforeEach(func() {
+				sweeper = &ExpirySweeper{URLDatabase: urlDatabase, Interval: time.Minute}
+
+				records := map[string]*LinkRecord{
+					"expired":   NewLinkRecord("https://expired.example/", now, now.Add(-time.Second)),
+					"current":   NewLinkRecord("https://current.example/", now, now.Add(time.Hour)),
+					"permanent": NewLinkRecord("https://permanent.example/", now, time.Time{}),
+				}
+				for URLKey, record := range records {
+					Expect(writeLinkRecord(urlDatabase, []byte(URLKey), record)).To(Succeed())
+				}
+
+				click := ClickEvent{Key: "expired", Timestamp: now}
+				Expect(RecordClick(urlDatabase, click)).To(Succeed())
+			})
+
+			It("deletes the expired URL keys", func() {
+				deleted, err := sweeper.Sweep(now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(1))
+
+				_, err = urlDatabase.Get([]byte("expired"))
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+
+			It("deletes the click events of the expired URL keys", func() {
+				_, err := sweeper.Sweep(now)
+				Expect(err).NotTo(HaveOccurred())
+
+				statistics, err := GetClickStatistics(urlDatabase, "expired")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+			})
+
+			It("deletes legacy values whose legacy expiry has passed", func() {
+				expiresAt := now.Add(-time.Hour)
+				Expect(urlDatabase.Put([]byte("legacy"), []byte("https://legacy.example/"))).To(Succeed())
+				Expect(urlDatabase.Put(legacyExpiryKey([]byte("legacy")), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+
+				deleted, err := sweeper.Sweep(now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(2))
+
+				Expect(urlDatabase.Has([]byte("legacy"))).To(BeFalse())
+				Expect(urlDatabase.Has(legacyExpiryKey([]byte("legacy")))).To(BeFalse())
+			})
+
+			It("keeps the URL keys which have not expired", func() {
+				_, err := sweeper.Sweep(now)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(urlDatabase.Has([]byte("current"))).To(BeTrue())
+				Expect(urlDatabase.Has([]byte("permanent"))).To(BeTrue())
+			})
+		})
+	})
+})
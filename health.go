@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessProbeKey is the key looked up to check that the URL database can be read. As it
+// contains a slash, it can never be a URL key.
+const readinessProbeKey = "health/probe"
+
+// HealthController contains logic and data related to the /healthz and /readyz routes.
+type HealthController struct {
+	URLDatabase URLDatabase
+}
+
+// Healthz implements the logic for the /healthz route, which reports that the process is up.
+func (c *HealthController) Healthz(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// Readyz implements the logic for the /readyz route, which reports whether requests can be
+// served, which is when a lookup in the URL database succeeds.
+func (c *HealthController) Readyz(context *gin.Context) {
+	if _, err := c.URLDatabase.Has([]byte(readinessProbeKey)); err != nil {
+		apiErr := NewAPIError(http.StatusServiceUnavailable, ErrorCodeUnavailable, "The URL database is unavailable")
+		apiErr.Err = err
+		respondWithError(context, apiErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
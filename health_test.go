@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Health checks", func() {
+	var urlDatabase *storage.Memory
+	var router http.Handler
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		// The database may already have been closed by the spec.
+		DeferCleanup(func() { urlDatabase.Close() })
+
+		router, _ = initializeRouter(urlDatabase, DefaultConfig())
+	})
+
+	send := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, request)
+		return writer
+	}
+
+	It("reports the process as alive", func() {
+		writer := send(router, "GET", "/healthz", "")
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Body.String()).To(MatchJSON(`{"status": "ok"}`))
+	})
+
+	It("reports the service as ready when the database can be read", func() {
+		writer := send(router, "GET", "/readyz", "")
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Body.String()).To(MatchJSON(`{"status": "ok"}`))
+	})
+
+	It("reports the service as unavailable once the database is closed", func() {
+		Expect(urlDatabase.Close()).To(Succeed())
+
+		writer := send(router, "GET", "/readyz", "")
+
+		Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeUnavailable))
+	})
+
+	It("reports the service as unavailable when the database fails", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockURLDatabase := mocks.NewMockURLDatabase(ctrl)
+		mockURLDatabase.EXPECT().Has([]byte(readinessProbeKey)).Return(false, errors.New("disk failure"))
+		mockRouter, _ := initializeRouter(mockURLDatabase, DefaultConfig())
+
+		Expect(send(mockRouter, "GET", "/readyz", "").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	DescribeTable("reserves the health check routes",
+		func(key string) {
+			writer := send(router, "POST", "/shorten", `{"url": "https://example.com/", "key": "`+key+`"}`)
+
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+			Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeKeyReserved))
+		},
+		Entry("healthz", "healthz"),
+		Entry("readyz", "readyz"),
+	)
+})
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultCustomKeyCharacters defines the characters allowed in custom keys by default,
+	// which is the URL-safe Base 64 alphabet used by generated keys.
+	DefaultCustomKeyCharacters = "A-Za-z0-9_-"
+
+	// DefaultMinCustomKeySize determines the default minimum size of a custom key.
+	DefaultMinCustomKeySize = 1
+
+	// forbiddenKeyCharacters can never be part of a key, as they would make it unreachable
+	// through the redirect route regardless of the configured character set.
+	forbiddenKeyCharacters = "/?#% "
+)
+
+// Rules of the custom key validation policy, reported when a key violates them.
+const (
+	KeyRuleMinLength = "min_length"
+	KeyRuleMaxLength = "max_length"
+	KeyRuleCharset   = "charset"
+	KeyRuleReserved  = "reserved"
+)
+
+// KeyValidationError describes the rule of the key validation policy violated by a custom key.
+type KeyValidationError struct {
+	Rule    string
+	Message string
+}
+
+// Error returns the description of the violated rule.
+func (e *KeyValidationError) Error() string {
+	return e.Message
+}
+
+// KeyPolicy validates custom keys.
+type KeyPolicy struct {
+	// MinLength determines the minimum size of a custom key.
+	MinLength int
+	// MaxLength determines the maximum size of a custom key.
+	MaxLength int
+	// Characters describes the allowed characters as the contents of a regular expression bracket expression.
+	Characters string
+	// CaseInsensitive folds custom keys to lowercase, so that keys differing only in case are the same.
+	CaseInsensitive bool
+
+	charsetPattern *regexp.Regexp
+	reserved       map[string]bool
+}
+
+// NewKeyPolicy creates a key validation policy from the service configuration.
+func NewKeyPolicy(config *Config) (*KeyPolicy, error) {
+	charsetPattern, err := compileKeyCharset(config.CustomKeyCharacters)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &KeyPolicy{
+		MinLength:       config.MinCustomKeySize,
+		MaxLength:       config.CustomKeySizeLimit,
+		Characters:      config.CustomKeyCharacters,
+		CaseInsensitive: config.CaseInsensitiveKeys,
+		charsetPattern:  charsetPattern,
+		reserved:        map[string]bool{},
+	}
+	policy.Reserve(config.ReservedKeys...)
+
+	return policy, nil
+}
+
+// Reserve prevents words from being used as custom keys. Reserved words are matched
+// regardless of case, as they would otherwise be easily confused with routes.
+func (p *KeyPolicy) Reserve(words ...string) {
+	for _, word := range words {
+		p.reserved[strings.ToLower(word)] = true
+	}
+}
+
+// Normalize returns the form in which a custom key is stored.
+func (p *KeyPolicy) Normalize(key string) string {
+	if p.CaseInsensitive {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// Validate checks a custom key against the policy, returning a *KeyValidationError
+// describing the first rule it violates.
+func (p *KeyPolicy) Validate(key string) error {
+	if len(key) < p.MinLength {
+		return &KeyValidationError{
+			Rule:    KeyRuleMinLength,
+			Message: fmt.Sprintf("key must be at least %d characters long", p.MinLength),
+		}
+	}
+
+	if len(key) > p.MaxLength {
+		return &KeyValidationError{
+			Rule:    KeyRuleMaxLength,
+			Message: fmt.Sprintf("key must be at most %d characters long", p.MaxLength),
+		}
+	}
+
+	if strings.ContainsAny(key, forbiddenKeyCharacters) || !p.charsetPattern.MatchString(key) {
+		return &KeyValidationError{
+			Rule:    KeyRuleCharset,
+			Message: fmt.Sprintf("key may only contain the characters %s", p.Characters),
+		}
+	}
+
+	if p.reserved[strings.ToLower(key)] {
+		return &KeyValidationError{
+			Rule:    KeyRuleReserved,
+			Message: fmt.Sprintf("key %q is reserved", key),
+		}
+	}
+
+	return nil
+}
+
+// ValidateStored checks a key which is kept as given, such as an imported key, returning a
+// *KeyValidationError describing the first rule it violates. As the key may have been generated
+// rather than chosen, only the rules keeping it reachable through the redirect route apply.
+func (p *KeyPolicy) ValidateStored(key string) error {
+	if strings.ContainsAny(key, forbiddenKeyCharacters) || strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return &KeyValidationError{
+			Rule:    KeyRuleCharset,
+			Message: fmt.Sprintf("key may not contain control characters or any of %q", forbiddenKeyCharacters),
+		}
+	}
+
+	if p.reserved[strings.ToLower(key)] {
+		return &KeyValidationError{
+			Rule:    KeyRuleReserved,
+			Message: fmt.Sprintf("key %q is reserved", key),
+		}
+	}
+
+	return nil
+}
+
+// compileKeyCharset compiles a pattern matching strings made only of the given characters.
+func compileKeyCharset(characters string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile(fmt.Sprintf("^[%s]+$", characters))
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom key characters %q: %w", characters, err)
+	}
+	return pattern, nil
+}
+
+// routeKeys returns the first segment of every static route path, which would be
+// shadowed by the route should a custom key with the same name be created.
+func routeKeys(paths []string) []string {
+	keys := []string{}
+	for _, path := range paths {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if segment != "" && !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			keys = append(keys, segment)
+		}
+	}
+	return keys
+}
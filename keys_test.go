@@ -0,0 +1,91 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyPolicy", func() {
+	var config *Config
+	var policy *KeyPolicy
+
+	BeforeEach(func() {
+		config = DefaultConfig()
+		config.MinCustomKeySize = 3
+		config.ReservedKeys = []string{"admin"}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		policy, err = NewKeyPolicy(config)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	DescribeTable("validating custom keys",
+		func(key, expectedRule string) {
+			err := policy.Validate(key)
+			if expectedRule == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+
+			var keyValidationError *KeyValidationError
+			Expect(err).To(BeAssignableToTypeOf(keyValidationError))
+			Expect(err.(*KeyValidationError).Rule).To(Equal(expectedRule))
+		},
+		Entry("accepts keys in the character set", "my_docs-2", ""),
+		Entry("rejects keys which are too short", "ab", KeyRuleMinLength),
+		Entry("rejects keys which are too long", "abcdefghijklmnopqrstuvwxyz0123456789", KeyRuleMaxLength),
+		Entry("rejects keys outside the character set", "docs.html", KeyRuleCharset),
+		Entry("rejects configured reserved words", "Admin", KeyRuleReserved),
+	)
+
+	When("the character set is customized", func() {
+		BeforeEach(func() {
+			config.CustomKeyCharacters = "a-z./%"
+		})
+
+		It("accepts keys in the character set", func() {
+			Expect(policy.Validate("docs.html")).To(Succeed())
+		})
+
+		It("still rejects characters which make keys unreachable", func() {
+			Expect(policy.Validate("docs/intro")).To(MatchError(ContainSubstring("characters")))
+			Expect(policy.Validate("docs%20")).To(MatchError(ContainSubstring("characters")))
+		})
+	})
+
+	When("words are reserved after creation", func() {
+		JustBeforeEach(func() {
+			policy.Reserve("healthz")
+		})
+
+		It("rejects the reserved words", func() {
+			Expect(policy.Validate("healthz")).To(MatchError(ContainSubstring("reserved")))
+		})
+	})
+
+	Describe("Normalize", func() {
+		It("keeps the case of keys by default", func() {
+			Expect(policy.Normalize("Docs")).To(Equal("Docs"))
+		})
+
+		When("keys are case insensitive", func() {
+			BeforeEach(func() {
+				config.CaseInsensitiveKeys = true
+			})
+
+			It("folds keys to lowercase", func() {
+				Expect(policy.Normalize("Docs")).To(Equal("docs"))
+			})
+		})
+	})
+
+	Describe("routeKeys", func() {
+		It("returns the first static segment of route paths", func() {
+			Expect(routeKeys([]string{"/shorten", "/:key", "/:key/stats", "/api/links/:key", "/*any"})).To(
+				Equal([]string{"shorten", "api"}),
+			)
+		})
+	})
+})
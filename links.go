@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// ErrLinkKeyTaken is returned when a link is moved to a key which is already in use.
+var ErrLinkKeyTaken = errors.New("key is already in use")
+
+const (
+	// DefaultLinkListLimit is the number of links listed by the /api/links route by default.
+	DefaultLinkListLimit = 100
+	// MaxLinkListLimit is the maximum number of links listed by the /api/links route at once.
+	MaxLinkListLimit = 1000
+)
+
+// LinkUpdateRequest represents a request to update a link through the /api/links/:key route.
+// Only the fields which are provided are updated.
+type LinkUpdateRequest struct {
+	// Key contains a new custom key under which the link, and its click statistics, are moved.
+	Key *string `json:"key,omitempty"`
+	// URL contains a new target for the link.
+	URL *string `json:"url,omitempty"`
+	// ExpiresAt contains a new time after which the link stops redirecting.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTLSeconds contains a new number of seconds after which the link stops redirecting.
+	TTLSeconds *int64 `json:"ttl_seconds,omitempty"`
+	// ClearExpiry removes the expiry of the link, so that it never expires.
+	ClearExpiry bool `json:"clear_expiry,omitempty"`
+	// RedirectType contains a new HTTP status code with which the link redirects.
+	RedirectType *int `json:"redirect_type,omitempty" binding:"omitempty,oneof=301 302 307 308"`
+	// Tags contains new labels replacing those attached to the link.
+	Tags *[]string `json:"tags,omitempty"`
+}
+
+// LinkListRequest represents a request to list links through the /api/links route.
+type LinkListRequest struct {
+	// After contains the key after which links are listed, in key order.
+	After string `form:"after" json:"after"`
+	// Limit contains the maximum number of links listed.
+	Limit int `form:"limit" json:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+// LinkListResponse represents a page of links returned by the /api/links route.
+type LinkListResponse struct {
+	Links []LinkResponse `json:"links"`
+	// Next contains the key after which the following page is listed, when there is one.
+	Next string `json:"next,omitempty"`
+}
+
+// LinkResponse represents a link returned by the /api/links/:key route.
+type LinkResponse struct {
+	Key          string `json:"key"`
+	ShortenedURL string `json:"shortened_url"`
+	*LinkRecord
+}
+
+// LinkController contains logic and data related to the /api/links/:key route.
+type LinkController struct {
+	URLDatabase       URLDatabase
+	Config            *Config
+	URLPrefixResolver *URLPrefixResolver
+	KeyPolicy         *KeyPolicy
+}
+
+// List implements the logic for listing links in key order, a page at a time.
+func (c *LinkController) List(context *gin.Context) {
+	var listRequest LinkListRequest
+	if err := context.ShouldBindQuery(&listRequest); err != nil {
+		respondWithError(context, errBinding(err, &listRequest))
+		return
+	}
+	if listRequest.Limit == 0 {
+		listRequest.Limit = DefaultLinkListLimit
+	}
+
+	// Listing starts at the smallest key following the one given.
+	var start []byte
+	if listRequest.After != "" {
+		start = append([]byte(listRequest.After), 0)
+	}
+
+	response := LinkListResponse{Links: []LinkResponse{}}
+	err := forEachLinkFrom(c.URLDatabase, start, func(URLKey, value []byte) error {
+		if len(response.Links) == listRequest.Limit {
+			response.Next = response.Links[len(response.Links)-1].Key
+			return errStopLinks
+		}
+
+		record, err := decodeStoredLink(c.URLDatabase, URLKey, value)
+		if err != nil {
+			return err
+		}
+		response.Links = append(response.Links, c.linkResponse(context, string(URLKey), record))
+		return nil
+	})
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// Get implements the logic for retrieving a link.
+func (c *LinkController) Get(context *gin.Context) {
+	URLKey, record, err := FindLinkRecord(c.URLDatabase, c.KeyPolicy, context.Param("key"))
+	if err != nil {
+		c.respondWithLookupError(context, err)
+		return
+	}
+
+	context.JSON(http.StatusOK, c.linkResponse(context, URLKey, record))
+}
+
+// Update implements the logic for updating a link.
+func (c *LinkController) Update(context *gin.Context) {
+	var updateRequest LinkUpdateRequest
+
+	if err := context.ShouldBindJSON(&updateRequest); err != nil {
+		respondWithError(context, errBinding(err, &updateRequest))
+		return
+	}
+
+	URLKey, _, err := FindLinkRecord(c.URLDatabase, c.KeyPolicy, context.Param("key"))
+	if err != nil {
+		c.respondWithLookupError(context, err)
+		return
+	}
+
+	newURLKey := URLKey
+	if updateRequest.Key != nil {
+		newURLKey = c.KeyPolicy.Normalize(*updateRequest.Key)
+
+		var keyValidationError *KeyValidationError
+		if err := c.KeyPolicy.Validate(newURLKey); errors.As(err, &keyValidationError) {
+			respondWithError(context, errKey(keyValidationError))
+			return
+		}
+	}
+
+	var normalizedURL string
+	if updateRequest.URL != nil {
+		if normalizedURL, err = NormalizeURL(*updateRequest.URL, c.Config.AllowedSchemes, c.Config.MaxURLLength); err != nil {
+			respondWithError(context, errURL(err, "url"))
+			return
+		}
+	}
+
+	now := time.Now()
+
+	expiresAt, err := requestedExpiry(updateRequest.ExpiresAt, updateRequest.TTLSeconds, now)
+	if err == nil && updateRequest.ClearExpiry && !expiresAt.IsZero() {
+		err = ErrExpiryConflict
+	}
+	if err != nil {
+		respondWithError(context, errExpiry(err))
+		return
+	}
+
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	// The record is read again under lock, so that concurrent updates are applied in turn.
+	record, _, err := readLinkRecord(c.URLDatabase, []byte(URLKey))
+	if err != nil {
+		c.respondWithLookupError(context, err)
+		return
+	}
+
+	if !requestPrincipal(context).CanManage(record) {
+		respondWithError(context, errForbidden())
+		return
+	}
+
+	if updateRequest.URL != nil {
+		record.URL = normalizedURL
+	}
+	if !expiresAt.IsZero() {
+		record.ExpiresAt = &expiresAt
+	}
+	if updateRequest.ClearExpiry {
+		record.ExpiresAt = nil
+	}
+	if updateRequest.RedirectType != nil {
+		record.RedirectType = *updateRequest.RedirectType
+	}
+	if updateRequest.Tags != nil {
+		record.Tags = *updateRequest.Tags
+	}
+	record.Version = LinkRecordVersion
+
+	if newURLKey == URLKey {
+		err = writeLinkRecord(c.URLDatabase, []byte(URLKey), record)
+	} else {
+		err = c.moveLink(URLKey, newURLKey, record, now)
+	}
+
+	if errors.Is(err, ErrLinkKeyTaken) {
+		respondWithError(context, NewAPIError(http.StatusConflict, ErrorCodeKeyInUse, err.Error()).
+			WithField("key").
+			WithDetail("key", newURLKey))
+		return
+	}
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.JSON(http.StatusOK, c.linkResponse(context, newURLKey, record))
+}
+
+// Delete implements the logic for deleting a link along with its click statistics.
+func (c *LinkController) Delete(context *gin.Context) {
+	URLKey := context.Param("key")
+
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	record, _, err := readLinkRecord(c.URLDatabase, []byte(URLKey))
+	if err == storage.ErrNotFound {
+		if normalizedKey := c.KeyPolicy.Normalize(URLKey); normalizedKey != URLKey {
+			URLKey = normalizedKey
+			record, _, err = readLinkRecord(c.URLDatabase, []byte(URLKey))
+		}
+	}
+
+	if err != nil {
+		c.respondWithLookupError(context, err)
+		return
+	}
+
+	if !requestPrincipal(context).CanManage(record) {
+		respondWithError(context, errForbidden())
+		return
+	}
+
+	// The link, its legacy expiry and its click events are deleted atomically, so that a link
+	// later created with the same key does not inherit them.
+	batch := new(storage.Batch)
+	if err = deleteLinkEntries(c.URLDatabase, batch, []byte(URLKey)); err == nil {
+		batch.Delete([]byte(URLKey))
+		err = c.URLDatabase.Write(batch)
+	}
+
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+// moveLink atomically moves a link and its click events to a new key, which must be free
+// or hold an expired link. The caller must hold urlKeyMutex.
+func (c *LinkController) moveLink(URLKey, newURLKey string, record *LinkRecord, now time.Time) error {
+	existingRecord, _, err := readLinkRecord(c.URLDatabase, []byte(newURLKey))
+	if err == nil && !existingRecord.IsExpired(now) {
+		return fmt.Errorf("%w: %s", ErrLinkKeyTaken, newURLKey)
+	}
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+
+	value, err := record.Encode()
+	if err != nil {
+		return err
+	}
+
+	batch := new(storage.Batch)
+
+	// The entries of an expired link held by the new key must not be merged into those moved.
+	if err = deleteLinkEntries(c.URLDatabase, batch, []byte(newURLKey)); err != nil {
+		return err
+	}
+
+	err = forEachClick(c.URLDatabase, URLKey, func(clickKey, clickValue []byte) {
+		var event ClickEvent
+		if json.Unmarshal(clickValue, &event) == nil {
+			event.Key = newURLKey
+			if movedValue, err := json.Marshal(event); err == nil {
+				clickValue = movedValue
+			}
+		}
+
+		suffix := clickKey[len(ClickKeyPrefix)+len(URLKey):]
+		batch.Put(append([]byte(ClickKeyPrefix+newURLKey), suffix...), clickValue)
+		batch.Delete(clickKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The expiry of the record, legacy or not, is encoded in its value, so the legacy expiry
+	// of the old key is removed rather than left for a later value to inherit.
+	batch.Put([]byte(newURLKey), value)
+	batch.Delete([]byte(URLKey))
+	batch.Delete(legacyExpiryKey([]byte(URLKey)))
+	return c.URLDatabase.Write(batch)
+}
+
+// linkResponse builds the representation of a link returned by the API.
+func (c *LinkController) linkResponse(context *gin.Context, URLKey string, record *LinkRecord) LinkResponse {
+	return LinkResponse{
+		Key:          URLKey,
+		ShortenedURL: fmt.Sprintf("%s/%s", c.URLPrefixResolver.Prefix(context.Request), URLKey),
+		LinkRecord:   record,
+	}
+}
+
+// respondWithLookupError responds to a failure to retrieve the record of a link.
+func (c *LinkController) respondWithLookupError(context *gin.Context, err error) {
+	if err == storage.ErrNotFound {
+		respondWithError(context, errNotFound())
+		return
+	}
+
+	respondWithError(context, errInternal(err))
+}
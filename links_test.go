@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Link management API", func() {
+	const (
+		exampleUrl  = "https://en.wikipedia.org/wiki/URL_shortening"
+		urlKey      = "oROh-p8o"
+		owner       = "alice"
+		adminAPIKey = "admin-secret"
+	)
+
+	var config *Config
+	var urlDatabase *storage.Memory
+	var router *gin.Engine
+	var writer *httptest.ResponseRecorder
+	var method string
+	var requestBody []byte
+	var apiKey string
+
+	createdAt := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	ownedLinkRecord := func(URL string, expiresAt time.Time) *LinkRecord {
+		record := NewLinkRecord(URL, createdAt, expiresAt)
+		record.Creator = owner
+		return record
+	}
+
+	BeforeEach(func() {
+		var err error
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		Expect(writeLinkRecord(urlDatabase, []byte(urlKey), ownedLinkRecord(exampleUrl, time.Time{}))).To(Succeed())
+		Expect(RecordClick(urlDatabase, ClickEvent{Key: urlKey, Timestamp: createdAt})).To(Succeed())
+
+		apiKey, _, err = CreateAPIKey(urlDatabase, owner, false, createdAt)
+		Expect(err).NotTo(HaveOccurred())
+
+		config = DefaultConfig()
+		config.AdminAPIKey = adminAPIKey
+		writer = httptest.NewRecorder()
+		requestBody = nil
+	})
+
+	JustBeforeEach(func() {
+		router, _ = initializeRouter(urlDatabase, config)
+		request, _ := http.NewRequest(method, fmt.Sprintf("/api/links/%s", urlKey), bytes.NewReader(requestBody))
+		if apiKey != "" {
+			request.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		router.ServeHTTP(writer, request)
+	})
+
+	responseContent := func() map[string]interface{} {
+		content := map[string]interface{}{}
+		Expect(json.Unmarshal(writer.Body.Bytes(), &content)).To(Succeed())
+		return content
+	}
+
+	storedRecord := func(key string) *LinkRecord {
+		record, _, err := readLinkRecord(urlDatabase, []byte(key))
+		Expect(err).NotTo(HaveOccurred())
+		return record
+	}
+
+	Describe("GET /api/links/:key", func() {
+		BeforeEach(func() {
+			method = "GET"
+		})
+
+		It("returns a 200", func() {
+			Expect(writer.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns the link", func() {
+			content := responseContent()
+			Expect(content["key"]).To(Equal(urlKey))
+			Expect(content["shortened_url"]).To(Equal(fmt.Sprintf("%s/%s", DefaultURLPrefix, urlKey)))
+			Expect(content["url"]).To(Equal(exampleUrl))
+			Expect(content["created_at"]).To(Equal("2022-06-01T12:00:00Z"))
+		})
+
+		Context("and the URL key is not present in database", func() {
+			BeforeEach(func() {
+				Expect(urlDatabase.Delete([]byte(urlKey))).To(Succeed())
+			})
+
+			It("returns a 404", func() {
+				Expect(writer.Code).To(Equal(http.StatusNotFound))
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeNotFound))
+			})
+		})
+	})
+
+	Describe("PATCH /api/links/:key", func() {
+		BeforeEach(func() {
+			method = "PATCH"
+			requestBody = []byte(`{"tags": ["docs"]}`)
+		})
+
+		Context("and the URL, redirect type and tags are updated", func() {
+			BeforeEach(func() {
+				requestBody = []byte(`{"url": "HTTPS://Example.com", "redirect_type": 301, "tags": ["docs"]}`)
+			})
+
+			It("returns the updated link", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+				Expect(responseContent()["url"]).To(Equal("https://example.com/"))
+			})
+
+			It("stores the updated record", func() {
+				record := storedRecord(urlKey)
+				Expect(record.URL).To(Equal("https://example.com/"))
+				Expect(record.RedirectType).To(Equal(http.StatusMovedPermanently))
+				Expect(record.Tags).To(Equal([]string{"docs"}))
+				Expect(record.CreatedAt).To(Equal(createdAt))
+			})
+		})
+
+		Context("and a TTL is provided", func() {
+			BeforeEach(func() {
+				requestBody = []byte(`{"ttl_seconds": 3600}`)
+			})
+
+			It("sets the expiry of the link", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+				Expect(*storedRecord(urlKey).ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			})
+		})
+
+		Context("and the expiry is cleared", func() {
+			BeforeEach(func() {
+				record := ownedLinkRecord(exampleUrl, time.Now().Add(time.Hour))
+				Expect(writeLinkRecord(urlDatabase, []byte(urlKey), record)).To(Succeed())
+				requestBody = []byte(`{"clear_expiry": true}`)
+			})
+
+			It("removes the expiry of the link", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+				Expect(storedRecord(urlKey).ExpiresAt).To(BeNil())
+			})
+		})
+
+		for _, body := range []string{
+			`{"url": "ftp://example.com"}`,
+			`{"redirect_type": 303}`,
+			`{"ttl_seconds": -1}`,
+			`{"ttl_seconds": 60, "clear_expiry": true}`,
+			`{"url": 5}`,
+		} {
+			body := body
+
+			Context(fmt.Sprintf("and the request is invalid (%s)", body), func() {
+				BeforeEach(func() {
+					requestBody = []byte(body)
+				})
+
+				It("returns a 400 and leaves the link unchanged", func() {
+					Expect(writer.Code).To(Equal(http.StatusBadRequest))
+					Expect(storedRecord(urlKey)).To(Equal(ownedLinkRecord(exampleUrl, time.Time{})))
+				})
+			})
+		}
+
+		Context("and the key is renamed", func() {
+			const newUrlKey = "wiki"
+
+			BeforeEach(func() {
+				requestBody = []byte(fmt.Sprintf(`{"key": "%s"}`, newUrlKey))
+			})
+
+			It("returns the link under its new key", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+				Expect(responseContent()["key"]).To(Equal(newUrlKey))
+			})
+
+			It("moves the record", func() {
+				Expect(storedRecord(newUrlKey).URL).To(Equal(exampleUrl))
+				_, err := urlDatabase.Get([]byte(urlKey))
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+
+			It("moves the click statistics", func() {
+				statistics, err := GetClickStatistics(urlDatabase, newUrlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(1))
+
+				statistics, err = GetClickStatistics(urlDatabase, urlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+			})
+
+			Context("and the new key is held by an expired legacy link", func() {
+				BeforeEach(func() {
+					expiresAt := createdAt.Add(time.Hour)
+					Expect(urlDatabase.Put([]byte(newUrlKey), []byte("https://example.com/"))).To(Succeed())
+					Expect(urlDatabase.Put(legacyExpiryKey([]byte(newUrlKey)), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+				})
+
+				It("replaces the expired link and removes its legacy expiry", func() {
+					Expect(writer.Code).To(Equal(http.StatusOK))
+					Expect(storedRecord(newUrlKey).URL).To(Equal(exampleUrl))
+
+					has, err := urlDatabase.Has(legacyExpiryKey([]byte(newUrlKey)))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(has).To(BeFalse())
+				})
+			})
+
+			Context("and the new key is already in use", func() {
+				BeforeEach(func() {
+					record := NewLinkRecord("https://example.com/", createdAt, time.Time{})
+					Expect(writeLinkRecord(urlDatabase, []byte(newUrlKey), record)).To(Succeed())
+				})
+
+				It("returns a 409", func() {
+					Expect(writer.Code).To(Equal(http.StatusConflict))
+					Expect(responseContent()["code"]).To(Equal(ErrorCodeKeyInUse))
+					Expect(responseContent()["key"]).To(Equal(newUrlKey))
+				})
+
+				It("leaves both links unchanged", func() {
+					Expect(storedRecord(urlKey).URL).To(Equal(exampleUrl))
+					Expect(storedRecord(newUrlKey).URL).To(Equal("https://example.com/"))
+				})
+			})
+
+			Context("and the new key is held by an expired link", func() {
+				BeforeEach(func() {
+					record := NewLinkRecord("https://example.com/", createdAt, createdAt.Add(time.Hour))
+					Expect(writeLinkRecord(urlDatabase, []byte(newUrlKey), record)).To(Succeed())
+					Expect(RecordClick(urlDatabase, ClickEvent{Key: newUrlKey, Timestamp: createdAt})).To(Succeed())
+				})
+
+				It("replaces the expired link and its click statistics", func() {
+					Expect(writer.Code).To(Equal(http.StatusOK))
+					Expect(storedRecord(newUrlKey).URL).To(Equal(exampleUrl))
+
+					statistics, err := GetClickStatistics(urlDatabase, newUrlKey)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(statistics.TotalClicks).To(Equal(1))
+				})
+			})
+		})
+
+		Context("and the new key is reserved", func() {
+			BeforeEach(func() {
+				requestBody = []byte(`{"key": "shorten"}`)
+			})
+
+			It("returns a 400 identifying the rule", func() {
+				Expect(writer.Code).To(Equal(http.StatusBadRequest))
+				Expect(responseContent()["code"]).To(Equal(ErrorCodeKeyReserved))
+			})
+		})
+
+		Context("and the URL key is not present in database", func() {
+			BeforeEach(func() {
+				Expect(urlDatabase.Delete([]byte(urlKey))).To(Succeed())
+				requestBody = []byte(`{"tags": ["docs"]}`)
+			})
+
+			It("returns a 404", func() {
+				Expect(writer.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("and no API key is provided", func() {
+			BeforeEach(func() {
+				apiKey = ""
+			})
+
+			It("returns a 401", func() {
+				Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+				Expect(writer.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			})
+		})
+
+		Context("and the API key belongs to another owner", func() {
+			BeforeEach(func() {
+				var err error
+				apiKey, _, err = CreateAPIKey(urlDatabase, "mallory", false, createdAt)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a 403 and leaves the link unchanged", func() {
+				Expect(writer.Code).To(Equal(http.StatusForbidden))
+				Expect(storedRecord(urlKey)).To(Equal(ownedLinkRecord(exampleUrl, time.Time{})))
+			})
+		})
+
+		Context("and the admin API key is provided", func() {
+			BeforeEach(func() {
+				apiKey = adminAPIKey
+			})
+
+			It("is allowed", func() {
+				Expect(writer.Code).To(BeNumerically("<", http.StatusMultipleChoices))
+			})
+		})
+	})
+
+	Describe("DELETE /api/links/:key", func() {
+		BeforeEach(func() {
+			method = "DELETE"
+		})
+
+		It("returns a 204", func() {
+			Expect(writer.Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("deletes the link and its click statistics", func() {
+			has, err := urlDatabase.Has([]byte(urlKey))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(has).To(BeFalse())
+
+			statistics, err := GetClickStatistics(urlDatabase, urlKey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(statistics.TotalClicks).To(Equal(0))
+		})
+
+		Context("and the link is a legacy value with an expiry", func() {
+			BeforeEach(func() {
+				expiresAt := time.Now().Add(time.Hour)
+				Expect(urlDatabase.Put([]byte(urlKey), []byte(exampleUrl))).To(Succeed())
+				Expect(urlDatabase.Put(legacyExpiryKey([]byte(urlKey)), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+				apiKey = adminAPIKey
+			})
+
+			It("deletes the legacy expiry along with the link", func() {
+				Expect(writer.Code).To(Equal(http.StatusNoContent))
+
+				has, err := urlDatabase.Has(legacyExpiryKey([]byte(urlKey)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(has).To(BeFalse())
+			})
+		})
+
+		Context("and the URL key is not present in database", func() {
+			BeforeEach(func() {
+				Expect(urlDatabase.Delete([]byte(urlKey))).To(Succeed())
+			})
+
+			It("returns a 404", func() {
+				Expect(writer.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("and no API key is provided", func() {
+			BeforeEach(func() {
+				apiKey = ""
+			})
+
+			It("returns a 401", func() {
+				Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+				Expect(writer.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			})
+		})
+
+		Context("and the API key belongs to another owner", func() {
+			BeforeEach(func() {
+				var err error
+				apiKey, _, err = CreateAPIKey(urlDatabase, "mallory", false, createdAt)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a 403 and leaves the link unchanged", func() {
+				Expect(writer.Code).To(Equal(http.StatusForbidden))
+				Expect(storedRecord(urlKey)).To(Equal(ownedLinkRecord(exampleUrl, time.Time{})))
+			})
+		})
+
+		Context("and the admin API key is provided", func() {
+			BeforeEach(func() {
+				apiKey = adminAPIKey
+			})
+
+			It("is allowed", func() {
+				Expect(writer.Code).To(BeNumerically("<", http.StatusMultipleChoices))
+			})
+		})
+	})
+
+	Describe("and there is an error accessing the database", func() {
+		var mockURLDatabase *mocks.MockURLDatabase
+
+		BeforeEach(func() {
+			ctrl := gomock.NewController(GinkgoT())
+			mockURLDatabase = mocks.NewMockURLDatabase(ctrl)
+			method = "GET"
+		})
+
+		It("returns a 500 when retrieving a link", func() {
+			mockURLDatabase.EXPECT().Get([]byte(urlKey)).Return(nil, errors.New("failed to query database"))
+
+			router, _ := initializeRouter(mockURLDatabase, DefaultConfig())
+			request, _ := http.NewRequest("GET", fmt.Sprintf("/api/links/%s", urlKey), nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(errorCode(recorder.Body.Bytes())).To(Equal(ErrorCodeInternal))
+		})
+
+		It("returns a 500 when deleting a link", func() {
+			mockURLDatabase.EXPECT().Get([]byte(urlKey)).Return(nil, errors.New("failed to query database"))
+
+			router, _ := initializeRouter(mockURLDatabase, config)
+			request, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/links/%s", urlKey), nil)
+			request.Header.Set("X-API-Key", adminAPIKey)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
+
+var _ = Describe("GET /api/links", func() {
+	const adminAPIKey = "admin-secret"
+
+	var urlDatabase *storage.Memory
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		createdAt := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+		for _, key := range []string{"c", "a", "b"} {
+			Expect(writeLinkRecord(urlDatabase, []byte(key), NewLinkRecord("https://example.com/"+key, createdAt, time.Time{}))).To(Succeed())
+		}
+		Expect(RecordClick(urlDatabase, ClickEvent{Key: "a", Timestamp: createdAt})).To(Succeed())
+		Expect(urlDatabase.Put([]byte("legacy"), []byte("https://example.com/legacy"))).To(Succeed())
+	})
+
+	list := func(query, apiKey string) *httptest.ResponseRecorder {
+		config := DefaultConfig()
+		config.AdminAPIKey = adminAPIKey
+		router, err := initializeRouter(urlDatabase, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/api/links"+query, nil)
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(writer, request)
+		return writer
+	}
+
+	listedKeys := func(writer *httptest.ResponseRecorder) ([]string, string) {
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		var response LinkListResponse
+		Expect(json.Unmarshal(writer.Body.Bytes(), &response)).To(Succeed())
+
+		keys := []string{}
+		for _, link := range response.Links {
+			keys = append(keys, link.Key)
+		}
+		return keys, response.Next
+	}
+
+	It("returns the links in key order", func() {
+		keys, next := listedKeys(list("", adminAPIKey))
+		Expect(keys).To(Equal([]string{"a", "b", "c", "legacy"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("returns the links a page at a time", func() {
+		keys, next := listedKeys(list("?limit=2", adminAPIKey))
+		Expect(keys).To(Equal([]string{"a", "b"}))
+		Expect(next).To(Equal("b"))
+
+		keys, next = listedKeys(list("?limit=2&after=b", adminAPIKey))
+		Expect(keys).To(Equal([]string{"c", "legacy"}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("returns a 400 when the limit is too large", func() {
+		writer := list(fmt.Sprintf("?limit=%d", MaxLinkListLimit+1), adminAPIKey)
+		Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInvalidField))
+	})
+
+	It("returns a 403 to API keys without administrative access", func() {
+		apiKey, _, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list("", apiKey).Code).To(Equal(http.StatusForbidden))
+	})
+})
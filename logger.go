@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// LogLevel ranks log entries by severity.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+const (
+	// LogFormatText formats log entries as logfmt-style key=value pairs.
+	LogFormatText = "text"
+	// LogFormatJSON formats log entries as JSON objects, one per line.
+	LogFormatJSON = "json"
+
+	// DefaultLogLevel defines the minimum level of the entries logged by default.
+	DefaultLogLevel = "info"
+	// DefaultLogFormat defines the format of log entries by default.
+	DefaultLogFormat = LogFormatText
+)
+
+// logLevelNames maps log levels to their names.
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+}
+
+// logger is the logger of the service, which is configured on startup.
+var logger = NewLogger(os.Stderr, LogLevelInfo, LogFormatText)
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLogLevel returns the log level of a name, such as "info".
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LogLevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+}
+
+// Logger writes leveled, structured log entries. Entries hold a message along with fields
+// given as alternating keys and values, such as Info("link shortened", "key", URLKey).
+type Logger struct {
+	writer io.Writer
+	mutex  *sync.Mutex
+	level  LogLevel
+	format string
+	fields []interface{}
+}
+
+// NewLogger creates a logger writing the entries of at least the given level in a format.
+func NewLogger(writer io.Writer, level LogLevel, format string) *Logger {
+	return &Logger{
+		writer: writer,
+		mutex:  &sync.Mutex{},
+		level:  level,
+		format: format,
+	}
+}
+
+// With returns a logger adding fields to every entry, writing to the same destination.
+func (l *Logger) With(fields ...interface{}) *Logger {
+	derived := *l
+	derived.fields = append(append([]interface{}{}, l.fields...), fields...)
+	return &derived
+}
+
+// Enabled determines whether entries of a level are written.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+// Debug logs an entry at debug level.
+func (l *Logger) Debug(message string, fields ...interface{}) {
+	l.Log(LogLevelDebug, message, fields...)
+}
+
+// Info logs an entry at info level.
+func (l *Logger) Info(message string, fields ...interface{}) {
+	l.Log(LogLevelInfo, message, fields...)
+}
+
+// Warn logs an entry at warn level.
+func (l *Logger) Warn(message string, fields ...interface{}) {
+	l.Log(LogLevelWarn, message, fields...)
+}
+
+// Error logs an entry at error level.
+func (l *Logger) Error(message string, fields ...interface{}) {
+	l.Log(LogLevelError, message, fields...)
+}
+
+// Log writes an entry at a level, unless the level is below that of the logger.
+func (l *Logger) Log(level LogLevel, message string, fields ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := []interface{}{"time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", message}
+	entry = append(append(entry, l.fields...), fields...)
+
+	var line bytes.Buffer
+	if l.format == LogFormatJSON {
+		writeJSONEntry(&line, entry)
+	} else {
+		writeTextEntry(&line, entry)
+	}
+	line.WriteByte('\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.writer.Write(line.Bytes())
+}
+
+// writeTextEntry formats the fields of an entry as key=value pairs, quoting values as needed.
+func writeTextEntry(line *bytes.Buffer, entry []interface{}) {
+	for index := 0; index < len(entry); index += 2 {
+		key, value := logField(entry, index)
+		if index > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(key)
+		line.WriteByte('=')
+
+		text := fmt.Sprint(value)
+		if err, ok := value.(error); ok {
+			text = err.Error()
+		}
+		if needsQuoting(text) {
+			text = strconv.Quote(text)
+		}
+		line.WriteString(text)
+	}
+}
+
+// writeJSONEntry formats the fields of an entry as a JSON object.
+func writeJSONEntry(line *bytes.Buffer, entry []interface{}) {
+	line.WriteByte('{')
+	for index := 0; index < len(entry); index += 2 {
+		key, value := logField(entry, index)
+		if index > 0 {
+			line.WriteByte(',')
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		line.Write(encodedKey)
+		line.WriteByte(':')
+
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			encodedValue, _ = json.Marshal(fmt.Sprint(value))
+		}
+		line.Write(encodedValue)
+	}
+	line.WriteByte('}')
+}
+
+// logField returns the key and value of the field starting at an index of an entry. A key
+// without a value, which is a mistake of the caller, is logged rather than dropped.
+func logField(entry []interface{}, index int) (string, interface{}) {
+	if index+1 == len(entry) {
+		return "!value", entry[index]
+	}
+	key, ok := entry[index].(string)
+	if !ok {
+		key = fmt.Sprint(entry[index])
+	}
+	return key, entry[index+1]
+}
+
+// needsQuoting determines whether a value of a text entry must be quoted to be parsed back.
+func needsQuoting(text string) bool {
+	if text == "" {
+		return true
+	}
+	for _, character := range text {
+		if character == '=' || character == '"' || unicode.IsSpace(character) || !unicode.IsPrint(character) {
+			return true
+		}
+	}
+	return false
+}
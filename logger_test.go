@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// Entries logged while running specs are only shown for failing specs.
+var _ = BeforeEach(func() {
+	logger = NewLogger(GinkgoWriter, LogLevelDebug, LogFormatText)
+})
+
+var _ = Describe("Logger", func() {
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+	})
+
+	It("writes fields as key=value pairs, quoting values when needed", func() {
+		NewLogger(output, LogLevelInfo, LogFormatText).Info("link shortened", "key", "abc", "error", errors.New("disk full"))
+
+		Expect(output.String()).To(MatchRegexp(`^time=\S+ level=info msg="link shortened" key=abc error="disk full"\n$`))
+	})
+
+	It("writes entries as JSON objects", func() {
+		NewLogger(output, LogLevelInfo, LogFormatJSON).With("request_id", "1").Warn("slow", "latency_ms", 1.5)
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(output.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "warn"))
+		Expect(entry).To(HaveKeyWithValue("msg", "slow"))
+		Expect(entry).To(HaveKeyWithValue("request_id", "1"))
+		Expect(entry).To(HaveKeyWithValue("latency_ms", 1.5))
+		Expect(entry).To(HaveKey("time"))
+	})
+
+	It("skips entries below its level", func() {
+		testLogger := NewLogger(output, LogLevelWarn, LogFormatText)
+		testLogger.Debug("debug")
+		testLogger.Info("info")
+		Expect(output.Len()).To(BeZero())
+
+		testLogger.Error("error")
+		Expect(output.String()).To(ContainSubstring("level=error"))
+	})
+
+	It("parses level names", func() {
+		level, err := ParseLogLevel("WARN")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(level).To(Equal(LogLevelWarn))
+
+		_, err = ParseLogLevel("verbose")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Request logging", func() {
+	var router http.Handler
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		urlDatabase := storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		router, _ = initializeRouter(urlDatabase, DefaultConfig())
+
+		output = &bytes.Buffer{}
+		logger = NewLogger(output, LogLevelInfo, LogFormatJSON)
+	})
+
+	send := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		return writer
+	}
+
+	lastEntry := func() map[string]interface{} {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		var entry map[string]interface{}
+		Expect(json.Unmarshal([]byte(lines[len(lines)-1]), &entry)).To(Succeed())
+		return entry
+	}
+
+	It("returns the identifier given by the client", func() {
+		writer := send("GET", "/missing", "", map[string]string{RequestIDHeader: "trace-1"})
+
+		Expect(writer.Header().Get(RequestIDHeader)).To(Equal("trace-1"))
+		Expect(lastEntry()).To(HaveKeyWithValue("request_id", "trace-1"))
+	})
+
+	It("generates an identifier when none is given", func() {
+		writer := send("GET", "/missing", "", nil)
+
+		Expect(writer.Header().Get(RequestIDHeader)).To(MatchRegexp("^[0-9a-f]{32}$"))
+		Expect(lastEntry()).To(HaveKeyWithValue("request_id", writer.Header().Get(RequestIDHeader)))
+	})
+
+	It("replaces malformed identifiers", func() {
+		writer := send("GET", "/missing", "", map[string]string{RequestIDHeader: "forged entry"})
+
+		Expect(writer.Header().Get(RequestIDHeader)).To(MatchRegexp("^[0-9a-f]{32}$"))
+	})
+
+	It("logs the key, outcome and latency of shorten and redirect requests", func() {
+		send("POST", "/shorten", `{"url": "https://example.com/", "key": "docs"}`, nil)
+
+		entry := lastEntry()
+		Expect(entry).To(HaveKeyWithValue("route", "/shorten"))
+		Expect(entry).To(HaveKeyWithValue("key", "docs"))
+		Expect(entry).To(HaveKeyWithValue("outcome", ShortenOutcomeCreated))
+		Expect(entry).To(HaveKeyWithValue("status", float64(http.StatusOK)))
+		Expect(entry).To(HaveKey("latency_ms"))
+
+		send("GET", "/docs", "", nil)
+
+		entry = lastEntry()
+		Expect(entry).To(HaveKeyWithValue("key", "docs"))
+		Expect(entry).To(HaveKeyWithValue("outcome", RedirectOutcomeHit))
+		Expect(entry).To(HaveKey("latency_ms"))
+	})
+
+	It("logs the error code as the outcome of failed requests", func() {
+		send("POST", "/shorten", `{"url": "https://example.com/", "key": "shorten"}`, nil)
+
+		entry := lastEntry()
+		Expect(entry).To(HaveKeyWithValue("key", "shorten"))
+		Expect(entry).To(HaveKeyWithValue("outcome", ErrorCodeKeyReserved))
+		Expect(entry).To(HaveKeyWithValue("level", "info"))
+	})
+})
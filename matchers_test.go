@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// linkRecordMatcher matches encoded link records with a given URL and expiry.
+type linkRecordMatcher struct {
+	URL       string
+	ExpiresAt time.Time
+}
+
+// Matches determines whether a value is an encoded link record with the expected URL and expiry.
+func (m linkRecordMatcher) Matches(x interface{}) bool {
+	value, ok := x.([]byte)
+	if !ok {
+		return false
+	}
+
+	record, legacy, err := DecodeLinkRecord(value)
+	if err != nil || legacy || record.Version != LinkRecordVersion || record.URL != m.URL {
+		return false
+	}
+
+	if m.ExpiresAt.IsZero() {
+		return record.ExpiresAt == nil
+	}
+	return record.ExpiresAt != nil && record.ExpiresAt.Equal(m.ExpiresAt)
+}
+
+// String describes the expected link record.
+func (m linkRecordMatcher) String() string {
+	return fmt.Sprintf("is a link record for %s expiring at %s", m.URL, m.ExpiresAt)
+}
+
+// matchLinkRecord matches the encoded link record of a URL, which never expires when expiresAt is the zero time.
+func matchLinkRecord(URL string, expiresAt time.Time) gomock.Matcher {
+	return linkRecordMatcher{URL: URL, ExpiresAt: expiresAt}
+}
+
+// encodedLinkRecord returns the encoded link record of a URL which never expires.
+func encodedLinkRecord(URL string) []byte {
+	value, _ := NewLinkRecord(URL, time.Now(), time.Time{}).Encode()
+	return value
+}
+
+// errorCode returns the code of an error response body, which is empty when the body is not a JSON error.
+func errorCode(body []byte) string {
+	content := struct {
+		Code string `json:"code"`
+	}{}
+	json.Unmarshal(body, &content)
+	return content.Code
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// metricsNamespace prefixes the names of the metrics of the service.
+	metricsNamespace = "bajo"
+
+	// unmatchedRoute labels the metrics of requests which matched no route, so that unknown
+	// paths cannot inflate the number of time series.
+	unmatchedRoute = "unmatched"
+
+	// levelDBLevels is the number of levels of a LevelDB database.
+	levelDBLevels = 7
+)
+
+// Outcomes of shorten requests, as counted by metrics.
+const (
+	ShortenOutcomeCreated  = "created"
+	ShortenOutcomeExisting = "existing"
+	ShortenOutcomeConflict = "conflict"
+	ShortenOutcomeInvalid  = "invalid"
+	ShortenOutcomeError    = "error"
+)
+
+// Outcomes of redirect requests, as counted by metrics.
+const (
+	RedirectOutcomeHit     = "hit"
+	RedirectOutcomeMiss    = "miss"
+	RedirectOutcomeExpired = "expired"
+	RedirectOutcomeError   = "error"
+)
+
+// Metrics holds the Prometheus metrics of the service, which are exposed on the /metrics route.
+// A nil *Metrics records nothing, so that controllers may be used without metrics.
+type Metrics struct {
+	registry                  *prometheus.Registry
+	requests                  *prometheus.CounterVec
+	requestDuration           *prometheus.HistogramVec
+	shortenOutcomes           *prometheus.CounterVec
+	redirectOutcomes          *prometheus.CounterVec
+	databaseOperationDuration *prometheus.HistogramVec
+	databaseOperationErrors   *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics of the service in a registry of their own, along with the
+// standard Go runtime and process metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests handled, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		shortenOutcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "shorten_total",
+			Help:      "Number of URLs shortened, by outcome: created, existing, conflict, invalid or error.",
+		}, []string{"outcome"}),
+		redirectOutcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "redirect_total",
+			Help:      "Number of redirect requests, by outcome: hit, miss, expired or error.",
+		}, []string{"outcome"}),
+		databaseOperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_operation_duration_seconds",
+			Help:      "Time taken by URL database operations, by operation.",
+			Buckets:   []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+		}, []string{"operation"}),
+		databaseOperationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_operation_errors_total",
+			Help:      "Number of failed URL database operations, by operation. Missing keys are not failures.",
+		}, []string{"operation"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.shortenOutcomes,
+		m.redirectOutcomes,
+		m.databaseOperationDuration,
+		m.databaseOperationErrors,
+	)
+	return m
+}
+
+// Handler implements the logic for the /metrics route.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Instrument is a middleware counting requests and measuring their latency.
+func (m *Metrics) Instrument(context *gin.Context) {
+	start := time.Now()
+
+	context.Next()
+
+	route := context.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	status := strconv.Itoa(context.Writer.Status())
+
+	m.requests.WithLabelValues(route, context.Request.Method, status).Inc()
+	m.requestDuration.WithLabelValues(route, context.Request.Method, status).Observe(time.Since(start).Seconds())
+}
+
+// RecordShorten counts the outcome of shortening a URL.
+func (m *Metrics) RecordShorten(outcome string) {
+	if m != nil {
+		m.shortenOutcomes.WithLabelValues(outcome).Inc()
+	}
+}
+
+// RecordRedirect counts the outcome of a redirect request.
+func (m *Metrics) RecordRedirect(outcome string) {
+	if m != nil {
+		m.redirectOutcomes.WithLabelValues(outcome).Inc()
+	}
+}
+
+// RegisterLevelDB exposes the internal statistics of a LevelDB database.
+func (m *Metrics) RegisterLevelDB(database LevelDBStatistics) {
+	m.registry.MustRegister(newLevelDBCollector(database))
+}
+
+// RegisterCache exposes the hits, misses and evictions of the read cache of the URL database.
+func (m *Metrics) RegisterCache(cache *CachedURLDatabase) {
+	m.registry.MustRegister(newCacheCollector(cache))
+}
+
+// shortenOutcome returns the outcome of a shorten request which failed with an API error.
+func shortenOutcome(apiErr *APIError) string {
+	switch {
+	case apiErr.Status >= http.StatusInternalServerError:
+		return ShortenOutcomeError
+	case apiErr.Status == http.StatusConflict:
+		return ShortenOutcomeConflict
+	default:
+		return ShortenOutcomeInvalid
+	}
+}
+
+// InstrumentedURLDatabase is a URLDatabase measuring the latency and counting the errors of the
+// operations of another URLDatabase.
+type InstrumentedURLDatabase struct {
+	URLDatabase URLDatabase
+	Metrics     *Metrics
+}
+
+// observe records the latency and outcome of an operation started at a time.
+func (d *InstrumentedURLDatabase) observe(operation string, start time.Time, err error) {
+	d.Metrics.databaseOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != storage.ErrNotFound {
+		d.Metrics.databaseOperationErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// Close closes the underlying database.
+func (d *InstrumentedURLDatabase) Close() error {
+	return d.URLDatabase.Close()
+}
+
+// Delete deletes a key from the underlying database.
+func (d *InstrumentedURLDatabase) Delete(key []byte) error {
+	start := time.Now()
+	err := d.URLDatabase.Delete(key)
+	d.observe("delete", start, err)
+	return err
+}
+
+// Get retrieves the value of a key from the underlying database.
+func (d *InstrumentedURLDatabase) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := d.URLDatabase.Get(key)
+	d.observe("get", start, err)
+	return value, err
+}
+
+// Has determines whether a key is present in the underlying database.
+func (d *InstrumentedURLDatabase) Has(key []byte) (bool, error) {
+	start := time.Now()
+	ret, err := d.URLDatabase.Has(key)
+	d.observe("has", start, err)
+	return ret, err
+}
+
+// NewIterator creates an iterator over the underlying database, whose iteration is measured
+// from its creation until its release.
+func (d *InstrumentedURLDatabase) NewIterator(prefix []byte) storage.Iterator {
+	return &instrumentedIterator{
+		Iterator: d.URLDatabase.NewIterator(prefix),
+		database: d,
+		start:    time.Now(),
+	}
+}
+
+// Put stores the value of a key in the underlying database.
+func (d *InstrumentedURLDatabase) Put(key, value []byte) error {
+	start := time.Now()
+	err := d.URLDatabase.Put(key, value)
+	d.observe("put", start, err)
+	return err
+}
+
+// Write applies a batch to the underlying database.
+func (d *InstrumentedURLDatabase) Write(batch *storage.Batch) error {
+	start := time.Now()
+	err := d.URLDatabase.Write(batch)
+	d.observe("write", start, err)
+	return err
+}
+
+// instrumentedIterator records an iteration over an InstrumentedURLDatabase once released.
+type instrumentedIterator struct {
+	storage.Iterator
+	database *InstrumentedURLDatabase
+	start    time.Time
+	released bool
+}
+
+// Release releases the underlying iterator, recording the iteration the first time.
+func (i *instrumentedIterator) Release() {
+	if !i.released {
+		i.released = true
+		i.database.observe("iterate", i.start, i.Iterator.Error())
+	}
+	i.Iterator.Release()
+}
+
+// LevelDBStatistics is implemented by leveldb.DB, which reports its internal statistics.
+type LevelDBStatistics interface {
+	Stats(stats *leveldb.DBStats) error
+	GetProperty(name string) (string, error)
+}
+
+// levelDBCollector exposes the internal statistics of a LevelDB database as metrics, which are
+// gathered whenever the metrics are scraped.
+type levelDBCollector struct {
+	database LevelDBStatistics
+
+	levelTables       *prometheus.Desc
+	tables            *prometheus.Desc
+	size              *prometheus.Desc
+	compactionSeconds *prometheus.Desc
+	compactionRead    *prometheus.Desc
+	compactionWrite   *prometheus.Desc
+	ioRead            *prometheus.Desc
+	ioWrite           *prometheus.Desc
+	writeDelays       *prometheus.Desc
+	writeDelaySeconds *prometheus.Desc
+	writePaused       *prometheus.Desc
+	aliveIterators    *prometheus.Desc
+	aliveSnapshots    *prometheus.Desc
+	openedTables      *prometheus.Desc
+	blockCacheSize    *prometheus.Desc
+}
+
+// newLevelDBCollector creates a collector of the statistics of a LevelDB database.
+func newLevelDBCollector(database LevelDBStatistics) *levelDBCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "leveldb", name), help, labels, nil)
+	}
+
+	return &levelDBCollector{
+		database:          database,
+		levelTables:       desc("level_tables", "Number of table files at each level.", "level"),
+		tables:            desc("tables", "Number of table files."),
+		size:              desc("size_bytes", "Size of the table files."),
+		compactionSeconds: desc("compaction_seconds_total", "Time spent compacting."),
+		compactionRead:    desc("compaction_read_bytes_total", "Bytes read by compactions."),
+		compactionWrite:   desc("compaction_write_bytes_total", "Bytes written by compactions."),
+		ioRead:            desc("io_read_bytes_total", "Bytes read from storage."),
+		ioWrite:           desc("io_write_bytes_total", "Bytes written to storage."),
+		writeDelays:       desc("write_delays_total", "Number of writes delayed by compactions."),
+		writeDelaySeconds: desc("write_delay_seconds_total", "Time writes were delayed by compactions."),
+		writePaused:       desc("write_paused", "Whether writes are paused by compactions."),
+		aliveIterators:    desc("alive_iterators", "Number of unreleased iterators."),
+		aliveSnapshots:    desc("alive_snapshots", "Number of unreleased snapshots."),
+		openedTables:      desc("opened_tables", "Number of table files held open."),
+		blockCacheSize:    desc("block_cache_size_bytes", "Size of the block cache."),
+	}
+}
+
+// Describe sends the descriptions of the metrics of the collector.
+func (c *levelDBCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.levelTables, c.tables, c.size, c.compactionSeconds, c.compactionRead, c.compactionWrite,
+		c.ioRead, c.ioWrite, c.writeDelays, c.writeDelaySeconds, c.writePaused,
+		c.aliveIterators, c.aliveSnapshots, c.openedTables, c.blockCacheSize,
+	} {
+		descs <- desc
+	}
+}
+
+// Collect gathers the statistics of the database. No metrics are reported when the database
+// is closed.
+func (c *levelDBCollector) Collect(metrics chan<- prometheus.Metric) {
+	var stats leveldb.DBStats
+	if err := c.database.Stats(&stats); err != nil {
+		return
+	}
+
+	// The statistics of LevelDB omit empty levels, so the tables of each level are queried separately.
+	for level := 0; level < levelDBLevels; level++ {
+		value, err := c.database.GetProperty(fmt.Sprintf("leveldb.num-files-at-level%d", level))
+		if err != nil {
+			break
+		}
+		if tables, err := strconv.ParseFloat(value, 64); err == nil {
+			metrics <- prometheus.MustNewConstMetric(c.levelTables, prometheus.GaugeValue, tables, strconv.Itoa(level))
+		}
+	}
+
+	var tables, size, compactionRead, compactionWrite float64
+	var compactionDuration time.Duration
+	for index := range stats.LevelTablesCounts {
+		tables += float64(stats.LevelTablesCounts[index])
+		size += float64(stats.LevelSizes[index])
+		compactionRead += float64(stats.LevelRead[index])
+		compactionWrite += float64(stats.LevelWrite[index])
+		compactionDuration += stats.LevelDurations[index]
+	}
+
+	writePaused := 0.0
+	if stats.WritePaused {
+		writePaused = 1
+	}
+
+	metrics <- prometheus.MustNewConstMetric(c.tables, prometheus.GaugeValue, tables)
+	metrics <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, size)
+	metrics <- prometheus.MustNewConstMetric(c.compactionSeconds, prometheus.CounterValue, compactionDuration.Seconds())
+	metrics <- prometheus.MustNewConstMetric(c.compactionRead, prometheus.CounterValue, compactionRead)
+	metrics <- prometheus.MustNewConstMetric(c.compactionWrite, prometheus.CounterValue, compactionWrite)
+	metrics <- prometheus.MustNewConstMetric(c.ioRead, prometheus.CounterValue, float64(stats.IORead))
+	metrics <- prometheus.MustNewConstMetric(c.ioWrite, prometheus.CounterValue, float64(stats.IOWrite))
+	metrics <- prometheus.MustNewConstMetric(c.writeDelays, prometheus.CounterValue, float64(stats.WriteDelayCount))
+	metrics <- prometheus.MustNewConstMetric(c.writeDelaySeconds, prometheus.CounterValue, stats.WriteDelayDuration.Seconds())
+	metrics <- prometheus.MustNewConstMetric(c.writePaused, prometheus.GaugeValue, writePaused)
+	metrics <- prometheus.MustNewConstMetric(c.aliveIterators, prometheus.GaugeValue, float64(stats.AliveIterators))
+	metrics <- prometheus.MustNewConstMetric(c.aliveSnapshots, prometheus.GaugeValue, float64(stats.AliveSnapshots))
+	metrics <- prometheus.MustNewConstMetric(c.openedTables, prometheus.GaugeValue, float64(stats.OpenedTablesCount))
+	metrics <- prometheus.MustNewConstMetric(c.blockCacheSize, prometheus.GaugeValue, float64(stats.BlockCacheSize))
+}
+
+// cacheCollector exposes the statistics of a read cache as metrics, which are gathered whenever
+// the metrics are scraped.
+type cacheCollector struct {
+	cache *CachedURLDatabase
+
+	requests  *prometheus.Desc
+	evictions *prometheus.Desc
+	entries   *prometheus.Desc
+}
+
+// newCacheCollector creates a collector of the statistics of a read cache.
+func newCacheCollector(cache *CachedURLDatabase) *cacheCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "cache", name), help, labels, nil)
+	}
+
+	return &cacheCollector{
+		cache:     cache,
+		requests:  desc("requests_total", "Number of reads of the URL database cache, by result: hit or miss.", "result"),
+		evictions: desc("evictions_total", "Number of keys evicted from the cache to make room for others."),
+		entries:   desc("entries", "Number of keys held by the cache."),
+	}
+}
+
+// Describe sends the descriptions of the metrics of the collector.
+func (c *cacheCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.requests
+	descs <- c.evictions
+	descs <- c.entries
+}
+
+// Collect gathers the statistics of the cache.
+func (c *cacheCollector) Collect(metrics chan<- prometheus.Metric) {
+	statistics := c.cache.Statistics()
+	metrics <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(statistics.Hits), "hit")
+	metrics <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(statistics.Misses), "miss")
+	metrics <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(statistics.Evictions))
+	metrics <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(statistics.Entries))
+}
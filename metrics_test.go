@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Metrics", func() {
+	var router *gin.Engine
+	var urlDatabase *storage.Memory
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		router, _ = initializeRouter(urlDatabase, DefaultConfig())
+	})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		return writer
+	}
+
+	scrape := func() string {
+		writer := send("GET", "/metrics", "")
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		return writer.Body.String()
+	}
+
+	It("counts requests by route, method and status", func() {
+		send("GET", "/missing", "")
+		send("GET", "/missing/stats", "")
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_http_requests_total{method="GET",route="/:key",status="404"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_http_requests_total{method="GET",route="/:key/stats",status="404"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_http_request_duration_seconds_count{method="GET",route="/:key",status="404"} 1`))
+	})
+
+	It("counts the outcomes of shorten requests", func() {
+		send("POST", "/shorten", `{"url": "https://example.com/", "key": "docs"}`)
+		send("POST", "/shorten", `{"url": "https://example.com/", "key": "docs"}`)
+		send("POST", "/shorten", `{"url": "https://example.org/", "key": "docs"}`)
+		send("POST", "/shorten", `{"url": "javascript:alert(1)"}`)
+		send("POST", "/shorten/batch", `[{"url": "https://example.net/"}]`)
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_shorten_total{outcome="created"} 2`))
+		Expect(metrics).To(ContainSubstring(`bajo_shorten_total{outcome="existing"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_shorten_total{outcome="conflict"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_shorten_total{outcome="invalid"} 1`))
+	})
+
+	It("does not count the items of a batch which could not be written as created", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockURLDatabase := mocks.NewMockURLDatabase(ctrl)
+		mockURLDatabase.EXPECT().Get(gomock.Any()).Return(nil, storage.ErrNotFound).AnyTimes()
+		mockURLDatabase.EXPECT().Write(gomock.Any()).Return(errors.New("failed to write batch"))
+		router, _ = initializeRouter(mockURLDatabase, DefaultConfig())
+
+		send("POST", "/shorten/batch", `[{"url": "https://example.net/"}]`)
+
+		metrics := scrape()
+		Expect(metrics).NotTo(ContainSubstring(`bajo_shorten_total{outcome="created"}`))
+		Expect(metrics).To(ContainSubstring(`bajo_shorten_total{outcome="error"} 1`))
+	})
+
+	It("counts the outcomes of redirect requests", func() {
+		expired := NewLinkRecord("https://example.org/", time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+		Expect(writeLinkRecord(urlDatabase, []byte("old"), expired)).To(Succeed())
+		send("POST", "/shorten", `{"url": "https://example.com/", "key": "docs"}`)
+
+		send("GET", "/docs", "")
+		send("GET", "/missing", "")
+		send("GET", "/old", "")
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_redirect_total{outcome="hit"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_redirect_total{outcome="miss"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_redirect_total{outcome="expired"} 1`))
+	})
+
+	It("measures database operations", func() {
+		send("GET", "/missing", "")
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_database_operation_duration_seconds_count{operation="get"}`))
+		Expect(metrics).NotTo(ContainSubstring(`bajo_database_operation_errors_total{operation="get"}`))
+	})
+
+	It("measures operations of the storage database underneath the cache", func() {
+		cache := NewCachedURLDatabase(urlDatabase, 10, time.Minute, time.Minute)
+		router, _ = initializeRouter(cache, DefaultConfig())
+		Expect(writeLinkRecord(urlDatabase, []byte("docs"), NewLinkRecord("https://example.com/", time.Now(), time.Time{}))).To(Succeed())
+
+		send("GET", "/docs", "")
+		send("GET", "/docs", "")
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_cache_requests_total{result="hit"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_cache_requests_total{result="miss"} 1`))
+		Expect(metrics).To(ContainSubstring(`bajo_database_operation_duration_seconds_count{operation="get"} 1`))
+	})
+
+	It("exposes the statistics of LevelDB", func() {
+		levelDB, err := storage.OpenLevelDB(GinkgoT().TempDir(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(levelDB.Close)
+		router, _ = initializeRouter(levelDB, DefaultConfig())
+
+		metrics := scrape()
+		Expect(metrics).To(ContainSubstring(`bajo_leveldb_level_tables{level="0"} 0`))
+		Expect(metrics).To(ContainSubstring(`bajo_leveldb_compaction_seconds_total`))
+		Expect(metrics).To(ContainSubstring(`bajo_leveldb_alive_iterators`))
+	})
+
+	It("does not expose LevelDB statistics for other backends", func() {
+		Expect(scrape()).NotTo(ContainSubstring(`bajo_leveldb_`))
+	})
+
+	Describe("InstrumentedURLDatabase", func() {
+		It("counts failed operations, but not missing keys", func() {
+			ctrl := gomock.NewController(GinkgoT())
+			mockURLDatabase := mocks.NewMockURLDatabase(ctrl)
+			metrics := NewMetrics()
+			instrumented := &InstrumentedURLDatabase{URLDatabase: mockURLDatabase, Metrics: metrics}
+
+			mockURLDatabase.EXPECT().Get([]byte("missing")).Return(nil, storage.ErrNotFound)
+			mockURLDatabase.EXPECT().Put([]byte("key"), []byte("value")).Return(errors.New("disk full"))
+
+			_, err := instrumented.Get([]byte("missing"))
+			Expect(err).To(Equal(storage.ErrNotFound))
+			Expect(instrumented.Put([]byte("key"), []byte("value"))).To(MatchError("disk full"))
+
+			router := gin.New()
+			router.GET("/metrics", metrics.Handler())
+			request, _ := http.NewRequest("GET", "/metrics", nil)
+			writer := httptest.NewRecorder()
+			router.ServeHTTP(writer, request)
+
+			Expect(writer.Body.String()).To(ContainSubstring(`bajo_database_operation_errors_total{operation="put"} 1`))
+			Expect(writer.Body.String()).NotTo(ContainSubstring(`bajo_database_operation_errors_total{operation="get"}`))
+		})
+	})
+})
@@ -8,8 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
-	leveldb "github.com/syndtr/goleveldb/leveldb"
-	opt "github.com/syndtr/goleveldb/leveldb/opt"
+	storage "github.com/upsideon/bajo/storage"
 )
 
 // MockURLDatabase is a mock of URLDatabase interface.
@@ -50,61 +49,89 @@ func (mr *MockURLDatabaseMockRecorder) Close() *gomock.Call {
 }
 
 // Delete mocks base method.
-func (m *MockURLDatabase) Delete(key []byte, wo *opt.WriteOptions) error {
+func (m *MockURLDatabase) Delete(key []byte) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", key, wo)
+	ret := m.ctrl.Call(m, "Delete", key)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockURLDatabaseMockRecorder) Delete(key, wo interface{}) *gomock.Call {
+func (mr *MockURLDatabaseMockRecorder) Delete(key interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockURLDatabase)(nil).Delete), key, wo)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockURLDatabase)(nil).Delete), key)
 }
 
 // Get mocks base method.
-func (m *MockURLDatabase) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+func (m *MockURLDatabase) Get(key []byte) ([]byte, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", key, ro)
+	ret := m.ctrl.Call(m, "Get", key)
 	ret0, _ := ret[0].([]byte)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
-func (mr *MockURLDatabaseMockRecorder) Get(key, ro interface{}) *gomock.Call {
+func (mr *MockURLDatabaseMockRecorder) Get(key interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockURLDatabase)(nil).Get), key, ro)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockURLDatabase)(nil).Get), key)
 }
 
 // Has mocks base method.
-func (m *MockURLDatabase) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+func (m *MockURLDatabase) Has(key []byte) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Has", key, ro)
+	ret := m.ctrl.Call(m, "Has", key)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Has indicates an expected call of Has.
-func (mr *MockURLDatabaseMockRecorder) Has(key, ro interface{}) *gomock.Call {
+func (mr *MockURLDatabaseMockRecorder) Has(key interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Has", reflect.TypeOf((*MockURLDatabase)(nil).Has), key, ro)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Has", reflect.TypeOf((*MockURLDatabase)(nil).Has), key)
+}
+
+// NewIterator mocks base method.
+func (m *MockURLDatabase) NewIterator(prefix []byte) storage.Iterator {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewIterator", prefix)
+	ret0, _ := ret[0].(storage.Iterator)
+	return ret0
+}
+
+// NewIterator indicates an expected call of NewIterator.
+func (mr *MockURLDatabaseMockRecorder) NewIterator(prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewIterator", reflect.TypeOf((*MockURLDatabase)(nil).NewIterator), prefix)
 }
 
 // Put mocks base method.
-func (m *MockURLDatabase) Put(key, value []byte, wo *opt.WriteOptions) error {
+func (m *MockURLDatabase) Put(key, value []byte) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Put", key, value, wo)
+	ret := m.ctrl.Call(m, "Put", key, value)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Put indicates an expected call of Put.
-func (mr *MockURLDatabaseMockRecorder) Put(key, value, wo interface{}) *gomock.Call {
+func (mr *MockURLDatabaseMockRecorder) Put(key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockURLDatabase)(nil).Put), key, value)
+}
+
+// Write mocks base method.
+func (m *MockURLDatabase) Write(batch *storage.Batch) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Write", batch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockURLDatabaseMockRecorder) Write(batch interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockURLDatabase)(nil).Put), key, value, wo)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockURLDatabase)(nil).Write), batch)
 }
 
 // MockDatabaseManager is a mock of DatabaseManager interface.
@@ -130,17 +157,17 @@ func (m *MockDatabaseManager) EXPECT() *MockDatabaseManagerMockRecorder {
 	return m.recorder
 }
 
-// OpenFile mocks base method.
-func (m *MockDatabaseManager) OpenFile(path string, o *opt.Options) (*leveldb.DB, error) {
+// Open mocks base method.
+func (m *MockDatabaseManager) Open(path string) (storage.Database, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "OpenFile", path, o)
-	ret0, _ := ret[0].(*leveldb.DB)
+	ret := m.ctrl.Call(m, "Open", path)
+	ret0, _ := ret[0].(storage.Database)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// OpenFile indicates an expected call of OpenFile.
-func (mr *MockDatabaseManagerMockRecorder) OpenFile(path, o interface{}) *gomock.Call {
+// Open indicates an expected call of Open.
+func (mr *MockDatabaseManagerMockRecorder) Open(path interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenFile", reflect.TypeOf((*MockDatabaseManager)(nil).OpenFile), path, o)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockDatabaseManager)(nil).Open), path)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// ErrURLTooLong is returned when a URL exceeds the configured maximum length.
+	ErrURLTooLong = errors.New("URL is too long")
+	// ErrURLNotAbsolute is returned when a URL lacks a scheme or host.
+	ErrURLNotAbsolute = errors.New("URL must be absolute")
+	// ErrURLSchemeNotAllowed is returned when a URL scheme is not in the configured allowlist.
+	ErrURLSchemeNotAllowed = errors.New("URL scheme is not allowed")
+	// ErrURLInvalidHost is returned when the host of a URL is not a valid domain name or IP address.
+	ErrURLInvalidHost = errors.New("URL host is invalid")
+)
+
+// defaultPorts maps schemes to the port which is implied when none is given.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeURL validates a URL and returns it in a canonical form, so that
+// equivalent URLs are stored and hashed identically. The scheme and host are
+// lowercased, internationalized domain names are converted to punycode, default
+// ports are removed and an empty path is replaced by the root path.
+func NormalizeURL(rawURL string, allowedSchemes []string, maxLength int) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+
+	if len(rawURL) > maxLength {
+		return "", ErrURLTooLong
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrURLNotAbsolute, err)
+	}
+
+	if !parsedURL.IsAbs() {
+		return "", ErrURLNotAbsolute
+	}
+
+	scheme := strings.ToLower(parsedURL.Scheme)
+	if !isAllowedScheme(scheme, allowedSchemes) {
+		return "", fmt.Errorf("%w: %s", ErrURLSchemeNotAllowed, scheme)
+	}
+
+	if parsedURL.Opaque != "" || parsedURL.Host == "" {
+		return "", ErrURLNotAbsolute
+	}
+
+	host, err := normalizeHost(parsedURL.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := parsedURL.Port()
+	if port != "" {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return "", fmt.Errorf("%w: invalid port %s", ErrURLInvalidHost, port)
+		}
+		if port == defaultPorts[scheme] {
+			port = ""
+		}
+	}
+
+	parsedURL.Scheme = scheme
+	parsedURL.Host = host
+	if strings.Contains(host, ":") {
+		parsedURL.Host = "[" + host + "]"
+	}
+	if port != "" {
+		parsedURL.Host = net.JoinHostPort(host, port)
+	}
+	if parsedURL.Path == "" {
+		parsedURL.Path = "/"
+	}
+
+	normalizedURL := parsedURL.String()
+	if len(normalizedURL) > maxLength {
+		return "", ErrURLTooLong
+	}
+
+	return normalizedURL, nil
+}
+
+// normalizeHost lowercases a host and converts internationalized domain names to punycode.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", ErrURLInvalidHost
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return strings.ToLower(host), nil
+	}
+
+	asciiHost, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrURLInvalidHost, err)
+	}
+
+	return strings.ToLower(asciiHost), nil
+}
+
+// isAllowedScheme determines whether a lowercase scheme is present in an allowlist.
+func isAllowedScheme(scheme string, allowedSchemes []string) bool {
+	for _, allowedScheme := range allowedSchemes {
+		if strings.EqualFold(scheme, allowedScheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NormalizeURL", func() {
+	allowedSchemes := []string{"http", "https"}
+
+	DescribeTable("normalizing valid URLs",
+		func(rawURL, expectedURL string) {
+			normalizedURL, err := NormalizeURL(rawURL, allowedSchemes, DefaultMaxURLLength)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(normalizedURL).To(Equal(expectedURL))
+		},
+		Entry("keeps canonical URLs", "https://en.wikipedia.org/wiki/URL_shortening", "https://en.wikipedia.org/wiki/URL_shortening"),
+		Entry("trims surrounding whitespace", "  https://example.com/a  ", "https://example.com/a"),
+		Entry("lowercases the scheme and host", "HTTPS://Example.COM/Path", "https://example.com/Path"),
+		Entry("strips the default HTTP port", "http://example.com:80/a", "http://example.com/a"),
+		Entry("strips the default HTTPS port", "https://example.com:443/a", "https://example.com/a"),
+		Entry("keeps other ports", "https://example.com:8443/a", "https://example.com:8443/a"),
+		Entry("adds the root path", "https://example.com", "https://example.com/"),
+		Entry("converts internationalized domain names to punycode", "https://bücher.example/", "https://xn--bcher-kva.example/"),
+		Entry("keeps IPv6 hosts", "http://[2001:DB8::1]:80/", "http://[2001:db8::1]/"),
+		Entry("keeps queries and fragments", "https://example.com/a?b=c#d", "https://example.com/a?b=c#d"),
+	)
+
+	DescribeTable("rejecting invalid URLs",
+		func(rawURL string, expectedErr error) {
+			_, err := NormalizeURL(rawURL, allowedSchemes, DefaultMaxURLLength)
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("rejects disallowed schemes", "javascript:alert(1)", ErrURLSchemeNotAllowed),
+		Entry("rejects disallowed schemes with hosts", "ftp://example.com/file", ErrURLSchemeNotAllowed),
+		Entry("rejects URLs without a host", "https:example.com", ErrURLNotAbsolute),
+		Entry("rejects text", "not a url", ErrURLNotAbsolute),
+		Entry("rejects relative paths", "/wiki/URL_shortening", ErrURLNotAbsolute),
+		Entry("rejects scheme-relative URLs", "//example.com/a", ErrURLNotAbsolute),
+		Entry("rejects invalid hosts", "https://exa_mple!.com/", ErrURLInvalidHost),
+		Entry("rejects invalid ports", "https://example.com:99999/", ErrURLInvalidHost),
+		Entry("rejects URLs that are too long", "https://example.com/"+strings.Repeat("a", DefaultMaxURLLength), ErrURLTooLong),
+	)
+})
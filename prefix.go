@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// URLPrefixResolver determines the prefix of the shortened URLs returned for a request.
+type URLPrefixResolver struct {
+	// FallbackPrefix is used when the prefix is not derived from requests, or cannot be.
+	FallbackPrefix string
+	// FromRequest enables deriving the prefix from the host and scheme of requests.
+	FromRequest bool
+	// TrustedProxies contains the networks of proxies whose X-Forwarded-* headers are honored.
+	TrustedProxies []*net.IPNet
+}
+
+// NewURLPrefixResolver creates a URL prefix resolver from the service configuration.
+func NewURLPrefixResolver(config *Config) (*URLPrefixResolver, error) {
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &URLPrefixResolver{
+		FallbackPrefix: config.URLPrefix,
+		FromRequest:    config.URLPrefixFromRequest,
+		TrustedProxies: trustedProxies,
+	}, nil
+}
+
+// Prefix returns the prefix of shortened URLs for a request.
+func (r *URLPrefixResolver) Prefix(request *http.Request) string {
+	if !r.FromRequest {
+		return r.FallbackPrefix
+	}
+
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	host := request.Host
+
+	// Forwarded headers can be set by any client, so they are only honored when
+	// the request was received from a trusted proxy.
+	if r.isTrustedProxy(request.RemoteAddr) {
+		if forwardedHost := firstHeaderValue(request.Header.Get("X-Forwarded-Host")); forwardedHost != "" {
+			host = forwardedHost
+		}
+		switch forwardedProto := strings.ToLower(firstHeaderValue(request.Header.Get("X-Forwarded-Proto"))); forwardedProto {
+		case "http", "https":
+			scheme = forwardedProto
+		}
+	}
+
+	if !isValidHost(host) {
+		return r.FallbackPrefix
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// isTrustedProxy determines whether a remote address belongs to a trusted proxy.
+func (r *URLPrefixResolver) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header value,
+// which is the one added by the proxy closest to the client.
+func firstHeaderValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// isValidHost determines whether a host, with an optional port, is safe to use in a URL.
+func isValidHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	parsed, err := url.Parse("//" + host)
+	return err == nil && parsed.Host == host && parsed.User == nil && parsed.Path == ""
+}
+
+// parseTrustedProxies parses a list of IP addresses and CIDR networks.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		cidr := proxy
+
+		// A single address is treated as a network containing only that address.
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
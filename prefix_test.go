@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("URLPrefixResolver", func() {
+	const fallbackPrefix = "https://bajo"
+
+	var config *Config
+	var request *http.Request
+	var prefix string
+
+	BeforeEach(func() {
+		config = DefaultConfig()
+		config.URLPrefix = fallbackPrefix
+		config.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+
+		request, _ = http.NewRequest("POST", "/shorten", nil)
+		request.Host = "short.example:8443"
+		request.RemoteAddr = "203.0.113.7:51234"
+		request.Header.Set("X-Forwarded-Host", "links.example")
+		request.Header.Set("X-Forwarded-Proto", "https")
+	})
+
+	JustBeforeEach(func() {
+		resolver, err := NewURLPrefixResolver(config)
+		Expect(err).NotTo(HaveOccurred())
+		prefix = resolver.Prefix(request)
+	})
+
+	When("deriving the prefix from requests is disabled", func() {
+		It("returns the configured prefix", func() {
+			Expect(prefix).To(Equal(fallbackPrefix))
+		})
+	})
+
+	When("deriving the prefix from requests is enabled", func() {
+		BeforeEach(func() {
+			config.URLPrefixFromRequest = true
+		})
+
+		Context("and the request does not come from a trusted proxy", func() {
+			It("ignores the forwarded headers", func() {
+				Expect(prefix).To(Equal("http://short.example:8443"))
+			})
+
+			Context("and the request was received over TLS", func() {
+				BeforeEach(func() {
+					request.TLS = &tls.ConnectionState{}
+				})
+
+				It("uses the https scheme", func() {
+					Expect(prefix).To(Equal("https://short.example:8443"))
+				})
+			})
+		})
+
+		Context("and the request comes from a trusted proxy network", func() {
+			BeforeEach(func() {
+				request.RemoteAddr = "10.1.2.3:51234"
+			})
+
+			It("honors the forwarded headers", func() {
+				Expect(prefix).To(Equal("https://links.example"))
+			})
+
+			Context("and the forwarded headers contain several values", func() {
+				BeforeEach(func() {
+					request.Header.Set("X-Forwarded-Host", "first.example, second.example")
+					request.Header.Set("X-Forwarded-Proto", "http, https")
+				})
+
+				It("uses the first values", func() {
+					Expect(prefix).To(Equal("http://first.example"))
+				})
+			})
+
+			Context("and the forwarded host is malformed", func() {
+				BeforeEach(func() {
+					request.Header.Set("X-Forwarded-Host", "evil.example/phishing")
+				})
+
+				It("returns the configured prefix", func() {
+					Expect(prefix).To(Equal(fallbackPrefix))
+				})
+			})
+
+			Context("and the forwarded scheme is not supported", func() {
+				BeforeEach(func() {
+					request.Header.Set("X-Forwarded-Proto", "javascript")
+				})
+
+				It("ignores the forwarded scheme", func() {
+					Expect(prefix).To(Equal("http://links.example"))
+				})
+			})
+		})
+
+		Context("and the request comes from a trusted proxy address", func() {
+			BeforeEach(func() {
+				request.RemoteAddr = "192.168.1.1:51234"
+			})
+
+			It("honors the forwarded headers", func() {
+				Expect(prefix).To(Equal("https://links.example"))
+			})
+		})
+
+		Context("and the request carries no host", func() {
+			BeforeEach(func() {
+				request.Host = ""
+			})
+
+			It("returns the configured prefix", func() {
+				Expect(prefix).To(Equal(fallbackPrefix))
+			})
+		})
+	})
+
+	Describe("NewURLPrefixResolver", func() {
+		It("rejects invalid trusted proxies", func() {
+			config := DefaultConfig()
+			config.TrustedProxies = []string{"not-an-address"}
+
+			_, err := NewURLPrefixResolver(config)
+			Expect(err).To(MatchError(ContainSubstring("not-an-address")))
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultRateBurst defines how many requests each client may make at once by default.
+const DefaultRateBurst = 20
+
+// RateLimiter decides whether a client may make a request, allowing other backends,
+// such as one shared between several instances of the service, to be plugged in.
+type RateLimiter interface {
+	// Allow consumes a request of the client identified by key, returning whether it is
+	// allowed and, when it is not, how long the client should wait before retrying.
+	Allow(key string, now time.Time) (bool, time.Duration)
+}
+
+// tokenBucket holds the tokens available to a client at the time it was last updated.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryRateLimiter is a RateLimiter keeping a token bucket per client in memory.
+// Each bucket holds up to Burst tokens and is refilled at Rate tokens per second.
+type MemoryRateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// NewMemoryRateLimiter creates an in-memory rate limiter.
+func NewMemoryRateLimiter(rate float64, burst int) *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow consumes a token from the bucket of a client.
+func (l *MemoryRateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.Burst), updated: now}
+		l.buckets[key] = bucket
+	}
+
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens = math.Min(float64(l.Burst), bucket.tokens+elapsed.Seconds()*l.Rate)
+		bucket.updated = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	missing := 1 - bucket.tokens
+	return false, time.Duration(missing / l.Rate * float64(time.Second))
+}
+
+// prune forgets the buckets which have refilled completely, as they are no different from new
+// buckets, so that memory use does not grow with every client ever seen. Buckets are pruned
+// at most once per refill period.
+func (l *MemoryRateLimiter) prune(now time.Time) {
+	refillPeriod := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	if now.Sub(l.lastPrune) < refillPeriod {
+		return
+	}
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refillPeriod {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// RateLimit is a middleware rejecting requests of clients exceeding the limit of a rate limiter.
+// Clients are identified by their API key or, for anonymous requests, by their IP address,
+// so it must be registered after the API key authentication middleware.
+func RateLimit(limiter RateLimiter) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		allowed, retryAfter := limiter.Allow(rateLimitKey(context), time.Now())
+		if allowed {
+			context.Next()
+			return
+		}
+
+		// Retry-After is given in whole seconds, rounded up so that retrying in time succeeds.
+		retryAfterSeconds := int64(math.Ceil(retryAfter.Seconds()))
+		if retryAfterSeconds < 1 {
+			retryAfterSeconds = 1
+		}
+		context.Header("Retry-After", strconv.FormatInt(retryAfterSeconds, 10))
+		abortWithError(context, NewAPIError(http.StatusTooManyRequests, ErrorCodeRateLimited,
+			fmt.Sprintf("Too many requests, retry in %d seconds", retryAfterSeconds)))
+	}
+}
+
+// rateLimitKey identifies the client making a request.
+func rateLimitKey(context *gin.Context) string {
+	if principal := requestPrincipal(context); principal != nil {
+		return fmt.Sprintf("key:%s", principal.KeyID)
+	}
+	return fmt.Sprintf("ip:%s", context.ClientIP())
+}
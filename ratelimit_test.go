@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Rate limiting", func() {
+	Describe("MemoryRateLimiter", func() {
+		var limiter *MemoryRateLimiter
+		now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+		BeforeEach(func() {
+			limiter = NewMemoryRateLimiter(2, 3)
+		})
+
+		It("allows a burst of requests", func() {
+			for i := 0; i < 3; i++ {
+				allowed, _ := limiter.Allow("client", now)
+				Expect(allowed).To(BeTrue())
+			}
+		})
+
+		It("rejects requests beyond the burst until tokens are refilled", func() {
+			for i := 0; i < 3; i++ {
+				limiter.Allow("client", now)
+			}
+
+			allowed, retryAfter := limiter.Allow("client", now)
+			Expect(allowed).To(BeFalse())
+			Expect(retryAfter).To(Equal(500 * time.Millisecond))
+
+			allowed, _ = limiter.Allow("client", now.Add(500*time.Millisecond))
+			Expect(allowed).To(BeTrue())
+		})
+
+		It("limits clients independently", func() {
+			for i := 0; i < 3; i++ {
+				limiter.Allow("client", now)
+			}
+
+			allowed, _ := limiter.Allow("other client", now)
+			Expect(allowed).To(BeTrue())
+		})
+
+		It("does not refill beyond the burst", func() {
+			limiter.Allow("client", now)
+
+			later := now.Add(time.Hour)
+			for i := 0; i < 3; i++ {
+				allowed, _ := limiter.Allow("client", later)
+				Expect(allowed).To(BeTrue())
+			}
+			allowed, _ := limiter.Allow("client", later)
+			Expect(allowed).To(BeFalse())
+		})
+
+		It("forgets clients whose bucket has refilled", func() {
+			limiter.Allow("client", now)
+			limiter.Allow("other client", now.Add(2*time.Second))
+
+			Expect(limiter.buckets).To(HaveLen(1))
+			Expect(limiter.buckets).To(HaveKey("other client"))
+		})
+	})
+
+	Describe("RateLimit", func() {
+		var urlDatabase *storage.Memory
+		var config *Config
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+			DeferCleanup(urlDatabase.Close)
+
+			config = DefaultConfig()
+			config.ShortenRateLimit = 0.001
+			config.ShortenRateBurst = 1
+		})
+
+		shorten := func(handler http.Handler, remoteAddr, apiKey string) *httptest.ResponseRecorder {
+			request, _ := http.NewRequest("POST", "/shorten", strings.NewReader(`{"url": "https://example.com/"}`))
+			request.RemoteAddr = remoteAddr
+			if apiKey != "" {
+				request.Header.Set("X-API-Key", apiKey)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, request)
+			return writer
+		}
+
+		It("returns a 429 with Retry-After once a client exceeds the limit", func() {
+			router, _ := initializeRouter(urlDatabase, config)
+
+			Expect(shorten(router, "192.0.2.1:1234", "").Code).To(Equal(http.StatusOK))
+
+			writer := shorten(router, "192.0.2.1:1234", "")
+			Expect(writer.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(writer.Header().Get("Retry-After")).To(Equal("1000"))
+		})
+
+		It("limits clients by IP address", func() {
+			router, _ := initializeRouter(urlDatabase, config)
+
+			Expect(shorten(router, "192.0.2.1:1234", "").Code).To(Equal(http.StatusOK))
+			Expect(shorten(router, "192.0.2.2:1234", "").Code).To(Equal(http.StatusOK))
+		})
+
+		It("limits authenticated clients by API key", func() {
+			key, _, err := CreateAPIKey(urlDatabase, "alice", false, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			router, _ := initializeRouter(urlDatabase, config)
+
+			Expect(shorten(router, "192.0.2.1:1234", "").Code).To(Equal(http.StatusOK))
+			Expect(shorten(router, "192.0.2.1:1234", key).Code).To(Equal(http.StatusOK))
+			Expect(shorten(router, "192.0.2.2:1234", key).Code).To(Equal(http.StatusTooManyRequests))
+		})
+
+		It("limits the redirect route separately", func() {
+			config.RedirectRateLimit = 0.001
+			config.RedirectRateBurst = 1
+			router, _ := initializeRouter(urlDatabase, config)
+
+			Expect(shorten(router, "192.0.2.1:1234", "").Code).To(Equal(http.StatusOK))
+
+			redirect := func() int {
+				request, _ := http.NewRequest("GET", "/missing", nil)
+				request.RemoteAddr = "192.0.2.1:1234"
+				writer := httptest.NewRecorder()
+				router.ServeHTTP(writer, request)
+				return writer.Code
+			}
+			Expect(redirect()).To(Equal(http.StatusNotFound))
+			Expect(redirect()).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+})
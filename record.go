@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// LinkRecordVersion is the version of the record format written to the URL database.
+	LinkRecordVersion = 1
+
+	// legacyExpiryKeyPrefix defines the prefix of the keyspace in which the expiry of
+	// legacy raw URL values was stored, before expiries became part of link records.
+	legacyExpiryKeyPrefix = "expiry/"
+)
+
+// ErrUnsupportedRecordVersion is returned when a link record was written by a newer version of the service.
+var ErrUnsupportedRecordVersion = errors.New("unsupported link record version")
+
+// errStopLinks is returned by the functions called by forEachLinkFrom to stop the iteration.
+var errStopLinks = errors.New("stop iterating over links")
+
+// LinkRecord represents the value stored in the URL database for a URL key.
+type LinkRecord struct {
+	// Version identifies the record format.
+	Version int `json:"version"`
+	// URL contains the target of the shortened URL.
+	URL string `json:"url"`
+	// CreatedAt contains the time at which the link was created, which is unknown for migrated links.
+	CreatedAt time.Time `json:"created_at"`
+	// Creator identifies who created the link.
+	Creator string `json:"creator,omitempty"`
+	// ExpiresAt contains the time after which the link stops redirecting.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RedirectType contains the HTTP status code used to redirect, which defaults to 302.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// Tags contains arbitrary labels attached to the link.
+	Tags []string `json:"tags,omitempty"`
+	// Flags contains markers altering how the link is handled.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// NewLinkRecord creates a link record for a URL, which expires at expiresAt unless it is the zero time.
+func NewLinkRecord(URL string, createdAt, expiresAt time.Time) *LinkRecord {
+	record := &LinkRecord{
+		Version:   LinkRecordVersion,
+		URL:       URL,
+		CreatedAt: createdAt.UTC(),
+	}
+	if !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		record.ExpiresAt = &expiresAt
+	}
+	return record
+}
+
+// IsExpired determines whether the link has expired at the given time.
+func (r *LinkRecord) IsExpired(now time.Time) bool {
+	return r.ExpiresAt != nil && isExpired(*r.ExpiresAt, now)
+}
+
+// SameLink determines whether another record links the same way: to the same URL, for the same
+// creator, with the same redirect type and expiry.
+func (r *LinkRecord) SameLink(other *LinkRecord) bool {
+	if r.URL != other.URL || r.Creator != other.Creator || r.RedirectStatus() != other.RedirectStatus() {
+		return false
+	}
+	if r.ExpiresAt == nil || other.ExpiresAt == nil {
+		return r.ExpiresAt == other.ExpiresAt
+	}
+	return r.ExpiresAt.Equal(*other.ExpiresAt)
+}
+
+// RedirectStatus returns the HTTP status code with which the link redirects.
+func (r *LinkRecord) RedirectStatus() int {
+	if r.RedirectType == 0 {
+		return http.StatusFound
+	}
+	return r.RedirectType
+}
+
+// Encode serializes the record for storage.
+func (r *LinkRecord) Encode() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// DecodeLinkRecord deserializes a stored value. Legacy values holding the raw URL
+// bytes are converted to a record, in which case legacy is true. As stored URLs are
+// absolute, a legacy value can never start with the brace opening a record.
+func DecodeLinkRecord(value []byte) (record *LinkRecord, legacy bool, err error) {
+	if !bytes.HasPrefix(value, []byte("{")) {
+		return &LinkRecord{Version: LinkRecordVersion, URL: string(value)}, true, nil
+	}
+
+	record = &LinkRecord{}
+	if err = json.Unmarshal(value, record); err != nil {
+		return nil, false, err
+	}
+
+	if record.Version > LinkRecordVersion {
+		return nil, false, fmt.Errorf("%w: %d", ErrUnsupportedRecordVersion, record.Version)
+	}
+
+	return record, false, nil
+}
+
+// readLinkRecord retrieves the record of a URL key. The expiry of legacy values,
+// which was stored in a separate keyspace, is folded into the returned record.
+func readLinkRecord(urlDatabase URLDatabase, URLKey []byte) (*LinkRecord, bool, error) {
+	value, err := urlDatabase.Get(URLKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record, legacy, err := DecodeLinkRecord(value)
+	if err != nil || !legacy {
+		return record, legacy, err
+	}
+
+	expiryBytes, err := urlDatabase.Get(legacyExpiryKey(URLKey))
+	if err == storage.ErrNotFound {
+		return record, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// A legacy expiry which cannot be decoded is treated as already passed.
+	expiresAt, err := time.Parse(time.RFC3339Nano, string(expiryBytes))
+	if err != nil {
+		expiresAt = time.Unix(0, 0).UTC()
+	}
+	record.ExpiresAt = &expiresAt
+
+	return record, true, nil
+}
+
+// writeLinkRecord stores the record of a URL key.
+func writeLinkRecord(urlDatabase URLDatabase, URLKey []byte, record *LinkRecord) error {
+	value, err := record.Encode()
+	if err != nil {
+		return err
+	}
+	return urlDatabase.Put(URLKey, value)
+}
+
+// upgradeLinkRecord rewrites a legacy value as a record, removing its legacy expiry atomically.
+func upgradeLinkRecord(urlDatabase URLDatabase, URLKey []byte, record *LinkRecord) error {
+	value, err := record.Encode()
+	if err != nil {
+		return err
+	}
+
+	batch := new(storage.Batch)
+	batch.Put(URLKey, value)
+	batch.Delete(legacyExpiryKey(URLKey))
+	return urlDatabase.Write(batch)
+}
+
+// GetLinkRecord retrieves the record of a URL key, rewriting legacy values as records on access.
+// The record is returned even when rewriting it fails, as it can be rewritten on a later access.
+func GetLinkRecord(urlDatabase URLDatabase, URLKey []byte) (*LinkRecord, error) {
+	record, legacy, err := readLinkRecord(urlDatabase, URLKey)
+	if err != nil || !legacy {
+		return record, err
+	}
+
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	// The value is read again under lock, so that a concurrent update is not overwritten.
+	record, legacy, err = readLinkRecord(urlDatabase, URLKey)
+	if err != nil || !legacy {
+		return record, err
+	}
+
+	if err = upgradeLinkRecord(urlDatabase, URLKey, record); err != nil {
+		logger.Warn("unable to rewrite legacy value as link record", "key", string(URLKey), "error", err)
+	}
+
+	return record, nil
+}
+
+// FindLinkRecord retrieves the record of a URL key as given or, failing that, in its normalized
+// form, returning the key under which the record is stored. Custom keys are stored normalized,
+// whereas generated keys are always looked up with their exact case.
+func FindLinkRecord(urlDatabase URLDatabase, keyPolicy *KeyPolicy, URLKey string) (string, *LinkRecord, error) {
+	record, err := GetLinkRecord(urlDatabase, []byte(URLKey))
+	if err == storage.ErrNotFound {
+		if normalizedKey := keyPolicy.Normalize(URLKey); normalizedKey != URLKey {
+			URLKey = normalizedKey
+			record, err = GetLinkRecord(urlDatabase, []byte(URLKey))
+		}
+	}
+	return URLKey, record, err
+}
+
+// MigrateLinkRecords rewrites every legacy value of the URL database as a record and removes
+// legacy expiries left without a URL key, returning the number of values rewritten.
+func MigrateLinkRecords(urlDatabase URLDatabase) (int, error) {
+	legacyKeys := [][]byte{}
+	err := forEachLink(urlDatabase, func(URLKey, value []byte) error {
+		if !bytes.HasPrefix(value, []byte("{")) {
+			legacyKeys = append(legacyKeys, append([]byte{}, URLKey...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, URLKey := range legacyKeys {
+		wasMigrated, err := migrateLinkRecord(urlDatabase, URLKey)
+		if err != nil {
+			return migrated, err
+		}
+		if wasMigrated {
+			migrated++
+		}
+	}
+
+	orphanedExpiryKeys := [][]byte{}
+	iter := urlDatabase.NewIterator([]byte(legacyExpiryKeyPrefix))
+	for iter.Next() {
+		orphanedExpiryKeys = append(orphanedExpiryKeys, append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err = iter.Error(); err != nil {
+		return migrated, err
+	}
+
+	for _, expiryKey := range orphanedExpiryKeys {
+		if err = urlDatabase.Delete(expiryKey); err != nil {
+			return migrated, err
+		}
+	}
+
+	return migrated, nil
+}
+
+// migrateLinkRecord rewrites the value of a URL key as a record if it is still a legacy value.
+func migrateLinkRecord(urlDatabase URLDatabase, URLKey []byte) (bool, error) {
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	record, legacy, err := readLinkRecord(urlDatabase, URLKey)
+	if err == storage.ErrNotFound || (err == nil && !legacy) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err = upgradeLinkRecord(urlDatabase, URLKey, record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// legacyExpiryKey returns the database key which held the expiry of a legacy value.
+func legacyExpiryKey(URLKey []byte) []byte {
+	return append([]byte(legacyExpiryKeyPrefix), URLKey...)
+}
+
+// deleteLinkEntries adds the deletion of the click events and legacy expiry of a URL key to a
+// batch, so that a link later stored under the key does not inherit them.
+func deleteLinkEntries(urlDatabase URLDatabase, batch *storage.Batch, URLKey []byte) error {
+	batch.Delete(legacyExpiryKey(URLKey))
+	return forEachClick(urlDatabase, string(URLKey), func(clickKey, _ []byte) {
+		batch.Delete(clickKey)
+	})
+}
+
+// decodeStoredLink decodes the value stored for a URL key, folding the expiry of legacy values
+// into the record as readLinkRecord does.
+func decodeStoredLink(urlDatabase URLDatabase, URLKey, value []byte) (*LinkRecord, error) {
+	record, legacy, err := DecodeLinkRecord(value)
+	if legacy {
+		record, _, err = readLinkRecord(urlDatabase, URLKey)
+	}
+	return record, err
+}
+
+// forEachLink calls fn with every URL key and its stored value. Keyspaces such as the
+// click events, whose keys contain a slash unlike URL keys, are skipped over.
+func forEachLink(urlDatabase URLDatabase, fn func(URLKey, value []byte) error) error {
+	return forEachLinkFrom(urlDatabase, nil, fn)
+}
+
+// forEachLinkFrom calls fn with every URL key greater than or equal to start, and its stored
+// value, as forEachLink does. The iteration stops without error when fn returns errStopLinks.
+func forEachLinkFrom(urlDatabase URLDatabase, start []byte, fn func(URLKey, value []byte) error) error {
+	iter := urlDatabase.NewIterator(nil)
+	defer iter.Release()
+
+	var ok bool
+	if start == nil {
+		ok = iter.Next()
+	} else {
+		ok = iter.Seek(start)
+	}
+
+	for ok {
+		key := iter.Key()
+
+		if slash := bytes.IndexByte(key, '/'); slash >= 0 {
+			keyspaceLimit := storage.PrefixLimit(key[:slash+1])
+			if keyspaceLimit == nil {
+				break
+			}
+			ok = iter.Seek(keyspaceLimit)
+			continue
+		}
+
+		if err := fn(key, iter.Value()); err == errStopLinks {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ok = iter.Next()
+	}
+
+	return iter.Error()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("LinkRecord", func() {
+	createdAt := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	Describe("DecodeLinkRecord", func() {
+		It("decodes encoded records", func() {
+			record := NewLinkRecord("https://duckduckgo.com/", createdAt, createdAt.Add(time.Hour))
+			record.Tags = []string{"search"}
+			value, err := record.Encode()
+			Expect(err).NotTo(HaveOccurred())
+
+			decodedRecord, legacy, err := DecodeLinkRecord(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeFalse())
+			Expect(decodedRecord).To(Equal(record))
+		})
+
+		It("converts legacy raw URL values", func() {
+			record, legacy, err := DecodeLinkRecord([]byte("https://duckduckgo.com/"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeTrue())
+			Expect(record.URL).To(Equal("https://duckduckgo.com/"))
+			Expect(record.Version).To(Equal(LinkRecordVersion))
+		})
+
+		It("rejects records of a newer version", func() {
+			_, _, err := DecodeLinkRecord([]byte(`{"version":99,"url":"https://duckduckgo.com/"}`))
+			Expect(err).To(MatchError(ErrUnsupportedRecordVersion))
+		})
+
+		It("rejects malformed records", func() {
+			_, _, err := DecodeLinkRecord([]byte(`{"version":`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("RedirectStatus", func() {
+		It("defaults to a 302", func() {
+			Expect(NewLinkRecord("https://duckduckgo.com/", createdAt, time.Time{}).RedirectStatus()).To(Equal(302))
+		})
+	})
+
+	Context("with a URL database holding legacy values", func() {
+		var urlDatabase *storage.Memory
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+
+			Expect(urlDatabase.Put([]byte("legacy"), []byte("https://legacy.example/"))).To(Succeed())
+			Expect(urlDatabase.Put([]byte("expiring"), []byte("https://expiring.example/"))).To(Succeed())
+			Expect(urlDatabase.Put([]byte("expiry/expiring"), []byte("2100-01-01T00:00:00Z"))).To(Succeed())
+			Expect(urlDatabase.Put([]byte("expiry/orphaned"), []byte("2100-01-01T00:00:00Z"))).To(Succeed())
+			Expect(writeLinkRecord(urlDatabase, []byte("current"), NewLinkRecord("https://current.example/", createdAt, time.Time{}))).To(Succeed())
+			Expect(RecordClick(urlDatabase, ClickEvent{Key: "legacy", Timestamp: createdAt})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			urlDatabase.Close()
+		})
+
+		expectRecord := func(URLKey string) *LinkRecord {
+			value, err := urlDatabase.Get([]byte(URLKey))
+			Expect(err).NotTo(HaveOccurred())
+			record, legacy, err := DecodeLinkRecord(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeFalse())
+			return record
+		}
+
+		Describe("GetLinkRecord", func() {
+			It("rewrites legacy values on access", func() {
+				record, err := GetLinkRecord(urlDatabase, []byte("expiring"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(record.URL).To(Equal("https://expiring.example/"))
+
+				storedRecord := expectRecord("expiring")
+				Expect(storedRecord.ExpiresAt).NotTo(BeNil())
+				Expect(*storedRecord.ExpiresAt).To(Equal(time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)))
+
+				_, err = urlDatabase.Get([]byte("expiry/expiring"))
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+		})
+
+		Describe("MigrateLinkRecords", func() {
+			It("rewrites every legacy value", func() {
+				migrated, err := MigrateLinkRecords(urlDatabase)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(migrated).To(Equal(2))
+
+				Expect(expectRecord("legacy").URL).To(Equal("https://legacy.example/"))
+				Expect(expectRecord("expiring").ExpiresAt).NotTo(BeNil())
+				Expect(expectRecord("current").URL).To(Equal("https://current.example/"))
+			})
+
+			It("removes orphaned legacy expiries", func() {
+				_, err := MigrateLinkRecords(urlDatabase)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = urlDatabase.Get([]byte("expiry/orphaned"))
+				Expect(err).To(Equal(storage.ErrNotFound))
+			})
+
+			It("leaves other keyspaces untouched", func() {
+				_, err := MigrateLinkRecords(urlDatabase)
+				Expect(err).NotTo(HaveOccurred())
+
+				statistics, err := GetClickStatistics(urlDatabase, "legacy")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(1))
+			})
+		})
+	})
+})
@@ -2,31 +2,62 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	dberror "github.com/syndtr/goleveldb/leveldb/errors"
+
+	"github.com/upsideon/bajo/storage"
 )
 
 // RedirectController manages URL redirection.
 type RedirectController struct {
 	URLDatabase URLDatabase
+	KeyPolicy   *KeyPolicy
+	Metrics     *Metrics
+	// ReadOnly skips recording clicks, which cannot be stored in a read-only database.
+	ReadOnly bool
 }
 
 // Redirect implements the logic for URL redirection.
 func (c *RedirectController) Redirect(context *gin.Context) {
 	URLKey := context.Param("key")
+	addLogFields(context, "key", URLKey)
 
-	urlBytes, err := c.URLDatabase.Get([]byte(URLKey), nil)
+	URLKey, record, err := FindLinkRecord(c.URLDatabase, c.KeyPolicy, URLKey)
 
 	if err != nil {
-		if err == dberror.ErrNotFound {
-			context.String(http.StatusNotFound, "Not Found")
+		if err == storage.ErrNotFound {
+			c.Metrics.RecordRedirect(RedirectOutcomeMiss)
+			respondWithError(context, errNotFound())
 			return
 		} else {
-			context.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Metrics.RecordRedirect(RedirectOutcomeError)
+			respondWithError(context, errInternal(err))
 			return
 		}
 	}
 
-	context.Redirect(http.StatusFound, string(urlBytes))
+	// Expired keys are reported as gone until the expiry sweeper deletes them.
+	if record.IsExpired(time.Now()) {
+		c.Metrics.RecordRedirect(RedirectOutcomeExpired)
+		respondWithError(context, NewAPIError(http.StatusGone, ErrorCodeGone, "The link has expired"))
+		return
+	}
+
+	// A failure to record the click should not prevent the user from being redirected.
+	if !c.ReadOnly {
+		click := ClickEvent{
+			Key:       URLKey,
+			Timestamp: time.Now().UTC(),
+			Referrer:  context.Request.Referer(),
+			UserAgent: context.Request.UserAgent(),
+		}
+		if err := RecordClick(c.URLDatabase, click); err != nil {
+			requestLogger(context).Warn("unable to record click", "key", URLKey, "error", err)
+		}
+	}
+
+	c.Metrics.RecordRedirect(RedirectOutcomeHit)
+	addLogFields(context, "outcome", RedirectOutcomeHit)
+	context.Redirect(record.RedirectStatus(), record.URL)
 }
@@ -1,21 +1,24 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	dberror "github.com/syndtr/goleveldb/leveldb/errors"
 
 	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
 )
 
 var _ = Describe("URL redirects", func() {
+	var config *Config
 	var router *gin.Engine
 	var mockURLDatabase *mocks.MockURLDatabase
 	var urlKey string
@@ -24,11 +27,12 @@ var _ = Describe("URL redirects", func() {
 	BeforeEach(func() {
 		ctrl := gomock.NewController(GinkgoT())
 		mockURLDatabase = mocks.NewMockURLDatabase(ctrl)
-		router = initializeRouter(mockURLDatabase)
+		config = DefaultConfig()
 		writer = httptest.NewRecorder()
 	})
 
 	JustBeforeEach(func() {
+		router, _ = initializeRouter(mockURLDatabase, config)
 		request, _ := http.NewRequest("GET", fmt.Sprintf("/%s", urlKey), nil)
 		router.ServeHTTP(writer, request)
 	})
@@ -51,7 +55,7 @@ var _ = Describe("URL redirects", func() {
 		Context("and there is an error retrieving it from database", func() {
 			BeforeEach(func() {
 				mockURLDatabase.EXPECT().Get(
-					[]byte(urlKey), nil,
+					[]byte(urlKey),
 				).Return(nil, errors.New("failed to query database"))
 			})
 
@@ -60,15 +64,15 @@ var _ = Describe("URL redirects", func() {
 			})
 
 			It("return error message", func() {
-				Expect(writer.Body.String()).To(Equal("Internal Server Error"))
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
 			})
 		})
 
 		Context("and the URL key is not present in database", func() {
 			BeforeEach(func() {
 				mockURLDatabase.EXPECT().Get(
-					[]byte(urlKey), nil,
-				).Return(nil, dberror.ErrNotFound)
+					[]byte(urlKey),
+				).Return(nil, storage.ErrNotFound)
 			})
 
 			It("returns a 404", func() {
@@ -76,20 +80,185 @@ var _ = Describe("URL redirects", func() {
 			})
 
 			It("return error message", func() {
-				Expect(writer.Body.String()).To(Equal("Not Found"))
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeNotFound))
 			})
 		})
 
 		Context("and the URL key is present in database", func() {
+			var storedRecord *LinkRecord
+
 			BeforeEach(func() {
+				storedRecord = NewLinkRecord("https://duckduckgo.com/", time.Now(), time.Time{})
+
 				mockURLDatabase.EXPECT().Get(
-					[]byte(urlKey), nil,
-				).Return([]byte("https://duckduckgo.com/"), nil)
+					[]byte(urlKey),
+				).DoAndReturn(func(_ []byte) ([]byte, error) {
+					return storedRecord.Encode()
+				})
+			})
+
+			Context("and it has expired", func() {
+				BeforeEach(func() {
+					expiresAt := time.Now().Add(-time.Minute)
+					storedRecord.ExpiresAt = &expiresAt
+				})
+
+				It("returns a 410", func() {
+					Expect(writer.Code).To(Equal(http.StatusGone))
+				})
+
+				It("return error message", func() {
+					Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeGone))
+				})
+			})
+
+			Context("and it expires in the future", func() {
+				BeforeEach(func() {
+					expiresAt := time.Now().Add(time.Hour)
+					storedRecord.ExpiresAt = &expiresAt
+					mockURLDatabase.EXPECT().Put(
+						gomock.Any(), gomock.Any(),
+					).Return(nil)
+				})
+
+				It("returns a 302", func() {
+					Expect(writer.Code).To(Equal(http.StatusFound))
+				})
+			})
+
+			Context("and it has a redirect type", func() {
+				BeforeEach(func() {
+					storedRecord.RedirectType = http.StatusMovedPermanently
+					mockURLDatabase.EXPECT().Put(
+						gomock.Any(), gomock.Any(),
+					).Return(nil)
+				})
+
+				It("redirects with the redirect type", func() {
+					Expect(writer.Code).To(Equal(http.StatusMovedPermanently))
+				})
+			})
+
+			Context("and the database is read-only", func() {
+				BeforeEach(func() {
+					config.LevelDBReadOnly = true
+				})
+
+				It("redirects without recording the click", func() {
+					Expect(writer.Code).To(Equal(http.StatusFound))
+				})
+			})
+
+			Context("and recording the click fails", func() {
+				BeforeEach(func() {
+					mockURLDatabase.EXPECT().Put(
+						gomock.Any(), gomock.Any(),
+					).Return(errors.New("failed to record click"))
+				})
+
+				It("returns a 302", func() {
+					Expect(writer.Code).To(Equal(http.StatusFound))
+				})
+			})
+
+			Context("and recording the click succeeds", func() {
+				var clickKey, clickValue []byte
+
+				BeforeEach(func() {
+					mockURLDatabase.EXPECT().Put(
+						gomock.Any(), gomock.Any(),
+					).DoAndReturn(func(key, value []byte) error {
+						clickKey, clickValue = key, value
+						return nil
+					})
+				})
+
+				It("returns a 302", func() {
+					Expect(writer.Code).To(Equal(http.StatusFound))
+				})
+
+				It("redirects to the URL", func() {
+					Expect(writer.Header().Get("Location")).To(Equal("https://duckduckgo.com/"))
+				})
+
+				It("stores the click in the click keyspace", func() {
+					Expect(string(clickKey)).To(HavePrefix(fmt.Sprintf("%s%s/", ClickKeyPrefix, urlKey)))
+
+					var click ClickEvent
+					Expect(json.Unmarshal(clickValue, &click)).To(Succeed())
+					Expect(click.Key).To(Equal(urlKey))
+				})
+			})
+		})
+
+		Context("and the URL key holds a legacy value", func() {
+			BeforeEach(func() {
+				legacyExpiryKey := []byte("expiry/" + urlKey)
+
+				for i := 0; i < 2; i++ {
+					mockURLDatabase.EXPECT().Get(
+						[]byte(urlKey),
+					).Return([]byte("https://duckduckgo.com/"), nil)
+					mockURLDatabase.EXPECT().Get(
+						legacyExpiryKey,
+					).Return(nil, storage.ErrNotFound)
+				}
+
+				mockURLDatabase.EXPECT().Write(
+					gomock.Any(),
+				).Return(nil)
+				mockURLDatabase.EXPECT().Put(
+					gomock.Any(), gomock.Any(),
+				).Return(nil)
 			})
 
 			It("returns a 302", func() {
 				Expect(writer.Code).To(Equal(http.StatusFound))
 			})
+
+			It("redirects to the URL", func() {
+				Expect(writer.Header().Get("Location")).To(Equal("https://duckduckgo.com/"))
+			})
+		})
+
+		Context("and keys are case insensitive", func() {
+			BeforeEach(func() {
+				urlKey = "Docs"
+				config.CaseInsensitiveKeys = true
+			})
+
+			Context("and the URL key is only present in lowercase", func() {
+				BeforeEach(func() {
+					mockURLDatabase.EXPECT().Get(
+						[]byte("Docs"),
+					).Return(nil, storage.ErrNotFound)
+					mockURLDatabase.EXPECT().Get(
+						[]byte("docs"),
+					).Return(encodedLinkRecord("https://duckduckgo.com/"), nil)
+					mockURLDatabase.EXPECT().Put(
+						gomock.Any(), gomock.Any(),
+					).Return(nil)
+				})
+
+				It("returns a 302", func() {
+					Expect(writer.Code).To(Equal(http.StatusFound))
+				})
+			})
+
+			Context("and the URL key is not present in any case", func() {
+				BeforeEach(func() {
+					mockURLDatabase.EXPECT().Get(
+						[]byte("Docs"),
+					).Return(nil, storage.ErrNotFound)
+					mockURLDatabase.EXPECT().Get(
+						[]byte("docs"),
+					).Return(nil, storage.ErrNotFound)
+				})
+
+				It("returns a 404", func() {
+					Expect(writer.Code).To(Equal(http.StatusNotFound))
+				})
+			})
 		})
 	})
 })
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestIDHeader names the header carrying the identifier of a request.
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength limits the length of request identifiers taken from clients.
+	maxRequestIDLength = 128
+
+	// requestIDContextKey is the key under which the request identifier is stored in the request context.
+	requestIDContextKey = "request_id"
+
+	// logFieldsContextKey is the key under which the fields logged with a request are stored in the request context.
+	logFieldsContextKey = "log_fields"
+)
+
+// RequestID is a middleware identifying each request, with the identifier given in its
+// X-Request-ID header or a generated one, which is returned in the X-Request-ID header of the
+// response, so that requests can be traced across services and log entries.
+func RequestID(context *gin.Context) {
+	context.Header(RequestIDHeader, requestID(context))
+	context.Next()
+}
+
+// LogRequests is a middleware logging every request once it has been handled, along with its
+// status, latency and the fields added by its handlers. Server errors are logged as errors.
+func LogRequests(context *gin.Context) {
+	start := time.Now()
+
+	context.Next()
+
+	status := context.Writer.Status()
+	level := LogLevelInfo
+	if status >= http.StatusInternalServerError {
+		level = LogLevelError
+	}
+
+	fields := []interface{}{
+		"method", context.Request.Method,
+		"path", context.Request.URL.Path,
+		"status", status,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"client_ip", context.ClientIP(),
+	}
+	if route := context.FullPath(); route != "" {
+		fields = append(fields, "route", route)
+	}
+	if principal := requestPrincipal(context); principal != nil {
+		fields = append(fields, "api_key_id", principal.KeyID)
+	}
+	if value, ok := context.Get(logFieldsContextKey); ok {
+		fields = append(fields, value.([]interface{})...)
+	}
+
+	requestLogger(context).Log(level, "request handled", fields...)
+}
+
+// recoverPanic responds with an internal error to requests whose handler panicked, logging
+// the stack of the panic.
+func recoverPanic(context *gin.Context, recovered interface{}) {
+	addLogFields(context, "stack", string(debug.Stack()))
+	abortWithError(context, errInternal(fmt.Errorf("panic: %v", recovered)))
+}
+
+// requestLogger returns the logger of a request, which adds its identifier to every entry.
+func requestLogger(context *gin.Context) *Logger {
+	return logger.With("request_id", requestID(context))
+}
+
+// addLogFields adds fields, given as alternating keys and values, to the entry logged once a
+// request has been handled.
+func addLogFields(context *gin.Context, fields ...interface{}) {
+	existing, _ := context.Get(logFieldsContextKey)
+	existingFields, _ := existing.([]interface{})
+	context.Set(logFieldsContextKey, append(existingFields, fields...))
+}
+
+// requestID returns the identifier of a request, which is taken from its X-Request-ID header
+// when present and well-formed, and generated otherwise.
+func requestID(context *gin.Context) string {
+	if ID := context.GetString(requestIDContextKey); ID != "" {
+		return ID
+	}
+
+	ID := strings.TrimSpace(context.GetHeader(RequestIDHeader))
+	if !isValidRequestID(ID) {
+		ID = ""
+		randomBytes := make([]byte, 16)
+		if _, err := rand.Read(randomBytes); err == nil {
+			ID = hex.EncodeToString(randomBytes)
+		}
+	}
+
+	context.Set(requestIDContextKey, ID)
+	return ID
+}
+
+// isValidRequestID determines whether a request identifier given by a client may be used, which
+// is when it is short and made of printable ASCII characters, so that it cannot forge log entries.
+func isValidRequestID(ID string) bool {
+	if ID == "" || len(ID) > maxRequestIDLength {
+		return false
+	}
+	for _, character := range ID {
+		if character < '!' || character > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultShutdownTimeout defines how long in-flight requests are given to complete on shutdown by default.
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// readHeaderTimeout limits how long clients may take to send the headers of a request.
+	readHeaderTimeout = 10 * time.Second
+)
+
+// Serve serves HTTP requests on a listener until the context is cancelled, after which the
+// server stops accepting connections and waits for in-flight requests to complete, for at most
+// the shutdown timeout, before closing the remaining connections.
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler, shutdownTimeout time.Duration) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down, draining in-flight requests", "timeout", shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("in-flight requests did not complete in time, closing their connections", "error", err)
+		server.Close()
+	}
+
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
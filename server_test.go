@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var _ = Describe("Server", func() {
+	Describe("Serve", func() {
+		var listener net.Listener
+		var requestStarted, releaseRequest chan struct{}
+		var handler http.Handler
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The handler keeps the channels of its spec, as a request left hanging by a spec
+			// may outlive it.
+			started, release := make(chan struct{}), make(chan struct{})
+			requestStarted, releaseRequest = started, release
+			handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				close(started)
+				<-release
+				writer.WriteHeader(http.StatusNoContent)
+			})
+		})
+
+		serve := func(ctx context.Context, shutdownTimeout time.Duration) chan error {
+			served := make(chan error, 1)
+			go func() {
+				served <- Serve(ctx, listener, handler, shutdownTimeout)
+			}()
+			return served
+		}
+
+		request := func() chan *http.Response {
+			responses := make(chan *http.Response, 1)
+			go func() {
+				response, _ := http.Get("http://" + listener.Addr().String() + "/")
+				responses <- response
+			}()
+			return responses
+		}
+
+		It("completes in-flight requests before returning", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			served := serve(ctx, time.Minute)
+			responses := request()
+			Eventually(requestStarted).Should(BeClosed())
+
+			cancel()
+			Consistently(served, 100*time.Millisecond).ShouldNot(Receive())
+
+			close(releaseRequest)
+			Eventually(served).Should(Receive(BeNil()))
+			response := <-responses
+			Expect(response).NotTo(BeNil())
+			Expect(response.StatusCode).To(Equal(http.StatusNoContent))
+		})
+
+		It("closes the connections of requests exceeding the shutdown timeout", func() {
+			defer close(releaseRequest)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			served := serve(ctx, 50*time.Millisecond)
+			responses := request()
+			Eventually(requestStarted).Should(BeClosed())
+
+			cancel()
+			Eventually(served).Should(Receive(BeNil()))
+			Eventually(responses).Should(Receive(BeNil()))
+		})
+
+		It("stops accepting connections once shut down", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			served := serve(ctx, time.Minute)
+
+			cancel()
+			Eventually(served).Should(Receive(BeNil()))
+
+			_, err := net.Dial("tcp", listener.Addr().String())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("run", func() {
+		It("exits with status 2 when the configuration is invalid", func() {
+			Expect(run([]string{"-url-key-size", "0"})).To(Equal(2))
+		})
+
+		It("exits with status 1 when the URL database is locked", func() {
+			path := GinkgoT().TempDir()
+			lockingDatabase, err := leveldb.OpenFile(path, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer lockingDatabase.Close()
+
+			Expect(run([]string{"-database-path", path})).To(Equal(1))
+		})
+
+		It("exits with status 1 when the listen address is in use", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			Expect(run([]string{"-database-path", GinkgoT().TempDir(), "-listen-address", listener.Addr().String()})).To(Equal(1))
+		})
+	})
+})
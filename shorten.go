@@ -1,88 +1,311 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	dberror "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/upsideon/bajo/storage"
 )
 
 const (
-	// CustomKeySizeLimit determines the maximum size of a custom key.
-	CustomKeySizeLimit = 32
+	// DefaultCustomKeySizeLimit determines the default maximum size of a custom key.
+	DefaultCustomKeySizeLimit = 32
 
-	// URLKeySize defines the number of characters in a URL key.
-	// As URL keys are encoded in Base 64 there are 64 ^ URLKeySize possible keys.
-	URLKeySize = 8
+	// DefaultURLKeySize defines the default number of characters in a URL key.
+	// As URL keys are encoded in Base 64 there are 64 ^ DefaultURLKeySize possible keys.
+	// Generated keys are extended beyond this size when a hash collision occurs.
+	DefaultURLKeySize = 8
 
-	// URLPrefix defines the prefix for shortened URLs.
-	URLPrefix = "https://bajo"
+	// DefaultURLPrefix defines the default prefix for shortened URLs.
+	DefaultURLPrefix = "https://bajo"
 )
 
+// ErrURLKeyExhausted is returned when every key generated from a URL hash maps to another URL.
+var ErrURLKeyExhausted = errors.New("all keys generated from the URL hash are in use")
+
+// KeyConflictError is returned when a custom key is already taken by another URL.
+type KeyConflictError struct {
+	Key         string
+	ExistingURL string
+}
+
+// Error describes the conflict.
+func (e *KeyConflictError) Error() string {
+	return fmt.Sprintf("custom key %s is already in use", e.Key)
+}
+
+// urlKeyMutex serializes the check and insertion of URL keys, so that concurrent
+// requests for the same key cannot both claim it.
+var urlKeyMutex sync.Mutex
+
 // ShortenRequest represents a request to the URL shortening route.
 type ShortenRequest struct {
 	// Key contains an optional custom key with which to index the provided URL.
 	Key string `form:"key" json:"key,omitempty" binding:"-"`
 	// URL contains the URL to be shortened.
 	URL string `form:"url" json:"url" binding:"required"`
+	// ExpiresAt contains an optional time after which the shortened URL stops redirecting.
+	ExpiresAt *time.Time `form:"expires_at" json:"expires_at,omitempty" binding:"-"`
+	// TTLSeconds contains an optional number of seconds after which the shortened URL stops redirecting.
+	TTLSeconds *int64 `form:"ttl_seconds" json:"ttl_seconds,omitempty" binding:"-"`
+	// RedirectType contains an optional HTTP status code with which the shortened URL redirects.
+	RedirectType int `form:"redirect_type" json:"redirect_type,omitempty" binding:"omitempty,oneof=301 302 307 308"`
+	// Tags contains optional labels attached to the shortened URL.
+	Tags []string `form:"tags" json:"tags,omitempty" binding:"-"`
+}
+
+// Expiry returns the time at which the shortened URL expires, which is the zero time
+// when no expiry was requested.
+func (r *ShortenRequest) Expiry(now time.Time) (time.Time, error) {
+	return requestedExpiry(r.ExpiresAt, r.TTLSeconds, now)
 }
 
 // ShortenController contains logic and data related to the /shorten route.
 type ShortenController struct {
-	URLDatabase URLDatabase
+	URLDatabase       URLDatabase
+	Config            *Config
+	URLPrefixResolver *URLPrefixResolver
+	KeyPolicy         *KeyPolicy
+	Metrics           *Metrics
 }
 
-// Shorten implements the logic for the /shorten route.
+// Shorten implements the logic for the /shorten route. Requests are accepted as JSON, as
+// URL-encoded or multipart forms and, for bookmarklets, as the query string of a GET request.
+// Responses are given in JSON or plain text, depending on the Accept header.
 func (c *ShortenController) Shorten(context *gin.Context) {
 	var shortenRequest ShortenRequest
-	var URLKey string
 
-	if err := context.BindJSON(&shortenRequest); err != nil {
-		fmt.Println("Error: ", err)
-		context.String(http.StatusBadRequest, "Bad Request")
+	if err := bindShortenRequest(context, &shortenRequest); err != nil {
+		c.respondWithShortenError(context, errBinding(err, &shortenRequest))
+		return
+	}
+
+	if shortenRequest.Key != "" {
+		addLogFields(context, "key", shortenRequest.Key)
+	}
+
+	record, err := c.newLinkRecord(&shortenRequest, requestPrincipal(context), time.Now())
+	if err != nil {
+		c.respondWithShortenError(context, shortenError(err))
+		return
+	}
+
+	outcome := ShortenOutcomeExisting
+	URLKey, err := c.claimURLKey(&shortenRequest, record, func(URLKey []byte, record *LinkRecord) (*LinkRecord, error) {
+		storedRecord, err := storeLinkRecord(c.URLDatabase, URLKey, record)
+		if storedRecord == record {
+			outcome = ShortenOutcomeCreated
+		}
+		return storedRecord, err
+	})
+	if err != nil {
+		c.respondWithShortenError(context, shortenError(err))
+		return
+	}
+
+	c.Metrics.RecordShorten(outcome)
+	addLogFields(context, "key", URLKey, "outcome", outcome)
+
+	shortenedURL := fmt.Sprintf("%s/%s", c.URLPrefixResolver.Prefix(context.Request), URLKey)
+	negotiateShortenResponse(context, http.StatusOK, gin.H{
+		"shortened_url": shortenedURL,
+	}, shortenedURL)
+}
+
+// bindShortenRequest decodes a shorten request according to the method and content type of
+// the request. Bodies without a content type are decoded as JSON, as are URL-encoded bodies
+// holding a JSON object, which is what curl sends for -d '{"url": ...}'.
+func bindShortenRequest(context *gin.Context, shortenRequest *ShortenRequest) error {
+	if context.Request.Method == http.MethodGet {
+		return context.ShouldBindWith(shortenRequest, binding.Query)
+	}
+
+	switch context.ContentType() {
+	case binding.MIMEMultipartPOSTForm:
+		return context.ShouldBindWith(shortenRequest, binding.FormMultipart)
+	case binding.MIMEPOSTForm:
+		body, err := io.ReadAll(context.Request.Body)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			return binding.JSON.BindBody(body, shortenRequest)
+		}
+		context.Request.Body = io.NopCloser(bytes.NewReader(body))
+		return context.ShouldBindWith(shortenRequest, binding.Form)
+	default:
+		return context.ShouldBindWith(shortenRequest, binding.JSON)
+	}
+}
+
+// respondWithShortenError responds with the error which a shorten request failed with, counting its outcome.
+func (c *ShortenController) respondWithShortenError(context *gin.Context, apiErr *APIError) {
+	c.Metrics.RecordShorten(shortenOutcome(apiErr))
+	respondWithError(context, apiErr)
+}
+
+// shortenError converts the failure to shorten a URL into an API error.
+func shortenError(err error) *APIError {
+	var apiErr *APIError
+	var keyValidationError *KeyValidationError
+	var keyConflictError *KeyConflictError
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &keyValidationError):
+		return errKey(keyValidationError)
+	case errors.As(err, &keyConflictError):
+		return NewAPIError(http.StatusConflict, ErrorCodeKeyInUse, err.Error()).
+			WithField("key").
+			WithDetail("existing_url", keyConflictError.ExistingURL)
+	default:
+		return errInternal(err)
+	}
+}
+
+// negotiateShortenResponse responds with either a JSON object or plain text, as preferred
+// by the Accept header of the request. JSON is used when the client expresses no preference.
+func negotiateShortenResponse(context *gin.Context, status int, content gin.H, text string) {
+	if context.NegotiateFormat(binding.MIMEJSON, binding.MIMEPlain) == binding.MIMEPlain {
+		context.String(status, text)
 		return
 	}
+	context.JSON(status, content)
+}
+
+// newLinkRecord creates the link record requested by a shorten request, failing with an
+// API error when the requested URL or expiry is invalid.
+func (c *ShortenController) newLinkRecord(shortenRequest *ShortenRequest, principal *Principal, now time.Time) (*LinkRecord, error) {
+	normalizedURL, err := NormalizeURL(shortenRequest.URL, c.Config.AllowedSchemes, c.Config.MaxURLLength)
+	if err != nil {
+		return nil, errURL(err, "url")
+	}
+
+	expiresAt, err := shortenRequest.Expiry(now)
+	if err != nil {
+		return nil, errExpiry(err)
+	}
 
-	shortenRequestURLBytes := []byte(shortenRequest.URL)
+	record := NewLinkRecord(normalizedURL, now, expiresAt)
+	record.RedirectType = shortenRequest.RedirectType
+	record.Tags = shortenRequest.Tags
+	if principal != nil {
+		record.Creator = principal.Owner
+	}
+	return record, nil
+}
+
+// linkClaimer stores the record of a URL key unless the key already maps to an unexpired
+// record, returning the record which the key maps to once the call completes.
+type linkClaimer func(URLKey []byte, record *LinkRecord) (*LinkRecord, error)
 
+// claimURLKey stores a link record under the custom key of a shorten request or, when none
+// was provided, under a key generated from the URL, returning the key.
+func (c *ShortenController) claimURLKey(shortenRequest *ShortenRequest, record *LinkRecord, claim linkClaimer) (string, error) {
 	// When a custom key has not been provided, we generate one from the URL.
 	if shortenRequest.Key == "" {
-		hasher := sha256.New()
-		hasher.Write(shortenRequestURLBytes)
-		base64Hash := base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))
-		URLKey = base64Hash[:URLKeySize]
-	} else {
-		if len(shortenRequest.Key) > CustomKeySizeLimit {
-			fmt.Println("Error: Custom key size is too large")
-			context.String(http.StatusBadRequest, "Bad Request")
-			return
+		return c.claimGeneratedURLKey(record, claim)
+	}
+
+	URLKey := c.KeyPolicy.Normalize(shortenRequest.Key)
+	if err := c.KeyPolicy.Validate(URLKey); err != nil {
+		return "", err
+	}
+
+	storedRecord, err := claim([]byte(URLKey), record)
+	if err != nil {
+		return "", err
+	}
+
+	// A custom key which is already taken by another URL must not be handed out,
+	// as the shortened URL would redirect somewhere other than requested.
+	if storedRecord.URL != record.URL {
+		return "", &KeyConflictError{Key: URLKey, ExistingURL: storedRecord.URL}
+	}
+
+	return URLKey, nil
+}
+
+// claimGeneratedURLKey stores a link record under a key generated from the hash of its URL, returning the key.
+// The key is the first configured number of characters of the Base 64 encoded SHA-256 hash of the URL.
+// Should that key already map to a different link, the key is extended one hash character
+// at a time until a free or matching key is found, so that resolution is deterministic. A key
+// is only handed out again for the same link, so that a link requested to be permanent, or
+// for another owner, is never given the key of an expiring link or of another owner's link.
+func (c *ShortenController) claimGeneratedURLKey(record *LinkRecord, claim linkClaimer) (string, error) {
+	hash := sha256.Sum256([]byte(record.URL))
+	base64Hash := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	for keySize := c.Config.URLKeySize; keySize <= len(base64Hash); keySize++ {
+		URLKey := base64Hash[:keySize]
+
+		storedRecord, err := claim([]byte(URLKey), record)
+		if err != nil {
+			return "", err
 		}
-		URLKey = shortenRequest.Key
-	}
-
-	URLKeyBytes := []byte(URLKey)
-
-	if _, err := c.URLDatabase.Get(URLKeyBytes, nil); err != nil {
-		if err == dberror.ErrNotFound {
-			// When not already present, the mapping between the URL key and URL is stored.
-			if err = c.URLDatabase.Put(URLKeyBytes, shortenRequestURLBytes, nil); err != nil {
-				fmt.Println("Error: ", err)
-				context.String(http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
-		} else {
-			// Any other error signals something unrecoverable, so we terminate the request.
-			fmt.Println("Error: ", err)
-			context.String(http.StatusInternalServerError, "Internal Server Error")
-			return
+
+		if storedRecord.SameLink(record) {
+			return URLKey, nil
 		}
 	}
 
-	shortenedURL := fmt.Sprintf("%s/%s", URLPrefix, URLKey)
-	context.JSON(http.StatusOK, gin.H{
-		"shortened_url": shortenedURL,
-	})
+	return "", ErrURLKeyExhausted
+}
+
+// storeLinkRecord stores the record of a URL key unless the key is already present and
+// has not expired, returning the record which the key maps to once the call completes.
+func storeLinkRecord(urlDatabase URLDatabase, URLKey []byte, record *LinkRecord) (*LinkRecord, error) {
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	storedRecord, _, err := readLinkRecord(urlDatabase, URLKey)
+	if err == nil {
+		// An expired key which has not been swept yet is free to be reused.
+		if !storedRecord.IsExpired(time.Now()) {
+			return storedRecord, nil
+		}
+	} else if err != storage.ErrNotFound {
+		// Any error other than a missing key signals something unrecoverable.
+		return nil, err
+	}
+
+	// When not already present, the mapping between the URL key and URL is stored. The
+	// entries of an expired link are deleted along with it being replaced.
+	if err == storage.ErrNotFound {
+		err = writeLinkRecord(urlDatabase, URLKey, record)
+	} else {
+		err = replaceLinkRecord(urlDatabase, URLKey, record)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// replaceLinkRecord atomically stores the record of a URL key in place of another link,
+// deleting the entries of the replaced link.
+func replaceLinkRecord(urlDatabase URLDatabase, URLKey []byte, record *LinkRecord) error {
+	value, err := record.Encode()
+	if err != nil {
+		return err
+	}
+
+	batch := new(storage.Batch)
+	if err = deleteLinkEntries(urlDatabase, batch, URLKey); err != nil {
+		return err
+	}
+	batch.Put(URLKey, value)
+	return urlDatabase.Write(batch)
 }
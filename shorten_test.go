@@ -5,16 +5,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	dberror "github.com/syndtr/goleveldb/leveldb/errors"
 
 	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
 )
 
 const shortenURL = "/shorten"
@@ -27,7 +32,7 @@ var _ = Describe(shortenURL, func() {
 	BeforeEach(func() {
 		ctrl := gomock.NewController(GinkgoT())
 		mockURLDatabase = mocks.NewMockURLDatabase(ctrl)
-		router = initializeRouter(mockURLDatabase)
+		router, _ = initializeRouter(mockURLDatabase, DefaultConfig())
 		writer = httptest.NewRecorder()
 	})
 
@@ -42,22 +47,23 @@ var _ = Describe(shortenURL, func() {
 		})
 
 		It("return error message", func() {
-			Expect(writer.Body.String()).To(Equal("Bad Request"))
+			Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeMalformedRequest))
 		})
 	})
 
 	When("a URL is provided", func() {
 		const (
 			computedUrlKey = "oROh-p8o"
+			extendedUrlKey = "oROh-p8o7"
 			customUrlKey   = "custom"
 			exampleUrl     = "https://en.wikipedia.org/wiki/URL_shortening"
 			invalidUrlKey  = "thiskeyistoolongfortherouteisitnotmyfriend?"
 		)
 
-		var requestContent map[string]string
+		var requestContent map[string]interface{}
 
 		BeforeEach(func() {
-			requestContent = map[string]string{}
+			requestContent = map[string]interface{}{}
 		})
 
 		JustBeforeEach(func() {
@@ -66,25 +72,86 @@ var _ = Describe(shortenURL, func() {
 			router.ServeHTTP(writer, request)
 		})
 
+		Context("and the URL is invalid", func() {
+			BeforeEach(func() {
+				requestContent["url"] = "javascript:alert(1)"
+			})
+
+			It("returns a 400", func() {
+				Expect(writer.Code).To(Equal(http.StatusBadRequest))
+			})
+
+			It("return error message", func() {
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeURLSchemeNotAllowed))
+			})
+		})
+
+		Context("and the URL is valid but not normalized", func() {
+			BeforeEach(func() {
+				requestContent["url"] = "HTTPS://EN.Wikipedia.org:443/wiki/URL_shortening"
+				mockURLDatabase.EXPECT().Get(
+					[]byte(computedUrlKey),
+				).Return(nil, storage.ErrNotFound)
+				mockURLDatabase.EXPECT().Put(
+					[]byte(computedUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
+				).Return(nil)
+			})
+
+			It("returns a 200", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+			})
+
+			It("returns the shortened URL of the normalized URL", func() {
+				expectedResponseContent := map[string]string{
+					"shortened_url": fmt.Sprintf("https://bajo/%s", computedUrlKey),
+				}
+				expectedJson, _ := json.Marshal(expectedResponseContent)
+
+				Expect(writer.Body.String()).To(Equal(string(expectedJson)))
+			})
+		})
+
 		Context("and the URL is valid", func() {
 			BeforeEach(func() {
 				requestContent["url"] = exampleUrl
 			})
 
 			Context("and a custom URL key is specified", func() {
-				Context("and the custom URL key is invalid", func() {
-					BeforeEach(func() {
-						requestContent["key"] = invalidUrlKey
-					})
+				invalidKeys := []struct {
+					description string
+					key         string
+					code        string
+				}{
+					{"too long", invalidUrlKey, ErrorCodeKeyTooLong},
+					{"containing a slash", "docs/intro", ErrorCodeKeyInvalidCharacters},
+					{"containing a space", "my docs", ErrorCodeKeyInvalidCharacters},
+					{"containing a question mark", "docs?", ErrorCodeKeyInvalidCharacters},
+					{"named after a route", "shorten", ErrorCodeKeyReserved},
+					{"named after a route in another case", "Shorten", ErrorCodeKeyReserved},
+				}
+
+				for _, invalidKey := range invalidKeys {
+					invalidKey := invalidKey
+
+					Context(fmt.Sprintf("and the custom URL key is invalid by being %s", invalidKey.description), func() {
+						BeforeEach(func() {
+							requestContent["key"] = invalidKey.key
+						})
 
-					It("returns a 400", func() {
-						Expect(writer.Code).To(Equal(http.StatusBadRequest))
-					})
+						It("returns a 400", func() {
+							Expect(writer.Code).To(Equal(http.StatusBadRequest))
+						})
 
-					It("return error message", func() {
-						Expect(writer.Body.String()).To(Equal("Bad Request"))
+						It("returns the violated rule", func() {
+							var responseContent map[string]string
+							Expect(json.Unmarshal(writer.Body.Bytes(), &responseContent)).To(Succeed())
+							Expect(responseContent).To(HaveKeyWithValue("error", "Bad Request"))
+							Expect(responseContent).To(HaveKeyWithValue("field", "key"))
+							Expect(responseContent).To(HaveKeyWithValue("code", invalidKey.code))
+							Expect(responseContent).To(HaveKey("message"))
+						})
 					})
-				})
+				}
 
 				Context("and the custom URL key is valid", func() {
 					BeforeEach(func() {
@@ -94,7 +161,7 @@ var _ = Describe(shortenURL, func() {
 					Context("and there is an error checking for an existing URL key", func() {
 						BeforeEach(func() {
 							mockURLDatabase.EXPECT().Get(
-								[]byte(customUrlKey), nil,
+								[]byte(customUrlKey),
 							).Return(nil, errors.New("failed to query database"))
 						})
 
@@ -103,21 +170,21 @@ var _ = Describe(shortenURL, func() {
 						})
 
 						It("return error message", func() {
-							Expect(writer.Body.String()).To(Equal("Internal Server Error"))
+							Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
 						})
 					})
 
 					Context("and the URL key is not present in database", func() {
 						BeforeEach(func() {
 							mockURLDatabase.EXPECT().Get(
-								[]byte(customUrlKey), nil,
-							).Return(nil, dberror.ErrNotFound)
+								[]byte(customUrlKey),
+							).Return(nil, storage.ErrNotFound)
 						})
 
 						Context("and inserting the URL key fails", func() {
 							BeforeEach(func() {
 								mockURLDatabase.EXPECT().Put(
-									[]byte(customUrlKey), []byte(exampleUrl), nil,
+									[]byte(customUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
 								).Return(errors.New("failed to insert URL key"))
 							})
 
@@ -126,14 +193,14 @@ var _ = Describe(shortenURL, func() {
 							})
 
 							It("return error message", func() {
-								Expect(writer.Body.String()).To(Equal("Internal Server Error"))
+								Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
 							})
 						})
 
 						Context("and inserting the URL key succeeds", func() {
 							BeforeEach(func() {
 								mockURLDatabase.EXPECT().Put(
-									[]byte(customUrlKey), []byte(exampleUrl), nil,
+									[]byte(customUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
 								).Return(nil)
 							})
 
@@ -152,11 +219,32 @@ var _ = Describe(shortenURL, func() {
 						})
 					})
 
-					Context("and the URL key is present in database", func() {
+					Context("and the URL key is present in database with a different URL", func() {
+						const existingUrl = "https://duckduckgo.com/"
+
 						BeforeEach(func() {
 							mockURLDatabase.EXPECT().Get(
-								[]byte(customUrlKey), nil,
-							).Return([]byte(exampleUrl), nil)
+								[]byte(customUrlKey),
+							).Return(encodedLinkRecord(existingUrl), nil)
+						})
+
+						It("returns a 409", func() {
+							Expect(writer.Code).To(Equal(http.StatusConflict))
+						})
+
+						It("returns the existing URL", func() {
+							var responseContent map[string]string
+							Expect(json.Unmarshal(writer.Body.Bytes(), &responseContent)).To(Succeed())
+							Expect(responseContent).To(HaveKeyWithValue("code", ErrorCodeKeyInUse))
+							Expect(responseContent).To(HaveKeyWithValue("existing_url", existingUrl))
+						})
+					})
+
+					Context("and the URL key is present in database with the same URL", func() {
+						BeforeEach(func() {
+							mockURLDatabase.EXPECT().Get(
+								[]byte(customUrlKey),
+							).Return(encodedLinkRecord(exampleUrl), nil)
 						})
 
 						It("returns a 200", func() {
@@ -175,11 +263,135 @@ var _ = Describe(shortenURL, func() {
 				})
 			})
 
+			Context("and an unsupported redirect type is specified", func() {
+				BeforeEach(func() {
+					requestContent["redirect_type"] = http.StatusSeeOther
+				})
+
+				It("returns a 400", func() {
+					Expect(writer.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+
+			Context("and an expiry is specified", func() {
+				expiresAt := time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+				BeforeEach(func() {
+					requestContent["key"] = customUrlKey
+				})
+
+				Context("and it is in the future", func() {
+					BeforeEach(func() {
+						requestContent["expires_at"] = expiresAt.Format(time.RFC3339)
+						mockURLDatabase.EXPECT().Get(
+							[]byte(customUrlKey),
+						).Return(nil, storage.ErrNotFound)
+						mockURLDatabase.EXPECT().Put(
+							[]byte(customUrlKey), matchLinkRecord(exampleUrl, expiresAt),
+						).Return(nil)
+					})
+
+					It("returns a 200", func() {
+						Expect(writer.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("and it is in the past", func() {
+					BeforeEach(func() {
+						requestContent["expires_at"] = "2000-01-01T00:00:00Z"
+					})
+
+					It("returns a 400", func() {
+						Expect(writer.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+
+				Context("and a TTL is specified as well", func() {
+					BeforeEach(func() {
+						requestContent["expires_at"] = expiresAt.Format(time.RFC3339)
+						requestContent["ttl_seconds"] = 60
+					})
+
+					It("returns a 400", func() {
+						Expect(writer.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+			})
+
 			Context("and a custom URL key is not specified", func() {
+				Context("and the URL key is present in database with a different URL", func() {
+					BeforeEach(func() {
+						mockURLDatabase.EXPECT().Get(
+							[]byte(computedUrlKey),
+						).Return(encodedLinkRecord("https://duckduckgo.com/"), nil)
+					})
+
+					Context("and the extended URL key is not present in database", func() {
+						BeforeEach(func() {
+							mockURLDatabase.EXPECT().Get(
+								[]byte(extendedUrlKey),
+							).Return(nil, storage.ErrNotFound)
+							mockURLDatabase.EXPECT().Put(
+								[]byte(extendedUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
+							).Return(nil)
+						})
+
+						It("returns a 200", func() {
+							Expect(writer.Code).To(Equal(http.StatusOK))
+						})
+
+						It("returns a shortened URL with the extended key", func() {
+							expectedResponseContent := map[string]string{
+								"shortened_url": fmt.Sprintf("https://bajo/%s", extendedUrlKey),
+							}
+							expectedJson, _ := json.Marshal(expectedResponseContent)
+
+							Expect(writer.Body.String()).To(Equal(string(expectedJson)))
+						})
+					})
+
+					Context("and the extended URL key is present in database with the same URL", func() {
+						BeforeEach(func() {
+							mockURLDatabase.EXPECT().Get(
+								[]byte(extendedUrlKey),
+							).Return(encodedLinkRecord(exampleUrl), nil)
+						})
+
+						It("returns a 200", func() {
+							Expect(writer.Code).To(Equal(http.StatusOK))
+						})
+
+						It("returns a shortened URL with the extended key", func() {
+							expectedResponseContent := map[string]string{
+								"shortened_url": fmt.Sprintf("https://bajo/%s", extendedUrlKey),
+							}
+							expectedJson, _ := json.Marshal(expectedResponseContent)
+
+							Expect(writer.Body.String()).To(Equal(string(expectedJson)))
+						})
+					})
+
+					Context("and every extended URL key is present in database with a different URL", func() {
+						BeforeEach(func() {
+							mockURLDatabase.EXPECT().Get(
+								gomock.Any(),
+							).Return(encodedLinkRecord("https://duckduckgo.com/"), nil).AnyTimes()
+						})
+
+						It("returns a 500", func() {
+							Expect(writer.Code).To(Equal(http.StatusInternalServerError))
+						})
+
+						It("return error message", func() {
+							Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
+						})
+					})
+				})
+
 				Context("and there is an error checking for an existing URL key", func() {
 					BeforeEach(func() {
 						mockURLDatabase.EXPECT().Get(
-							[]byte(computedUrlKey), nil,
+							[]byte(computedUrlKey),
 						).Return(nil, errors.New("failed to query database"))
 					})
 
@@ -188,21 +400,21 @@ var _ = Describe(shortenURL, func() {
 					})
 
 					It("return error message", func() {
-						Expect(writer.Body.String()).To(Equal("Internal Server Error"))
+						Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
 					})
 				})
 
 				Context("and the URL key is not present in database", func() {
 					BeforeEach(func() {
 						mockURLDatabase.EXPECT().Get(
-							[]byte(computedUrlKey), nil,
-						).Return(nil, dberror.ErrNotFound)
+							[]byte(computedUrlKey),
+						).Return(nil, storage.ErrNotFound)
 					})
 
 					Context("and inserting the URL key fails", func() {
 						BeforeEach(func() {
 							mockURLDatabase.EXPECT().Put(
-								[]byte(computedUrlKey), []byte(exampleUrl), nil,
+								[]byte(computedUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
 							).Return(errors.New("failed to insert URL key"))
 						})
 
@@ -211,14 +423,14 @@ var _ = Describe(shortenURL, func() {
 						})
 
 						It("return error message", func() {
-							Expect(writer.Body.String()).To(Equal("Internal Server Error"))
+							Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
 						})
 					})
 
 					Context("and inserting the URL key succeeds", func() {
 						BeforeEach(func() {
 							mockURLDatabase.EXPECT().Put(
-								[]byte(computedUrlKey), []byte(exampleUrl), nil,
+								[]byte(computedUrlKey), matchLinkRecord(exampleUrl, time.Time{}),
 							).Return(nil)
 						})
 
@@ -240,8 +452,8 @@ var _ = Describe(shortenURL, func() {
 				Context("and the URL key is present in database", func() {
 					BeforeEach(func() {
 						mockURLDatabase.EXPECT().Get(
-							[]byte(computedUrlKey), nil,
-						).Return([]byte(exampleUrl), nil)
+							[]byte(computedUrlKey),
+						).Return(encodedLinkRecord(exampleUrl), nil)
 					})
 
 					It("returns a 200", func() {
@@ -261,3 +473,163 @@ var _ = Describe(shortenURL, func() {
 		})
 	})
 })
+
+var _ = Describe("storeLinkRecord", func() {
+	const urlKey = "custom"
+
+	var urlDatabase *storage.Memory
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+	})
+
+	AfterEach(func() {
+		urlDatabase.Close()
+	})
+
+	When("several URLs are stored concurrently under the same key", func() {
+		It("stores exactly one of them", func() {
+			const requestCount = 16
+
+			var waitGroup sync.WaitGroup
+			storedURLs := make([]string, requestCount)
+
+			for i := 0; i < requestCount; i++ {
+				waitGroup.Add(1)
+				go func(i int) {
+					defer waitGroup.Done()
+					defer GinkgoRecover()
+
+					url := fmt.Sprintf("https://example.com/%d", i)
+					record := NewLinkRecord(url, time.Now(), time.Time{})
+					storedRecord, err := storeLinkRecord(urlDatabase, []byte(urlKey), record)
+					Expect(err).NotTo(HaveOccurred())
+					storedURLs[i] = storedRecord.URL
+				}(i)
+			}
+			waitGroup.Wait()
+
+			winner, _, err := readLinkRecord(urlDatabase, []byte(urlKey))
+			Expect(err).NotTo(HaveOccurred())
+			for _, storedURL := range storedURLs {
+				Expect(storedURL).To(Equal(winner.URL))
+			}
+		})
+	})
+})
+
+var _ = Describe("/shorten content negotiation", func() {
+	const (
+		exampleUrl     = "https://en.wikipedia.org/wiki/URL_shortening"
+		computedUrlKey = "oROh-p8o"
+	)
+
+	var urlDatabase *storage.Memory
+	var router *gin.Engine
+	var writer *httptest.ResponseRecorder
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		router, _ = initializeRouter(urlDatabase, DefaultConfig())
+		writer = httptest.NewRecorder()
+	})
+
+	expectShortened := func(key string) {
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Body.String()).To(Equal(fmt.Sprintf(`{"shortened_url":"%s/%s"}`, DefaultURLPrefix, key)))
+	}
+
+	It("accepts URL-encoded forms", func() {
+		form := url.Values{"url": {exampleUrl}, "key": {"wiki"}, "ttl_seconds": {"3600"}, "tags": {"docs", "wiki"}}
+		request, _ := http.NewRequest("POST", shortenURL, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(writer, request)
+
+		expectShortened("wiki")
+
+		record, _, err := readLinkRecord(urlDatabase, []byte("wiki"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.Tags).To(Equal([]string{"docs", "wiki"}))
+		Expect(record.ExpiresAt).NotTo(BeNil())
+	})
+
+	It("reuses a generated key only for the same link", func() {
+		shorten := func(body string) string {
+			writer = httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", shortenURL, strings.NewReader(body))
+			request.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(writer, request)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			return writer.Body.String()
+		}
+
+		expiring := shorten(fmt.Sprintf(`{"url": "%s", "ttl_seconds": 60}`, exampleUrl))
+		permanent := shorten(fmt.Sprintf(`{"url": "%s"}`, exampleUrl))
+		Expect(permanent).NotTo(Equal(expiring))
+		Expect(shorten(fmt.Sprintf(`{"url": "%s"}`, exampleUrl))).To(Equal(permanent))
+		Expect(shorten(fmt.Sprintf(`{"url": "%s", "redirect_type": 301}`, exampleUrl))).NotTo(Equal(permanent))
+
+		var response map[string]string
+		Expect(json.Unmarshal([]byte(permanent), &response)).To(Succeed())
+		record, _, err := readLinkRecord(urlDatabase, []byte(strings.TrimPrefix(response["shortened_url"], DefaultURLPrefix+"/")))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.ExpiresAt).To(BeNil())
+	})
+
+	It("accepts JSON sent as a URL-encoded form", func() {
+		request, _ := http.NewRequest("POST", shortenURL, strings.NewReader(fmt.Sprintf(`{"url": "%s"}`, exampleUrl)))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(writer, request)
+
+		expectShortened(computedUrlKey)
+	})
+
+	It("accepts multipart forms", func() {
+		body := &bytes.Buffer{}
+		multipartWriter := multipart.NewWriter(body)
+		Expect(multipartWriter.WriteField("url", exampleUrl)).To(Succeed())
+		Expect(multipartWriter.Close()).To(Succeed())
+
+		request, _ := http.NewRequest("POST", shortenURL, body)
+		request.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+		router.ServeHTTP(writer, request)
+
+		expectShortened(computedUrlKey)
+	})
+
+	It("accepts query strings of GET requests", func() {
+		request, _ := http.NewRequest("GET", shortenURL+"?url="+url.QueryEscape(exampleUrl), nil)
+		router.ServeHTTP(writer, request)
+
+		expectShortened(computedUrlKey)
+	})
+
+	It("rejects GET requests without a URL", func() {
+		request, _ := http.NewRequest("GET", shortenURL, nil)
+		router.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("responds in plain text when preferred by the client", func() {
+		request, _ := http.NewRequest("GET", shortenURL+"?url="+url.QueryEscape(exampleUrl), nil)
+		request.Header.Set("Accept", "text/plain")
+		router.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(writer.Body.String()).To(Equal(fmt.Sprintf("%s/%s", DefaultURLPrefix, computedUrlKey)))
+	})
+
+	It("reports invalid keys in plain text when preferred by the client", func() {
+		request, _ := http.NewRequest("GET", shortenURL+"?key=shorten&url="+url.QueryEscape(exampleUrl), nil)
+		request.Header.Set("Accept", "text/plain")
+		router.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		Expect(writer.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(writer.Body.String()).To(ContainSubstring("reserved"))
+	})
+})
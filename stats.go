@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// ClickKeyPrefix defines the prefix of the keyspace in which click events are stored.
+	// As keys containing a slash can never be reached by the redirect route, click
+	// events cannot collide with the events of another URL key.
+	ClickKeyPrefix = "clicks/"
+
+	// TopReferrersLimit determines the maximum number of referrers reported in statistics.
+	TopReferrersLimit = 10
+
+	// dailyClicksLayout defines the format of the days used to group click events.
+	dailyClicksLayout = "2006-01-02"
+)
+
+// clickSequence disambiguates click events recorded within the same nanosecond.
+var clickSequence uint64
+
+// ClickEvent represents a single visit of a shortened URL.
+type ClickEvent struct {
+	Key       string    `json:"key"`
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// ReferrerCount represents the number of clicks originating from a referrer.
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+// ClickStatistics summarizes the click events recorded for a URL key.
+type ClickStatistics struct {
+	Key          string          `json:"key"`
+	TotalClicks  int             `json:"total_clicks"`
+	DailyClicks  map[string]int  `json:"daily_clicks"`
+	TopReferrers []ReferrerCount `json:"top_referrers"`
+}
+
+// clickKeyPrefix returns the prefix of the database keys holding the click events of a URL key.
+func clickKeyPrefix(URLKey string) []byte {
+	return []byte(fmt.Sprintf("%s%s/", ClickKeyPrefix, URLKey))
+}
+
+// RecordClick stores a click event in the click keyspace of the URL database.
+func RecordClick(urlDatabase URLDatabase, event ClickEvent) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Event keys are ordered chronologically within the keyspace of a URL key.
+	sequence := atomic.AddUint64(&clickSequence, 1)
+	eventKey := fmt.Sprintf(
+		"%s%s/%020d-%020d", ClickKeyPrefix, event.Key, event.Timestamp.UnixNano(), sequence,
+	)
+
+	return urlDatabase.Put([]byte(eventKey), eventBytes)
+}
+
+// forEachClick calls fn with the database key and value of every click event of a URL key.
+func forEachClick(urlDatabase URLDatabase, URLKey string, fn func(clickKey, clickValue []byte)) error {
+	iter := urlDatabase.NewIterator(clickKeyPrefix(URLKey))
+	defer iter.Release()
+
+	for iter.Next() {
+		fn(append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...))
+	}
+	return iter.Error()
+}
+
+// GetClickStatistics aggregates the click events recorded for a URL key.
+func GetClickStatistics(urlDatabase URLDatabase, URLKey string) (*ClickStatistics, error) {
+	statistics := &ClickStatistics{
+		Key:          URLKey,
+		DailyClicks:  map[string]int{},
+		TopReferrers: []ReferrerCount{},
+	}
+	referrerClicks := map[string]int{}
+
+	iter := urlDatabase.NewIterator(clickKeyPrefix(URLKey))
+	defer iter.Release()
+
+	for iter.Next() {
+		var event ClickEvent
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			return nil, err
+		}
+
+		statistics.TotalClicks++
+		statistics.DailyClicks[event.Timestamp.UTC().Format(dailyClicksLayout)]++
+		if event.Referrer != "" {
+			referrerClicks[event.Referrer]++
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	for referrer, clicks := range referrerClicks {
+		statistics.TopReferrers = append(statistics.TopReferrers, ReferrerCount{
+			Referrer: referrer,
+			Clicks:   clicks,
+		})
+	}
+
+	// Referrers are ranked by clicks, with ties broken alphabetically for stable output.
+	sort.Slice(statistics.TopReferrers, func(i, j int) bool {
+		a, b := statistics.TopReferrers[i], statistics.TopReferrers[j]
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Referrer < b.Referrer
+	})
+
+	if len(statistics.TopReferrers) > TopReferrersLimit {
+		statistics.TopReferrers = statistics.TopReferrers[:TopReferrersLimit]
+	}
+
+	return statistics, nil
+}
+
+// StatsController contains logic and data related to the /:key/stats route.
+type StatsController struct {
+	URLDatabase URLDatabase
+	KeyPolicy   *KeyPolicy
+}
+
+// Stats implements the logic for the /:key/stats route.
+func (c *StatsController) Stats(context *gin.Context) {
+	// Clicks are recorded under the key the link is stored with, as found by the redirect route.
+	URLKey, _, err := FindLinkRecord(c.URLDatabase, c.KeyPolicy, context.Param("key"))
+	if err == storage.ErrNotFound {
+		respondWithError(context, errNotFound())
+		return
+	}
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	statistics, err := GetClickStatistics(c.URLDatabase, URLKey)
+	if err != nil {
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	context.JSON(http.StatusOK, statistics)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	mocks "github.com/upsideon/bajo/mocks"
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Click statistics", func() {
+	const (
+		exampleUrl = "https://en.wikipedia.org/wiki/URL_shortening"
+		urlKey     = "oROh-p8o"
+	)
+
+	Describe("GetClickStatistics", func() {
+		var urlDatabase *storage.Memory
+
+		BeforeEach(func() {
+			urlDatabase = storage.NewMemory()
+			Expect(urlDatabase.Put([]byte(urlKey), []byte(exampleUrl))).To(Succeed())
+		})
+
+		AfterEach(func() {
+			urlDatabase.Close()
+		})
+
+		Context("and no clicks have been recorded", func() {
+			It("returns empty statistics", func() {
+				statistics, err := GetClickStatistics(urlDatabase, urlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+				Expect(statistics.DailyClicks).To(BeEmpty())
+				Expect(statistics.TopReferrers).To(BeEmpty())
+			})
+		})
+
+		Context("and clicks have been recorded", func() {
+			BeforeEach(func() {
+				firstDay := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+				secondDay := firstDay.Add(24 * time.Hour)
+
+				clicks := []ClickEvent{
+					{Key: urlKey, Timestamp: firstDay, Referrer: "https://duckduckgo.com/"},
+					{Key: urlKey, Timestamp: firstDay, Referrer: "https://duckduckgo.com/"},
+					{Key: urlKey, Timestamp: firstDay, Referrer: "https://news.ycombinator.com/"},
+					{Key: urlKey, Timestamp: secondDay},
+					{Key: urlKey + "-other", Timestamp: secondDay, Referrer: "https://example.com/"},
+				}
+				for _, click := range clicks {
+					Expect(RecordClick(urlDatabase, click)).To(Succeed())
+				}
+			})
+
+			It("counts the clicks of the URL key only", func() {
+				statistics, err := GetClickStatistics(urlDatabase, urlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(4))
+			})
+
+			It("counts the clicks per day", func() {
+				statistics, err := GetClickStatistics(urlDatabase, urlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.DailyClicks).To(Equal(map[string]int{
+					"2022-06-01": 3,
+					"2022-06-02": 1,
+				}))
+			})
+
+			It("ranks the referrers by clicks", func() {
+				statistics, err := GetClickStatistics(urlDatabase, urlKey)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TopReferrers).To(Equal([]ReferrerCount{
+					{Referrer: "https://duckduckgo.com/", Clicks: 2},
+					{Referrer: "https://news.ycombinator.com/", Clicks: 1},
+				}))
+			})
+		})
+	})
+
+	Describe("/:key/stats", func() {
+		var router *gin.Engine
+		var mockURLDatabase *mocks.MockURLDatabase
+		var writer *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			ctrl := gomock.NewController(GinkgoT())
+			mockURLDatabase = mocks.NewMockURLDatabase(ctrl)
+			router, _ = initializeRouter(mockURLDatabase, DefaultConfig())
+			writer = httptest.NewRecorder()
+		})
+
+		JustBeforeEach(func() {
+			request, _ := http.NewRequest("GET", fmt.Sprintf("/%s/stats", urlKey), nil)
+			router.ServeHTTP(writer, request)
+		})
+
+		Context("and there is an error checking for the URL key", func() {
+			BeforeEach(func() {
+				mockURLDatabase.EXPECT().Get(
+					[]byte(urlKey),
+				).Return(nil, errors.New("failed to query database"))
+			})
+
+			It("returns a 500", func() {
+				Expect(writer.Code).To(Equal(http.StatusInternalServerError))
+			})
+
+			It("return error message", func() {
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeInternal))
+			})
+		})
+
+		Context("and the URL key is not present in database", func() {
+			BeforeEach(func() {
+				mockURLDatabase.EXPECT().Get(
+					[]byte(urlKey),
+				).Return(nil, storage.ErrNotFound)
+			})
+
+			It("returns a 404", func() {
+				Expect(writer.Code).To(Equal(http.StatusNotFound))
+			})
+
+			It("return error message", func() {
+				Expect(errorCode(writer.Body.Bytes())).To(Equal(ErrorCodeNotFound))
+			})
+		})
+
+		Context("and the URL key is present in database", func() {
+			BeforeEach(func() {
+				memoryDatabase := storage.NewMemory()
+				DeferCleanup(memoryDatabase.Close)
+
+				click := ClickEvent{
+					Key:       urlKey,
+					Timestamp: time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC),
+					Referrer:  "https://duckduckgo.com/",
+				}
+				Expect(RecordClick(memoryDatabase, click)).To(Succeed())
+
+				mockURLDatabase.EXPECT().Get(
+					[]byte(urlKey),
+				).Return(NewLinkRecord(exampleUrl, time.Now(), time.Time{}).Encode())
+				mockURLDatabase.EXPECT().NewIterator(
+					gomock.Any(),
+				).DoAndReturn(memoryDatabase.NewIterator)
+			})
+
+			It("returns a 200", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+			})
+
+			It("returns the click statistics", func() {
+				expectedResponseContent := ClickStatistics{
+					Key:          urlKey,
+					TotalClicks:  1,
+					DailyClicks:  map[string]int{"2022-06-01": 1},
+					TopReferrers: []ReferrerCount{{Referrer: "https://duckduckgo.com/", Clicks: 1}},
+				}
+				expectedJson, _ := json.Marshal(expectedResponseContent)
+
+				Expect(writer.Body.String()).To(Equal(string(expectedJson)))
+			})
+		})
+
+		Context("and keys are case insensitive", func() {
+			var memoryDatabase *storage.Memory
+
+			BeforeEach(func() {
+				memoryDatabase = storage.NewMemory()
+				DeferCleanup(memoryDatabase.Close)
+
+				config := DefaultConfig()
+				config.CaseInsensitiveKeys = true
+				router, _ = initializeRouter(memoryDatabase, config)
+			})
+
+			JustBeforeEach(func() {
+				Expect(writeLinkRecord(memoryDatabase, []byte("docs"), NewLinkRecord(exampleUrl, time.Now(), time.Time{}))).To(Succeed())
+
+				writer = httptest.NewRecorder()
+				request, _ := http.NewRequest("GET", "/Docs", nil)
+				router.ServeHTTP(writer, request)
+				Expect(writer.Code).To(Equal(http.StatusFound))
+
+				writer = httptest.NewRecorder()
+				request, _ = http.NewRequest("GET", "/Docs/stats", nil)
+				router.ServeHTTP(writer, request)
+			})
+
+			It("reports the clicks of the key in any case", func() {
+				Expect(writer.Code).To(Equal(http.StatusOK))
+
+				statistics := ClickStatistics{}
+				Expect(json.Unmarshal(writer.Body.Bytes(), &statistics)).To(Succeed())
+				Expect(statistics.Key).To(Equal("docs"))
+				Expect(statistics.TotalClicks).To(Equal(1))
+			})
+		})
+	})
+})
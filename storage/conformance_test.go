@@ -0,0 +1,278 @@
+package storage_test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+// iteratedKeys returns the keys of an iterator, releasing it.
+func iteratedKeys(iter storage.Iterator) []string {
+	defer iter.Release()
+
+	keys := []string{}
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	Expect(iter.Error()).NotTo(HaveOccurred())
+	return keys
+}
+
+// describeConformance describes the behavior shared by every database, each spec running
+// against a new database.
+func describeConformance(name string, open func() storage.Database) {
+	Describe(name, func() {
+		var database storage.Database
+
+		BeforeEach(func() {
+			database = open()
+			DeferCleanup(func() { database.Close() })
+		})
+
+		It("should report a missing key", func() {
+			_, err := database.Get([]byte("missing"))
+			Expect(err).To(MatchError(storage.ErrNotFound))
+
+			present, err := database.Has([]byte("missing"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(present).To(BeFalse())
+		})
+
+		It("should store, replace and delete values", func() {
+			Expect(database.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+			Expect(database.Put([]byte("docs"), []byte("https://example.org/"))).To(Succeed())
+
+			value, err := database.Get([]byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(value)).To(Equal("https://example.org/"))
+
+			present, err := database.Has([]byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(present).To(BeTrue())
+
+			Expect(database.Delete([]byte("docs"))).To(Succeed())
+			_, err = database.Get([]byte("docs"))
+			Expect(err).To(MatchError(storage.ErrNotFound))
+		})
+
+		It("should not report deleting a missing key", func() {
+			Expect(database.Delete([]byte("missing"))).To(Succeed())
+		})
+
+		It("should not share values with callers", func() {
+			value := []byte("https://example.com/")
+			Expect(database.Put([]byte("docs"), value)).To(Succeed())
+			value[0] = 'X'
+
+			storedValue, err := database.Get([]byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			storedValue[1] = 'X'
+
+			storedValue, err = database.Get([]byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(storedValue)).To(Equal("https://example.com/"))
+		})
+
+		It("should apply batches in order", func() {
+			Expect(database.Put([]byte("deleted"), []byte("value"))).To(Succeed())
+
+			batch := new(storage.Batch)
+			batch.Put([]byte("docs"), []byte("https://example.com/"))
+			batch.Delete([]byte("deleted"))
+			batch.Put([]byte("replaced"), []byte("first"))
+			batch.Put([]byte("replaced"), []byte("second"))
+			batch.Put([]byte("readded"), []byte("value"))
+			batch.Delete([]byte("readded"))
+			batch.Put([]byte("readded"), []byte("again"))
+			Expect(batch.Len()).To(Equal(7))
+			Expect(database.Write(batch)).To(Succeed())
+
+			Expect(database.Has([]byte("deleted"))).To(BeFalse())
+			Expect(database.Get([]byte("docs"))).To(Equal([]byte("https://example.com/")))
+			Expect(database.Get([]byte("replaced"))).To(Equal([]byte("second")))
+			Expect(database.Get([]byte("readded"))).To(Equal([]byte("again")))
+		})
+
+		It("should apply an empty batch", func() {
+			Expect(database.Write(new(storage.Batch))).To(Succeed())
+		})
+
+		It("should keep its pairs when compacted", func() {
+			Expect(database.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+			Expect(database.Put([]byte("deleted"), []byte("value"))).To(Succeed())
+			Expect(database.Delete([]byte("deleted"))).To(Succeed())
+
+			compacter, ok := database.(storage.Compacter)
+			Expect(ok).To(BeTrue())
+			Expect(compacter.Compact()).To(Succeed())
+
+			Expect(iteratedKeys(database.NewIterator(nil))).To(Equal([]string{"docs"}))
+		})
+
+		Context("when iterating", func() {
+			BeforeEach(func() {
+				for _, key := range []string{"b", "clicks/b/2", "a", "clicks/a/1", "clicks/b/1", "clicks0", "c"} {
+					Expect(database.Put([]byte(key), []byte("value of "+key))).To(Succeed())
+				}
+			})
+
+			It("should iterate over every key in order", func() {
+				Expect(iteratedKeys(database.NewIterator(nil))).To(Equal([]string{
+					"a", "b", "c", "clicks/a/1", "clicks/b/1", "clicks/b/2", "clicks0",
+				}))
+			})
+
+			It("should iterate over the keys starting with a prefix", func() {
+				Expect(iteratedKeys(database.NewIterator([]byte("clicks/b/")))).To(Equal([]string{
+					"clicks/b/1", "clicks/b/2",
+				}))
+				Expect(iteratedKeys(database.NewIterator([]byte("missing/")))).To(BeEmpty())
+			})
+
+			It("should return the values of the keys", func() {
+				iter := database.NewIterator([]byte("clicks/a/"))
+				defer iter.Release()
+
+				Expect(iter.Next()).To(BeTrue())
+				Expect(string(iter.Value())).To(Equal("value of clicks/a/1"))
+				Expect(iter.Next()).To(BeFalse())
+				Expect(iter.Key()).To(BeNil())
+			})
+
+			It("should seek within the prefix", func() {
+				iter := database.NewIterator([]byte("clicks/"))
+				defer iter.Release()
+
+				Expect(iter.Seek([]byte("clicks/b/"))).To(BeTrue())
+				Expect(string(iter.Key())).To(Equal("clicks/b/1"))
+				Expect(iter.Next()).To(BeTrue())
+				Expect(string(iter.Key())).To(Equal("clicks/b/2"))
+				Expect(iter.Next()).To(BeFalse())
+
+				Expect(iter.Seek([]byte("a"))).To(BeTrue())
+				Expect(string(iter.Key())).To(Equal("clicks/a/1"))
+
+				Expect(iter.Seek([]byte("clicks0"))).To(BeFalse())
+			})
+
+			It("should iterate over more keys than are fetched at once", func() {
+				batch := new(storage.Batch)
+				for index := 0; index < 1000; index++ {
+					batch.Put([]byte(fmt.Sprintf("many/%04d", index)), []byte("value"))
+				}
+				Expect(database.Write(batch)).To(Succeed())
+
+				keys := iteratedKeys(database.NewIterator([]byte("many/")))
+				Expect(keys).To(HaveLen(1000))
+				Expect(keys[0]).To(Equal("many/0000"))
+				Expect(keys[999]).To(Equal("many/0999"))
+			})
+			It("should iterate over a snapshot taken when created", func() {
+				batch := new(storage.Batch)
+				for index := 0; index < 1000; index++ {
+					batch.Put([]byte(fmt.Sprintf("many/%04d", index)), []byte("value"))
+				}
+				Expect(database.Write(batch)).To(Succeed())
+
+				iter := database.NewIterator([]byte("many/"))
+				Expect(database.Put([]byte("many/0000a"), []byte("value"))).To(Succeed())
+				Expect(database.Delete([]byte("many/0999"))).To(Succeed())
+
+				keys := iteratedKeys(iter)
+				Expect(keys).To(HaveLen(1000))
+				Expect(keys[1]).To(Equal("many/0001"))
+				Expect(keys[999]).To(Equal("many/0999"))
+			})
+		})
+
+		Context("once closed", func() {
+			BeforeEach(func() {
+				Expect(database.Close()).To(Succeed())
+			})
+
+			It("should report being closed", func() {
+				_, err := database.Get([]byte("docs"))
+				Expect(err).To(MatchError(storage.ErrClosed))
+				Expect(database.Put([]byte("docs"), []byte("value"))).To(MatchError(storage.ErrClosed))
+				Expect(database.Write(new(storage.Batch))).To(MatchError(storage.ErrClosed))
+
+				iter := database.NewIterator(nil)
+				Expect(iter.Next()).To(BeFalse())
+				Expect(iter.Error()).To(MatchError(storage.ErrClosed))
+				iter.Release()
+			})
+		})
+	})
+}
+
+var _ = Describe("Database", func() {
+	describeConformance("LevelDB", func() storage.Database {
+		database, err := storage.OpenLevelDB(GinkgoT().TempDir(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		return database
+	})
+
+	describeConformance("Memory", func() storage.Database {
+		return storage.NewMemory()
+	})
+
+	describeConformance("SQLite", func() storage.Database {
+		database, err := storage.OpenSQLite(filepath.Join(GinkgoT().TempDir(), "bajo.db"))
+		Expect(err).NotTo(HaveOccurred())
+		return database
+	})
+})
+
+var _ = Describe("OpenLevelDB", func() {
+	It("should report a database opened by another process", func() {
+		path := GinkgoT().TempDir()
+		database, err := storage.OpenLevelDB(path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer database.Close()
+
+		_, err = storage.OpenLevelDB(path, nil)
+		Expect(err).To(MatchError(storage.ErrLocked))
+	})
+})
+
+var _ = Describe("OpenSQLite", func() {
+	It("should report a database opened by another process", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "bajo.db")
+		database, err := storage.OpenSQLite(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = storage.OpenSQLite(path)
+		Expect(err).To(MatchError(storage.ErrLocked))
+
+		Expect(database.Close()).To(Succeed())
+		database, err = storage.OpenSQLite(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(database.Close()).To(Succeed())
+	})
+
+	It("should keep values across reopening", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "bajo.db")
+		database, err := storage.OpenSQLite(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(database.Put([]byte("docs"), []byte("https://example.com/"))).To(Succeed())
+		Expect(database.Close()).To(Succeed())
+
+		database, err = storage.OpenSQLite(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer database.Close()
+		Expect(database.Get([]byte("docs"))).To(Equal([]byte("https://example.com/")))
+	})
+})
+
+var _ = Describe("PrefixLimit", func() {
+	It("should return the smallest key following the prefix", func() {
+		Expect(storage.PrefixLimit([]byte("clicks/"))).To(Equal([]byte("clicks0")))
+		Expect(storage.PrefixLimit([]byte{'a', 0xff})).To(Equal([]byte("b")))
+		Expect(storage.PrefixLimit([]byte{0xff, 0xff})).To(BeNil())
+		Expect(storage.PrefixLimit(nil)).To(BeNil())
+	})
+})
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	dberror "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDB is a Database stored in a LevelDB database.
+type LevelDB struct {
+	DB *leveldb.DB
+}
+
+// OpenLevelDB opens the LevelDB database at a filepath with the given options, creating it
+// when missing unless the options state otherwise.
+func OpenLevelDB(path string, options *opt.Options) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, options)
+	if err != nil {
+		// LevelDB reports a database held by another process with the error of the file lock.
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return &LevelDB{DB: db}, nil
+}
+
+// IsCorrupted determines whether an error reports a corrupted LevelDB database.
+func IsCorrupted(err error) bool {
+	return dberror.IsCorrupted(err)
+}
+
+// levelDBError converts the errors of LevelDB which callers may act upon to those of this package.
+func levelDBError(err error) error {
+	switch err {
+	case leveldb.ErrNotFound:
+		return ErrNotFound
+	case leveldb.ErrReadOnly:
+		return ErrReadOnly
+	case leveldb.ErrClosed:
+		return ErrClosed
+	default:
+		return err
+	}
+}
+
+// Close closes the database.
+func (d *LevelDB) Close() error {
+	return levelDBError(d.DB.Close())
+}
+
+// Compact compacts every level of the database.
+func (d *LevelDB) Compact() error {
+	return levelDBError(d.DB.CompactRange(util.Range{}))
+}
+
+// Delete removes a key.
+func (d *LevelDB) Delete(key []byte) error {
+	return levelDBError(d.DB.Delete(key, nil))
+}
+
+// Get retrieves the value of a key.
+func (d *LevelDB) Get(key []byte) ([]byte, error) {
+	value, err := d.DB.Get(key, nil)
+	return value, levelDBError(err)
+}
+
+// Has determines whether a key is present.
+func (d *LevelDB) Has(key []byte) (bool, error) {
+	ret, err := d.DB.Has(key, nil)
+	return ret, levelDBError(err)
+}
+
+// NewIterator creates an iterator over the keys starting with a prefix, which iterates over
+// a snapshot of the database.
+func (d *LevelDB) NewIterator(prefix []byte) Iterator {
+	var slice *util.Range
+	if prefix != nil {
+		slice = util.BytesPrefix(prefix)
+	}
+	return &levelDBIterator{Iterator: d.DB.NewIterator(slice, nil)}
+}
+
+// Put stores the value of a key.
+func (d *LevelDB) Put(key, value []byte) error {
+	return levelDBError(d.DB.Put(key, value, nil))
+}
+
+// Write applies the operations of a batch atomically.
+func (d *LevelDB) Write(batch *Batch) error {
+	levelDBBatch := new(leveldb.Batch)
+	batch.Replay(levelDBBatch.Put, levelDBBatch.Delete)
+	return levelDBError(d.DB.Write(levelDBBatch, nil))
+}
+
+// levelDBIterator converts the errors of a LevelDB iterator.
+type levelDBIterator struct {
+	Iterator
+}
+
+// Error returns the error which stopped the iteration, if any.
+func (i *levelDBIterator) Error() error {
+	return levelDBError(i.Iterator.Error())
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on the file at a filepath, creating it when missing, and
+// fails with ErrLocked when the lock is already held. The lock is released when the file is closed.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err = windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, new(windows.Overlapped)); err != nil {
+		file.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
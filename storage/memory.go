@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// Memory is a Database held in memory, whose content is lost once closed. It suits tests and
+// deployments which do not need to keep links across restarts.
+type Memory struct {
+	mutex  sync.RWMutex
+	keys   []string
+	values map[string][]byte
+	closed bool
+}
+
+// NewMemory creates an empty in-memory database.
+func NewMemory() *Memory {
+	return &Memory{values: map[string][]byte{}}
+}
+
+// Close closes the database, discarding its content.
+func (d *Memory) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	d.closed = true
+	d.keys = nil
+	d.values = nil
+	return nil
+}
+
+// Compact does nothing, as the space of deleted values is reclaimed as they are deleted.
+func (d *Memory) Compact() error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Delete removes a key.
+func (d *Memory) Delete(key []byte) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	d.delete(string(key))
+	return nil
+}
+
+// Get retrieves the value of a key.
+func (d *Memory) Get(key []byte) ([]byte, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+	value, ok := d.values[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Has determines whether a key is present.
+func (d *Memory) Has(key []byte) (bool, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return false, ErrClosed
+	}
+	_, ok := d.values[string(key)]
+	return ok, nil
+}
+
+// NewIterator creates an iterator over the keys starting with a prefix, which iterates over
+// a snapshot of the database.
+func (d *Memory) NewIterator(prefix []byte) Iterator {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return &memoryIterator{index: -1, err: ErrClosed}
+	}
+
+	start := sort.SearchStrings(d.keys, string(prefix))
+	end := len(d.keys)
+	if limit := PrefixLimit(prefix); limit != nil {
+		end = sort.SearchStrings(d.keys, string(limit))
+	}
+
+	iterator := &memoryIterator{index: -1}
+	for _, key := range d.keys[start:end] {
+		iterator.keys = append(iterator.keys, []byte(key))
+		iterator.values = append(iterator.values, d.values[key])
+	}
+	return iterator
+}
+
+// Put stores the value of a key.
+func (d *Memory) Put(key, value []byte) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	d.put(string(key), append([]byte{}, value...))
+	return nil
+}
+
+// Write applies the operations of a batch atomically.
+func (d *Memory) Write(batch *Batch) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	batch.Replay(func(key, value []byte) {
+		d.put(string(key), append([]byte{}, value...))
+	}, func(key []byte) {
+		d.delete(string(key))
+	})
+	return nil
+}
+
+// put stores the value of a key, keeping the keys sorted. The caller must hold the write lock.
+func (d *Memory) put(key string, value []byte) {
+	if _, ok := d.values[key]; !ok {
+		index := sort.SearchStrings(d.keys, key)
+		d.keys = append(d.keys, "")
+		copy(d.keys[index+1:], d.keys[index:])
+		d.keys[index] = key
+	}
+	d.values[key] = value
+}
+
+// delete removes a key. The caller must hold the write lock.
+func (d *Memory) delete(key string) {
+	if _, ok := d.values[key]; !ok {
+		return
+	}
+	index := sort.SearchStrings(d.keys, key)
+	d.keys = append(d.keys[:index], d.keys[index+1:]...)
+	delete(d.values, key)
+}
+
+// memoryIterator iterates over a snapshot of the pairs of an in-memory database. As values are
+// never modified in place, the snapshot shares them with the database.
+type memoryIterator struct {
+	keys   [][]byte
+	values [][]byte
+	index  int
+	err    error
+}
+
+// Next moves to the next pair.
+func (i *memoryIterator) Next() bool {
+	if i.index < len(i.keys) {
+		i.index++
+	}
+	return i.index < len(i.keys)
+}
+
+// Seek moves to the first pair whose key is greater than or equal to a key.
+func (i *memoryIterator) Seek(key []byte) bool {
+	i.index = sort.Search(len(i.keys), func(index int) bool {
+		return bytes.Compare(i.keys[index], key) >= 0
+	})
+	return i.index < len(i.keys)
+}
+
+// Key returns the key of the current pair.
+func (i *memoryIterator) Key() []byte {
+	if i.index < 0 || i.index >= len(i.keys) {
+		return nil
+	}
+	return i.keys[i.index]
+}
+
+// Value returns the value of the current pair.
+func (i *memoryIterator) Value() []byte {
+	if i.index < 0 || i.index >= len(i.keys) {
+		return nil
+	}
+	return i.values[i.index]
+}
+
+// Release releases the snapshot.
+func (i *memoryIterator) Release() {
+	i.keys = nil
+	i.values = nil
+}
+
+// Error returns the error which stopped the iteration, if any.
+func (i *memoryIterator) Error() error {
+	return i.err
+}
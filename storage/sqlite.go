@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	// The pure-Go SQLite driver is registered as "sqlite".
+	_ "modernc.org/sqlite"
+)
+
+// sqliteIteratorChunk is the number of pairs an iterator over an SQLite database fetches at once.
+const sqliteIteratorChunk = 256
+
+// sqliteSchema creates the table holding the pairs of an SQLite database. SQLite compares blobs
+// bytewise, which orders keys as the other databases do.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS entries (
+	key BLOB PRIMARY KEY,
+	value BLOB NOT NULL
+) WITHOUT ROWID`
+
+// SQLite is a Database stored in a single table of an SQLite database.
+type SQLite struct {
+	db     *sql.DB
+	lock   *os.File
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// OpenSQLite opens the SQLite database at a filepath, creating it when missing. The database is
+// written ahead of log, so that reads do not block on writes, and waits for locks held by other
+// connections rather than failing at once.
+//
+// As with LevelDB, a database is opened by a single process at a time, which holds an exclusive
+// lock on the file named after it with a -lock suffix: callers serialize the check and claim of
+// keys within their process, which would not exclude the writes of another process.
+func OpenSQLite(path string) (*SQLite, error) {
+	lock, err := lockFile(path + "-lock")
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?%s", path, query.Encode()))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+	return &SQLite{db: db, lock: lock}, nil
+}
+
+// sqliteError converts the errors of SQLite which callers may act upon to those of this package.
+func sqliteError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Close closes the database.
+func (d *SQLite) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	d.closed = true
+	err := d.db.Close()
+	if lockErr := d.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// Compact rebuilds the database file, then truncates its write-ahead log.
+func (d *SQLite) Compact() error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	if _, err := d.db.Exec("VACUUM"); err != nil {
+		return err
+	}
+	_, err := d.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// Delete removes a key.
+func (d *SQLite) Delete(key []byte) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	_, err := d.db.Exec("DELETE FROM entries WHERE key = ?", key)
+	return err
+}
+
+// Get retrieves the value of a key.
+func (d *SQLite) Get(key []byte) ([]byte, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+	value := []byte{}
+	if err := d.db.QueryRow("SELECT value FROM entries WHERE key = ?", key).Scan(&value); err != nil {
+		return nil, sqliteError(err)
+	}
+	return value, nil
+}
+
+// Has determines whether a key is present.
+func (d *SQLite) Has(key []byte) (bool, error) {
+	_, err := d.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NewIterator creates an iterator over the keys starting with a prefix, which iterates over
+// a snapshot of the database. Pairs are fetched in chunks within a read transaction, held on a
+// connection of its own until the iterator is released, which does not block writers.
+func (d *SQLite) NewIterator(prefix []byte) Iterator {
+	iterator := &sqliteIterator{
+		database:  d,
+		start:     append([]byte{}, prefix...),
+		limit:     PrefixLimit(prefix),
+		from:      append([]byte{}, prefix...),
+		inclusive: true,
+		index:     -1,
+	}
+	iterator.begin()
+	return iterator
+}
+
+// Put stores the value of a key.
+func (d *SQLite) Put(key, value []byte) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	_, err := d.db.Exec(sqlitePut, key, append([]byte{}, value...))
+	return err
+}
+
+// sqlitePut stores the value of a key, replacing any previous value.
+const sqlitePut = "INSERT INTO entries (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value"
+
+// Write applies the operations of a batch in a transaction.
+func (d *SQLite) Write(batch *Batch) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	batch.Replay(func(key, value []byte) {
+		if err == nil {
+			_, err = tx.Exec(sqlitePut, key, append([]byte{}, value...))
+		}
+	}, func(key []byte) {
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM entries WHERE key = ?", key)
+		}
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteIterator iterates over the pairs of an SQLite database whose keys are within
+// [start, limit), fetching them in chunks of keys following from.
+type sqliteIterator struct {
+	database  *SQLite
+	conn      *sql.Conn
+	start     []byte
+	limit     []byte
+	from      []byte
+	inclusive bool
+	exhausted bool
+	keys      [][]byte
+	values    [][]byte
+	index     int
+	err       error
+}
+
+// Next moves to the next pair.
+func (i *sqliteIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	if i.index+1 < len(i.keys) {
+		i.index++
+		return true
+	}
+	if i.exhausted {
+		i.index = len(i.keys)
+		return false
+	}
+
+	if len(i.keys) > 0 {
+		i.from = i.keys[len(i.keys)-1]
+		i.inclusive = false
+	}
+	i.fetch()
+	i.index = 0
+	return len(i.keys) > 0
+}
+
+// Seek moves to the first pair whose key is greater than or equal to a key.
+func (i *sqliteIterator) Seek(key []byte) bool {
+	if i.err != nil {
+		return false
+	}
+	i.from = append([]byte{}, key...)
+	if string(i.from) < string(i.start) {
+		i.from = i.start
+	}
+	i.inclusive = true
+	i.exhausted = false
+	i.fetch()
+	i.index = 0
+	return len(i.keys) > 0
+}
+
+// begin starts the read transaction of the iterator, whose snapshot is taken by fetching the
+// first chunk, as SQLite defers it to the first read.
+func (i *sqliteIterator) begin() {
+	i.database.mutex.RLock()
+	if i.database.closed {
+		i.database.mutex.RUnlock()
+		i.err = ErrClosed
+		return
+	}
+
+	i.conn, i.err = i.database.db.Conn(context.Background())
+	if i.err == nil {
+		if _, i.err = i.conn.ExecContext(context.Background(), "BEGIN DEFERRED"); i.err != nil {
+			i.conn.Close()
+			i.conn = nil
+		}
+	}
+	i.database.mutex.RUnlock()
+
+	if i.err == nil {
+		i.fetch()
+	}
+}
+
+// fetch replaces the pairs of the iterator by the next chunk.
+func (i *sqliteIterator) fetch() {
+	i.keys, i.values = nil, nil
+
+	i.database.mutex.RLock()
+	defer i.database.mutex.RUnlock()
+
+	if i.database.closed {
+		i.err = ErrClosed
+		return
+	}
+	if i.conn == nil {
+		return
+	}
+
+	conditions := []string{}
+	arguments := []interface{}{}
+	if len(i.from) > 0 || !i.inclusive {
+		if i.inclusive {
+			conditions = append(conditions, "key >= ?")
+		} else {
+			conditions = append(conditions, "key > ?")
+		}
+		arguments = append(arguments, i.from)
+	}
+	if i.limit != nil {
+		conditions = append(conditions, "key < ?")
+		arguments = append(arguments, i.limit)
+	}
+
+	query := "SELECT key, value FROM entries"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY key LIMIT %d", sqliteIteratorChunk)
+
+	rows, err := i.conn.QueryContext(context.Background(), query, arguments...)
+	if err != nil {
+		i.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, value := []byte{}, []byte{}
+		if i.err = rows.Scan(&key, &value); i.err != nil {
+			i.keys, i.values = nil, nil
+			return
+		}
+		i.keys = append(i.keys, key)
+		i.values = append(i.values, value)
+	}
+	if i.err = rows.Err(); i.err != nil {
+		i.keys, i.values = nil, nil
+		return
+	}
+	i.exhausted = len(i.keys) < sqliteIteratorChunk
+}
+
+// Key returns the key of the current pair.
+func (i *sqliteIterator) Key() []byte {
+	if i.index < 0 || i.index >= len(i.keys) {
+		return nil
+	}
+	return i.keys[i.index]
+}
+
+// Value returns the value of the current pair.
+func (i *sqliteIterator) Value() []byte {
+	if i.index < 0 || i.index >= len(i.keys) {
+		return nil
+	}
+	return i.values[i.index]
+}
+
+// Release ends the read transaction of the iterator and releases the pairs it fetched.
+func (i *sqliteIterator) Release() {
+	if i.conn != nil {
+		i.conn.ExecContext(context.Background(), "ROLLBACK")
+		i.conn.Close()
+		i.conn = nil
+	}
+	i.keys, i.values = nil, nil
+	i.exhausted = true
+}
+
+// Error returns the error which stopped the iteration, if any.
+func (i *sqliteIterator) Error() error {
+	return i.err
+}
@@ -0,0 +1,123 @@
+// Package storage provides the ordered key-value stores in which bajo keeps its data, behind an
+// interface which does not depend on any of them.
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotFound is returned when a key is not present in a database.
+	ErrNotFound = errors.New("storage: key not found")
+
+	// ErrReadOnly is returned when writing to a database opened in read-only mode.
+	ErrReadOnly = errors.New("storage: database is read-only")
+
+	// ErrClosed is returned when using a database which has been closed.
+	ErrClosed = errors.New("storage: database is closed")
+
+	// ErrLocked is returned when a database is already opened by another process.
+	ErrLocked = errors.New("storage: database is locked by another process")
+)
+
+// Database is an ordered key-value store. Keys are ordered bytewise, and a key which is
+// not present is reported with ErrNotFound.
+type Database interface {
+	// Close closes the database, after which it can no longer be used.
+	Close() error
+	// Delete removes a key, which is not an error when the key is not present.
+	Delete(key []byte) error
+	// Get retrieves the value of a key.
+	Get(key []byte) ([]byte, error)
+	// Has determines whether a key is present.
+	Has(key []byte) (bool, error)
+	// NewIterator creates an iterator over the keys starting with a prefix, in key order.
+	// A nil prefix iterates over every key. The iterator must be released once done with.
+	NewIterator(prefix []byte) Iterator
+	// Put stores the value of a key, replacing any previous value.
+	Put(key, value []byte) error
+	// Write applies the operations of a batch atomically.
+	Write(batch *Batch) error
+}
+
+// Compacter is implemented by databases which can reclaim at once the space held by deleted and
+// replaced values, which they otherwise reclaim gradually, if ever.
+type Compacter interface {
+	// Compact rewrites the database so that it only holds its current pairs.
+	Compact() error
+}
+
+// Iterator iterates over key-value pairs in key order. It is positioned before the first pair
+// when created, so Next must be called before reading a pair.
+type Iterator interface {
+	// Next moves to the next pair, returning whether there is one.
+	Next() bool
+	// Seek moves to the first pair whose key is greater than or equal to a key, returning
+	// whether there is one.
+	Seek(key []byte) bool
+	// Key returns the key of the current pair, which is only valid until the iterator moves.
+	Key() []byte
+	// Value returns the value of the current pair, which is only valid until the iterator moves.
+	Value() []byte
+	// Release releases the resources held by the iterator.
+	Release()
+	// Error returns the error which stopped the iteration, if any.
+	Error() error
+}
+
+// operation is a put or delete of a batch.
+type operation struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// Batch accumulates puts and deletes which are applied atomically by Database.Write.
+type Batch struct {
+	operations []operation
+}
+
+// Put adds the put of a key to the batch.
+func (b *Batch) Put(key, value []byte) {
+	b.operations = append(b.operations, operation{
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+// Delete adds the deletion of a key to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.operations = append(b.operations, operation{
+		key:     append([]byte{}, key...),
+		deleted: true,
+	})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.operations)
+}
+
+// Replay calls put or del for every operation of the batch, in the order they were added.
+func (b *Batch) Replay(put func(key, value []byte), del func(key []byte)) {
+	for _, operation := range b.operations {
+		if operation.deleted {
+			del(operation.key)
+		} else {
+			put(operation.key, operation.value)
+		}
+	}
+}
+
+// PrefixLimit returns the smallest key greater than every key starting with a prefix, which
+// is nil when there is none, as for a prefix of 0xff bytes only.
+func PrefixLimit(prefix []byte) []byte {
+	limit := append([]byte{}, prefix...)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
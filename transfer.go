@@ -0,0 +1,728 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+const (
+	// TransferFormatCSV identifies CSV files holding a header row naming their columns.
+	TransferFormatCSV = "csv"
+	// TransferFormatJSONL identifies JSON Lines files holding one link object per line.
+	TransferFormatJSONL = "jsonl"
+
+	// ConflictSkip leaves links whose key is already in use untouched.
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces links whose key is already in use.
+	ConflictOverwrite = "overwrite"
+	// ConflictFail stops an import at the first key already in use.
+	ConflictFail = "fail"
+
+	// csvListSeparator separates the tags and flags held by a single CSV column.
+	csvListSeparator = ";"
+
+	// importBatchSize is the number of links written to the URL database at once on import.
+	importBatchSize = 1000
+
+	// maxImportErrors bounds the number of invalid lines described by an import summary.
+	maxImportErrors = 100
+)
+
+// transferCSVColumns lists the columns of CSV exports. Imported CSV files may hold any of them,
+// in any order, but must hold the key and url columns.
+var transferCSVColumns = []string{"key", "url", "created_at", "creator", "expires_at", "redirect_type", "tags", "flags"}
+
+// transferContentTypes maps the transfer formats to the media types with which they are served.
+var transferContentTypes = map[string]string{
+	TransferFormatCSV:   "text/csv; charset=utf-8",
+	TransferFormatJSONL: "application/x-ndjson",
+}
+
+var (
+	// ErrTransferFormat is returned when a transfer format is neither csv nor jsonl.
+	ErrTransferFormat = errors.New("format must be csv or jsonl")
+	// ErrConflictHandling is returned when the conflict handling of an import is unknown.
+	ErrConflictHandling = errors.New("conflict handling must be skip, overwrite or fail")
+	// ErrImportFormat is returned when an imported file cannot be read in its format at all,
+	// as opposed to lines which are invalid on their own.
+	ErrImportFormat = errors.New("the file cannot be imported")
+)
+
+// TransferredLink represents a link as it is exported, and as it is read from imported files.
+type TransferredLink struct {
+	Key          string     `json:"key"`
+	URL          string     `json:"url"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	Creator      string     `json:"creator,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Flags        []string   `json:"flags,omitempty"`
+}
+
+// ExportOptions holds the options of an export.
+type ExportOptions struct {
+	// Format is the format of the export, which defaults to JSON Lines.
+	Format string `form:"format" json:"format" binding:"omitempty,oneof=csv jsonl"`
+}
+
+// ImportOptions holds the options of an import.
+type ImportOptions struct {
+	// Format is the format of the imported file, which defaults to JSON Lines.
+	Format string `form:"format" json:"format" binding:"omitempty,oneof=csv jsonl"`
+	// Conflict determines how links whose key is already in use are handled, failing by default.
+	Conflict string `form:"conflict" json:"conflict" binding:"omitempty,oneof=skip overwrite fail"`
+	// DryRun reports the outcome of the import without writing anything.
+	DryRun bool `form:"dry_run" json:"dry_run"`
+}
+
+// Validate checks that the options name a known format and conflict handling.
+func (o *ImportOptions) Validate() error {
+	if o.Format != "" && transferContentTypes[o.Format] == "" {
+		return ErrTransferFormat
+	}
+	switch o.Conflict {
+	case "", ConflictSkip, ConflictOverwrite, ConflictFail:
+		return nil
+	}
+	return ErrConflictHandling
+}
+
+// ImportSummary reports the outcome of an import.
+type ImportSummary struct {
+	// Links is the number of links read, invalid ones included.
+	Links int `json:"links"`
+	// Created is the number of links whose key was free.
+	Created int `json:"created"`
+	// Overwritten is the number of links which replaced a link with the same key.
+	Overwritten int `json:"overwritten"`
+	// Skipped is the number of links left out as their key was already in use.
+	Skipped int `json:"skipped"`
+	// Invalid is the number of lines which could not be imported.
+	Invalid int `json:"invalid"`
+	// DryRun is set when nothing was written, the other counts describing what would have been.
+	DryRun bool `json:"dry_run"`
+	// Errors describes the first invalid lines.
+	Errors []ImportLineError `json:"errors,omitempty"`
+}
+
+// ImportLineError describes a line of an imported file which could not be imported.
+type ImportLineError struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// addInvalid counts an invalid line, describing it unless enough lines already are.
+func (s *ImportSummary) addInvalid(line int, key string, err error) {
+	s.Invalid++
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, ImportLineError{Line: line, Key: key, Error: err.Error()})
+	}
+}
+
+// ImportConflictError is returned when an import failing on conflicts reaches a key in use.
+type ImportConflictError struct {
+	Line int
+	Key  string
+}
+
+// Error describes the conflicting line.
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("line %d: key %q is already in use", e.Line, e.Key)
+}
+
+// Unwrap returns ErrLinkKeyTaken.
+func (e *ImportConflictError) Unwrap() error {
+	return ErrLinkKeyTaken
+}
+
+// ExportLinks writes every link of the URL database in a format, returning the number of links
+// written. Click statistics and API keys are not exported, backups holding the whole database.
+func ExportLinks(urlDatabase URLDatabase, writer io.Writer, format string) (int, error) {
+	var links linkWriter
+	switch format {
+	case TransferFormatCSV:
+		links = newCSVLinkWriter(writer)
+	case TransferFormatJSONL, "":
+		links = newJSONLinkWriter(writer)
+	default:
+		return 0, ErrTransferFormat
+	}
+
+	if err := links.Header(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err := forEachLink(urlDatabase, func(URLKey, value []byte) error {
+		record, err := decodeStoredLink(urlDatabase, URLKey, value)
+		if err != nil {
+			return fmt.Errorf("unable to export key %q: %w", URLKey, err)
+		}
+
+		count++
+		return links.Write(newTransferredLink(string(URLKey), record))
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, links.Flush()
+}
+
+// newTransferredLink converts the record of a URL key to its exported form.
+func newTransferredLink(URLKey string, record *LinkRecord) *TransferredLink {
+	link := &TransferredLink{
+		Key:          URLKey,
+		URL:          record.URL,
+		Creator:      record.Creator,
+		ExpiresAt:    record.ExpiresAt,
+		RedirectType: record.RedirectType,
+		Tags:         record.Tags,
+		Flags:        record.Flags,
+	}
+	// The creation time of migrated links is unknown.
+	if !record.CreatedAt.IsZero() {
+		createdAt := record.CreatedAt
+		link.CreatedAt = &createdAt
+	}
+	return link
+}
+
+// linkWriter writes links in a transfer format.
+type linkWriter interface {
+	Header() error
+	Write(link *TransferredLink) error
+	Flush() error
+}
+
+// jsonLinkWriter writes links as JSON Lines.
+type jsonLinkWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// newJSONLinkWriter creates a writer of JSON Lines.
+func newJSONLinkWriter(writer io.Writer) *jsonLinkWriter {
+	buffered := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(buffered)
+	encoder.SetEscapeHTML(false)
+	return &jsonLinkWriter{writer: buffered, encoder: encoder}
+}
+
+// Header writes nothing, as JSON Lines have no header.
+func (w *jsonLinkWriter) Header() error {
+	return nil
+}
+
+// Write writes a link on its own line.
+func (w *jsonLinkWriter) Write(link *TransferredLink) error {
+	return w.encoder.Encode(link)
+}
+
+// Flush writes the buffered lines.
+func (w *jsonLinkWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// csvLinkWriter writes links as CSV rows.
+type csvLinkWriter struct {
+	writer *csv.Writer
+}
+
+// newCSVLinkWriter creates a writer of CSV rows.
+func newCSVLinkWriter(writer io.Writer) *csvLinkWriter {
+	return &csvLinkWriter{writer: csv.NewWriter(writer)}
+}
+
+// Header writes the row naming the columns.
+func (w *csvLinkWriter) Header() error {
+	return w.writer.Write(transferCSVColumns)
+}
+
+// Write writes a link as a row. Times are formatted as RFC 3339, while tags and flags are
+// joined by semicolons.
+func (w *csvLinkWriter) Write(link *TransferredLink) error {
+	row := []string{link.Key, link.URL, "", link.Creator, "", "", strings.Join(link.Tags, csvListSeparator), strings.Join(link.Flags, csvListSeparator)}
+	if link.CreatedAt != nil {
+		row[2] = link.CreatedAt.Format(time.RFC3339Nano)
+	}
+	if link.ExpiresAt != nil {
+		row[4] = link.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	if link.RedirectType != 0 {
+		row[5] = strconv.Itoa(link.RedirectType)
+	}
+	return w.writer.Write(row)
+}
+
+// Flush writes the buffered rows.
+func (w *csvLinkWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// linkReader reads links in a transfer format.
+type linkReader interface {
+	// Read returns the next link along with its line number, and io.EOF once there are no more.
+	// Lines which cannot be decoded are reported as an *invalidLineError, and can be skipped.
+	Read() (*TransferredLink, int, error)
+}
+
+// invalidLineError reports a line which cannot be decoded as a link.
+type invalidLineError struct {
+	err error
+}
+
+// Error describes why the line cannot be decoded.
+func (e *invalidLineError) Error() string {
+	return e.err.Error()
+}
+
+// jsonLinkReader reads links from JSON Lines. Blank lines are skipped.
+type jsonLinkReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+// newJSONLinkReader creates a reader of JSON Lines.
+func newJSONLinkReader(reader io.Reader) *jsonLinkReader {
+	return &jsonLinkReader{reader: bufio.NewReader(reader)}
+}
+
+// Read decodes the next non-blank line. Fields other than those of a link are rejected, so that
+// misnamed fields are not silently dropped.
+func (r *jsonLinkReader) Read() (*TransferredLink, int, error) {
+	for {
+		content, err := r.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(content) == 0) {
+			return nil, r.line, err
+		}
+		r.line++
+
+		content = bytes.TrimSpace(content)
+		if len(content) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		link := &TransferredLink{}
+		if err = decoder.Decode(link); err != nil {
+			return nil, r.line, &invalidLineError{err: err}
+		}
+		if decoder.More() {
+			return nil, r.line, &invalidLineError{err: errors.New("line holds more than a single JSON object")}
+		}
+		return link, r.line, nil
+	}
+}
+
+// csvLinkReader reads links from CSV rows, whose columns are named by a header row.
+type csvLinkReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+// newCSVLinkReader creates a reader of CSV rows, reading the header row.
+func newCSVLinkReader(reader io.Reader) (*csvLinkReader, error) {
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing CSV header row", ErrImportFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrImportFormat, err)
+	}
+
+	// Spreadsheets may start their files with a byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := map[string]int{}
+	for index, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !containsString(transferCSVColumns, name) {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", ErrImportFormat, name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate CSV column %q", ErrImportFormat, name)
+		}
+		columns[name] = index
+	}
+	for _, name := range []string{"key", "url"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing CSV column %q", ErrImportFormat, name)
+		}
+	}
+
+	return &csvLinkReader{reader: csvReader, columns: columns}, nil
+}
+
+// Read decodes the next row.
+func (r *csvLinkReader) Read() (*TransferredLink, int, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, 0, err
+	}
+
+	var parseError *csv.ParseError
+	if errors.As(err, &parseError) {
+		return nil, parseError.StartLine, &invalidLineError{err: parseError.Err}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	link, err := r.decode(row)
+	if err != nil {
+		return nil, line, &invalidLineError{err: err}
+	}
+	return link, line, nil
+}
+
+// decode converts a row to a link.
+func (r *csvLinkReader) decode(row []string) (*TransferredLink, error) {
+	value := func(name string) string {
+		if index, ok := r.columns[name]; ok {
+			return strings.TrimSpace(row[index])
+		}
+		return ""
+	}
+
+	link := &TransferredLink{
+		Key:     value("key"),
+		URL:     value("url"),
+		Creator: value("creator"),
+		Tags:    splitCSVList(value("tags")),
+		Flags:   splitCSVList(value("flags")),
+	}
+
+	for name, field := range map[string]**time.Time{"created_at": &link.CreatedAt, "expires_at": &link.ExpiresAt} {
+		if value(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value(name))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*field = &parsed
+	}
+
+	if redirectType := value("redirect_type"); redirectType != "" {
+		var err error
+		if link.RedirectType, err = strconv.Atoi(redirectType); err != nil {
+			return nil, errors.New("redirect_type must be a number")
+		}
+	}
+
+	return link, nil
+}
+
+// splitCSVList splits the tags or flags held by a CSV column.
+func splitCSVList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	items := []string{}
+	for _, item := range strings.Split(value, csvListSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// containsString determines whether a string is among values.
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkImporter imports links into the URL database, validating them as the routes creating
+// links do.
+type LinkImporter struct {
+	URLDatabase URLDatabase
+	Config      *Config
+	KeyPolicy   *KeyPolicy
+}
+
+// importedLink is a validated link waiting to be written to the URL database.
+type importedLink struct {
+	line  int
+	key   string
+	value []byte
+}
+
+// Import reads links from a file and writes them to the URL database in batches, reporting the
+// outcome in a summary. Invalid lines are counted and skipped. When the import fails, the
+// summary describes the links read so far, of which those preceding the failure were written.
+func (i *LinkImporter) Import(reader io.Reader, options ImportOptions) (*ImportSummary, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Conflict == "" {
+		options.Conflict = ConflictFail
+	}
+
+	var links linkReader
+	if options.Format == TransferFormatCSV {
+		csvReader, err := newCSVLinkReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		links = csvReader
+	} else {
+		links = newJSONLinkReader(reader)
+	}
+
+	summary := &ImportSummary{DryRun: options.DryRun}
+	// claimed holds the keys of links read but not yet written, which is all of them on a dry run,
+	// so that later links with the same key are handled as conflicts.
+	claimed := map[string]bool{}
+	pending := []importedLink{}
+	now := time.Now()
+
+	for {
+		link, line, err := links.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var invalidLine *invalidLineError
+		if errors.As(err, &invalidLine) {
+			summary.Links++
+			summary.addInvalid(line, "", invalidLine)
+			continue
+		}
+		if err != nil {
+			return summary, fmt.Errorf("%w: %s", ErrImportFormat, err)
+		}
+		summary.Links++
+
+		URLKey, record, err := i.linkRecord(link, now)
+		if err != nil {
+			summary.addInvalid(line, link.Key, err)
+			continue
+		}
+		value, err := record.Encode()
+		if err != nil {
+			return summary, err
+		}
+
+		pending = append(pending, importedLink{line: line, key: URLKey, value: value})
+		if len(pending) == importBatchSize {
+			if err = i.write(pending, claimed, options, summary); err != nil {
+				return summary, err
+			}
+			pending = pending[:0]
+		}
+	}
+
+	return summary, i.write(pending, claimed, options, summary)
+}
+
+// linkRecord validates a link, returning its key and its record. Keys are imported as exported,
+// without being normalized or held to the rules of custom keys, as generated keys are not.
+func (i *LinkImporter) linkRecord(link *TransferredLink, now time.Time) (string, *LinkRecord, error) {
+	if link.Key == "" {
+		return "", nil, errors.New("key is required")
+	}
+	if err := i.KeyPolicy.ValidateStored(link.Key); err != nil {
+		return "", nil, err
+	}
+
+	if link.URL == "" {
+		return "", nil, errors.New("url is required")
+	}
+	normalizedURL, err := NormalizeURL(link.URL, i.Config.AllowedSchemes, i.Config.MaxURLLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch link.RedirectType {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", nil, errors.New("redirect_type must be 301, 302, 307 or 308")
+	}
+
+	// Links whose creation time is unknown are considered created by the import.
+	createdAt := now
+	if link.CreatedAt != nil {
+		createdAt = *link.CreatedAt
+	}
+	var expiresAt time.Time
+	if link.ExpiresAt != nil {
+		expiresAt = *link.ExpiresAt
+	}
+
+	record := NewLinkRecord(normalizedURL, createdAt, expiresAt)
+	record.Creator = link.Creator
+	record.RedirectType = link.RedirectType
+	record.Tags = link.Tags
+	record.Flags = link.Flags
+	return link.Key, record, nil
+}
+
+// write writes pending links to the URL database in a single batch, unless the import is a dry
+// run, handling the keys already in use as the options require. Keys of expired links are free,
+// as when shortening, and the entries of a replaced link are deleted along with it. Links
+// preceding a conflict which fails the import are written.
+func (i *LinkImporter) write(pending []importedLink, claimed map[string]bool, options ImportOptions, summary *ImportSummary) error {
+	urlKeyMutex.Lock()
+	defer urlKeyMutex.Unlock()
+
+	batch := new(storage.Batch)
+	var conflict error
+	now := time.Now()
+
+	for _, link := range pending {
+		inUse, stored := claimed[link.key], false
+		if !inUse {
+			var err error
+			if inUse, stored, err = i.keyInUse([]byte(link.key), now); err != nil {
+				return err
+			}
+		}
+
+		if inUse {
+			if options.Conflict == ConflictSkip {
+				summary.Skipped++
+				continue
+			}
+			if options.Conflict == ConflictFail {
+				conflict = &ImportConflictError{Line: link.line, Key: link.key}
+				break
+			}
+			summary.Overwritten++
+		} else {
+			summary.Created++
+		}
+
+		if stored {
+			if err := deleteLinkEntries(i.URLDatabase, batch, []byte(link.key)); err != nil {
+				return err
+			}
+		}
+		claimed[link.key] = true
+		batch.Put([]byte(link.key), link.value)
+	}
+
+	if !options.DryRun {
+		if batch.Len() > 0 {
+			if err := i.URLDatabase.Write(batch); err != nil {
+				return err
+			}
+		}
+		// Written keys are found in the URL database from now on.
+		for key := range claimed {
+			delete(claimed, key)
+		}
+	}
+
+	return conflict
+}
+
+// keyInUse determines whether a key is held by a link which has not expired, and whether it is
+// stored at all. Values which cannot be decoded hold their key, as they are not known to expire.
+func (i *LinkImporter) keyInUse(URLKey []byte, now time.Time) (inUse, stored bool, err error) {
+	value, err := i.URLDatabase.Get(URLKey)
+	if err == storage.ErrNotFound {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	record, err := decodeStoredLink(i.URLDatabase, URLKey, value)
+	return err != nil || !record.IsExpired(now), true, nil
+}
+
+// TransferController contains logic and data related to the /api/export and /api/import routes.
+type TransferController struct {
+	URLDatabase URLDatabase
+	Config      *Config
+	KeyPolicy   *KeyPolicy
+}
+
+// Export implements the logic for the /api/export route, which streams every link of the URL
+// database. A failure once the export has started to be sent is logged, and leaves the client
+// with a truncated export.
+func (c *TransferController) Export(context *gin.Context) {
+	var options ExportOptions
+	if err := context.ShouldBindQuery(&options); err != nil {
+		respondWithError(context, errBinding(err, &options))
+		return
+	}
+	if options.Format == "" {
+		options.Format = TransferFormatJSONL
+	}
+
+	filename := fmt.Sprintf("bajo-%s.%s", time.Now().UTC().Format("20060102T150405Z"), options.Format)
+	context.Header("Content-Type", transferContentTypes[options.Format])
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	count, err := ExportLinks(c.URLDatabase, context.Writer, options.Format)
+	if err != nil {
+		if !context.Writer.Written() {
+			context.Writer.Header().Del("Content-Disposition")
+			respondWithError(context, errInternal(err))
+			return
+		}
+		requestLogger(context).Error("unable to complete export", "error", err)
+		addLogFields(context, "outcome", ErrorCodeInternal)
+		return
+	}
+	addLogFields(context, "links", count)
+}
+
+// Import implements the logic for the /api/import route, which imports the links streamed in
+// the request body and responds with a summary of the import.
+func (c *TransferController) Import(context *gin.Context) {
+	var options ImportOptions
+	if err := context.ShouldBindQuery(&options); err != nil {
+		respondWithError(context, errBinding(err, &options))
+		return
+	}
+
+	importer := LinkImporter{URLDatabase: c.URLDatabase, Config: c.Config, KeyPolicy: c.KeyPolicy}
+	summary, err := importer.Import(context.Request.Body, options)
+
+	var conflictError *ImportConflictError
+	switch {
+	case errors.As(err, &conflictError):
+		respondWithError(context, NewAPIError(http.StatusConflict, ErrorCodeKeyInUse, err.Error()).
+			WithDetail("line", conflictError.Line).
+			WithDetail("key", conflictError.Key).
+			WithDetail("summary", summary))
+		return
+	case errors.Is(err, ErrImportFormat):
+		apiErr := NewAPIError(http.StatusBadRequest, ErrorCodeMalformedRequest, err.Error())
+		apiErr.Err = err
+		respondWithError(context, apiErr.WithDetail("summary", summary))
+		return
+	case err != nil:
+		respondWithError(context, errInternal(err))
+		return
+	}
+
+	addLogFields(context, "links", summary.Links, "invalid", summary.Invalid, "dry_run", summary.DryRun)
+	context.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/upsideon/bajo/storage"
+)
+
+var _ = Describe("Transfer", func() {
+	var urlDatabase *storage.Memory
+	var importer *LinkImporter
+
+	createdAt := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		urlDatabase = storage.NewMemory()
+		DeferCleanup(urlDatabase.Close)
+
+		keyPolicy, err := NewKeyPolicy(DefaultConfig())
+		Expect(err).NotTo(HaveOccurred())
+		keyPolicy.Reserve("api")
+		importer = &LinkImporter{URLDatabase: urlDatabase, Config: DefaultConfig(), KeyPolicy: keyPolicy}
+	})
+
+	// storeLinks stores a link with every kind of metadata, a legacy value with a legacy expiry,
+	// and a click event.
+	storeLinks := func() {
+		record := NewLinkRecord("https://example.com/docs", createdAt, expiresAt)
+		record.Creator = "team"
+		record.RedirectType = http.StatusMovedPermanently
+		record.Tags = []string{"docs", "public"}
+		Expect(writeLinkRecord(urlDatabase, []byte("docs"), record)).To(Succeed())
+
+		Expect(urlDatabase.Put([]byte("legacy"), []byte("https://example.com/legacy"))).To(Succeed())
+		Expect(urlDatabase.Put(legacyExpiryKey([]byte("legacy")), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+
+		Expect(RecordClick(urlDatabase, ClickEvent{Key: "docs", Timestamp: createdAt})).To(Succeed())
+	}
+
+	Describe("ExportLinks", func() {
+		BeforeEach(storeLinks)
+
+		It("should write every link as JSON Lines", func() {
+			output := new(bytes.Buffer)
+			count, err := ExportLinks(urlDatabase, output, TransferFormatJSONL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(2))
+
+			Expect(strings.Split(strings.TrimSpace(output.String()), "\n")).To(Equal([]string{
+				`{"key":"docs","url":"https://example.com/docs","created_at":"2023-01-01T12:00:00Z","creator":"team","expires_at":"2030-01-01T00:00:00Z","redirect_type":301,"tags":["docs","public"]}`,
+				`{"key":"legacy","url":"https://example.com/legacy","expires_at":"2030-01-01T00:00:00Z"}`,
+			}))
+		})
+
+		It("should write every link as CSV", func() {
+			output := new(bytes.Buffer)
+			_, err := ExportLinks(urlDatabase, output, TransferFormatCSV)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(output.String()).To(Equal("key,url,created_at,creator,expires_at,redirect_type,tags,flags\n" +
+				"docs,https://example.com/docs,2023-01-01T12:00:00Z,team,2030-01-01T00:00:00Z,301,docs;public,\n" +
+				"legacy,https://example.com/legacy,,,2030-01-01T00:00:00Z,,,\n"))
+		})
+
+		It("should reject unknown formats", func() {
+			_, err := ExportLinks(urlDatabase, new(bytes.Buffer), "xml")
+			Expect(err).To(MatchError(ErrTransferFormat))
+		})
+
+		for _, format := range []string{TransferFormatCSV, TransferFormatJSONL} {
+			format := format
+
+			It("should export links which import identically as "+format, func() {
+				output := new(bytes.Buffer)
+				_, err := ExportLinks(urlDatabase, output, format)
+				Expect(err).NotTo(HaveOccurred())
+
+				source := urlDatabase
+				urlDatabase = storage.NewMemory()
+				DeferCleanup(urlDatabase.Close)
+				importer.URLDatabase = urlDatabase
+
+				summary, err := importer.Import(output, ImportOptions{Format: format})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(summary.Created).To(Equal(2))
+
+				for _, key := range []string{"docs", "legacy"} {
+					expected, _, err := readLinkRecord(source, []byte(key))
+					Expect(err).NotTo(HaveOccurred())
+					imported, _, err := readLinkRecord(urlDatabase, []byte(key))
+					Expect(err).NotTo(HaveOccurred())
+
+					// The creation time of the migrated link is unknown, so it is that of the import.
+					if expected.CreatedAt.IsZero() {
+						expected.CreatedAt = imported.CreatedAt
+					}
+					Expect(imported).To(Equal(expected))
+				}
+			})
+		}
+	})
+
+	Describe("LinkImporter", func() {
+		It("should report invalid lines and import the others", func() {
+			input := strings.Join([]string{
+				`{"key":"docs","url":"https://example.com/docs"}`,
+				``,
+				`{"key":"ftp","url":"ftp://example.com/"}`,
+				`{"key":"api","url":"https://example.com/"}`,
+				`{"key":"broken"`,
+				`{"key":"moved","url":"https://example.com/","redirect_type":303}`,
+				`{"key":"typo","target":"https://example.com/"}`,
+				`{"url":"https://example.com/"}`,
+			}, "\n")
+
+			summary, err := importer.Import(strings.NewReader(input), ImportOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Links).To(Equal(7))
+			Expect(summary.Created).To(Equal(1))
+			Expect(summary.Invalid).To(Equal(6))
+
+			lines := []int{}
+			for _, lineError := range summary.Errors {
+				lines = append(lines, lineError.Line)
+			}
+			Expect(lines).To(Equal([]int{3, 4, 5, 6, 7, 8}))
+			Expect(summary.Errors[1]).To(Equal(ImportLineError{Line: 4, Key: "api", Error: `key "api" is reserved`}))
+
+			Expect(databaseContent(urlDatabase)).To(HaveLen(1))
+		})
+
+		It("should read CSV columns in any order", func() {
+			input := "\ufeffURL,Key,tags\nhttps://example.com/,docs,a; b\nhttps://example.com/,\"unterminated\n"
+
+			summary, err := importer.Import(strings.NewReader(input), ImportOptions{Format: TransferFormatCSV})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Created).To(Equal(1))
+			Expect(summary.Errors).To(ConsistOf(HaveField("Line", 3)))
+
+			record, _, err := readLinkRecord(urlDatabase, []byte("docs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.Tags).To(Equal([]string{"a", "b"}))
+		})
+
+		It("should reject CSV files without the required columns", func() {
+			_, err := importer.Import(strings.NewReader("key,target\ndocs,https://example.com/\n"), ImportOptions{Format: TransferFormatCSV})
+			Expect(err).To(MatchError(ErrImportFormat))
+			Expect(err).To(MatchError(ContainSubstring(`unknown CSV column "target"`)))
+
+			_, err = importer.Import(strings.NewReader("key\ndocs\n"), ImportOptions{Format: TransferFormatCSV})
+			Expect(err).To(MatchError(ContainSubstring(`missing CSV column "url"`)))
+		})
+
+		Context("when keys are already in use", func() {
+			input := strings.Join([]string{
+				`{"key":"new","url":"https://example.com/new"}`,
+				`{"key":"docs","url":"https://example.com/replaced"}`,
+				`{"key":"new","url":"https://example.com/again"}`,
+			}, "\n")
+
+			BeforeEach(func() {
+				Expect(writeLinkRecord(urlDatabase, []byte("docs"), NewLinkRecord("https://example.com/docs", createdAt, time.Time{}))).To(Succeed())
+			})
+
+			storedURL := func(key string) string {
+				record, _, err := readLinkRecord(urlDatabase, []byte(key))
+				Expect(err).NotTo(HaveOccurred())
+				return record.URL
+			}
+
+			It("should skip them", func() {
+				summary, err := importer.Import(strings.NewReader(input), ImportOptions{Conflict: ConflictSkip})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*summary).To(Equal(ImportSummary{Links: 3, Created: 1, Skipped: 2}))
+				Expect(storedURL("docs")).To(Equal("https://example.com/docs"))
+				Expect(storedURL("new")).To(Equal("https://example.com/new"))
+			})
+
+			It("should overwrite them", func() {
+				summary, err := importer.Import(strings.NewReader(input), ImportOptions{Conflict: ConflictOverwrite})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*summary).To(Equal(ImportSummary{Links: 3, Created: 1, Overwritten: 2}))
+				Expect(storedURL("docs")).To(Equal("https://example.com/replaced"))
+				Expect(storedURL("new")).To(Equal("https://example.com/again"))
+			})
+
+			It("should fail on the first of them, having imported the preceding links", func() {
+				summary, err := importer.Import(strings.NewReader(input), ImportOptions{})
+				Expect(err).To(MatchError(ErrLinkKeyTaken))
+				Expect(err).To(Equal(&ImportConflictError{Line: 2, Key: "docs"}))
+				Expect(summary.Created).To(Equal(1))
+				Expect(storedURL("docs")).To(Equal("https://example.com/docs"))
+				Expect(storedURL("new")).To(Equal("https://example.com/new"))
+			})
+
+			It("should only report the outcome of a dry run", func() {
+				summary, err := importer.Import(strings.NewReader(input), ImportOptions{Conflict: ConflictSkip, DryRun: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*summary).To(Equal(ImportSummary{Links: 3, Created: 1, Skipped: 2, DryRun: true}))
+				Expect(databaseContent(urlDatabase)).To(HaveLen(1))
+			})
+
+			It("should delete the click events and legacy expiry of the links they overwrite", func() {
+				Expect(urlDatabase.Put(legacyExpiryKey([]byte("docs")), []byte(expiresAt.Format(time.RFC3339Nano)))).To(Succeed())
+				Expect(RecordClick(urlDatabase, ClickEvent{Key: "docs", Timestamp: createdAt})).To(Succeed())
+
+				_, err := importer.Import(strings.NewReader(input), ImportOptions{Conflict: ConflictOverwrite})
+				Expect(err).NotTo(HaveOccurred())
+
+				statistics, err := GetClickStatistics(urlDatabase, "docs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+				Expect(urlDatabase.Has(legacyExpiryKey([]byte("docs")))).To(BeFalse())
+			})
+
+			It("should reuse the keys of expired links, deleting their click events", func() {
+				expiredRecord := NewLinkRecord("https://example.com/docs", createdAt, time.Now().Add(-time.Minute))
+				Expect(writeLinkRecord(urlDatabase, []byte("docs"), expiredRecord)).To(Succeed())
+				Expect(RecordClick(urlDatabase, ClickEvent{Key: "docs", Timestamp: createdAt})).To(Succeed())
+
+				summary, err := importer.Import(strings.NewReader(input), ImportOptions{Conflict: ConflictSkip})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*summary).To(Equal(ImportSummary{Links: 3, Created: 2, Skipped: 1}))
+				Expect(storedURL("docs")).To(Equal("https://example.com/replaced"))
+
+				statistics, err := GetClickStatistics(urlDatabase, "docs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statistics.TotalClicks).To(Equal(0))
+			})
+		})
+
+		It("should write links in batches", func() {
+			input := new(bytes.Buffer)
+			for i := 0; i < importBatchSize+10; i++ {
+				json.NewEncoder(input).Encode(TransferredLink{Key: "key" + strconv.Itoa(i), URL: "https://example.com/"})
+			}
+
+			summary, err := importer.Import(input, ImportOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Created).To(Equal(importBatchSize + 10))
+			Expect(databaseContent(urlDatabase)).To(HaveLen(importBatchSize + 10))
+		})
+	})
+
+	Describe("/api/export and /api/import", func() {
+		const adminAPIKey = "admin-secret"
+		var config *Config
+
+		BeforeEach(func() {
+			config = DefaultConfig()
+			config.AdminAPIKey = adminAPIKey
+		})
+
+		send := func(method, path, body string) *httptest.ResponseRecorder {
+			router, err := initializeRouter(urlDatabase, config)
+			Expect(err).NotTo(HaveOccurred())
+
+			writer := httptest.NewRecorder()
+			request, _ := http.NewRequest(method, path, strings.NewReader(body))
+			request.Header.Set("Authorization", "Bearer "+adminAPIKey)
+			router.ServeHTTP(writer, request)
+			return writer
+		}
+
+		BeforeEach(storeLinks)
+
+		It("should stream an export of the links to admins", func() {
+			writer := send("GET", "/api/export?format=csv", "")
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Header().Get("Content-Type")).To(Equal("text/csv; charset=utf-8"))
+			Expect(writer.Header().Get("Content-Disposition")).To(MatchRegexp(`^attachment; filename="bajo-\d{8}T\d{6}Z\.csv"$`))
+			Expect(strings.Count(writer.Body.String(), "\n")).To(Equal(3))
+		})
+
+		It("should reject unknown formats", func() {
+			writer := send("GET", "/api/export?format=xml", "")
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+			Expect(writer.Body.String()).To(ContainSubstring(`"field":"format"`))
+		})
+
+		It("should import links and respond with a summary", func() {
+			writer := send("POST", "/api/import?conflict=skip", `{"key":"docs","url":"https://example.com/"}`+"\n"+`{"key":"new","url":"https://example.com/"}`)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Body.String()).To(MatchJSON(`{"links":2,"created":1,"overwritten":0,"skipped":1,"invalid":0,"dry_run":false}`))
+		})
+
+		It("should respond with a conflict when failing on keys in use", func() {
+			writer := send("POST", "/api/import", `{"key":"docs","url":"https://example.com/"}`)
+			Expect(writer.Code).To(Equal(http.StatusConflict))
+			Expect(writer.Body.String()).To(ContainSubstring(`"code":"key_in_use"`))
+			Expect(writer.Body.String()).To(ContainSubstring(`"line":1`))
+		})
+
+		It("should import exported generated links under the same keys", func() {
+			// Generated keys are longer than custom keys may be, and differ from their lowercase form.
+			config.CaseInsensitiveKeys = true
+			config.CustomKeySizeLimit = 4
+
+			keys := map[string]string{}
+			for _, target := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+				writer := send("POST", "/shorten", `{"url": "`+target+`"}`)
+				Expect(writer.Code).To(Equal(http.StatusOK))
+				var response map[string]string
+				Expect(json.Unmarshal(writer.Body.Bytes(), &response)).To(Succeed())
+				keys[strings.TrimPrefix(response["shortened_url"], DefaultURLPrefix+"/")] = target
+			}
+
+			export := send("GET", "/api/export", "")
+			Expect(export.Code).To(Equal(http.StatusOK))
+
+			urlDatabase = storage.NewMemory()
+			DeferCleanup(urlDatabase.Close)
+			writer := send("POST", "/api/import", export.Body.String())
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Body.String()).To(MatchJSON(`{"links":5,"created":5,"overwritten":0,"skipped":0,"invalid":0,"dry_run":false}`))
+
+			for key, target := range keys {
+				Expect(key).To(HaveLen(DefaultURLKeySize))
+				writer := send("GET", "/"+key, "")
+				Expect(writer.Code).To(Equal(http.StatusFound))
+				Expect(writer.Header().Get("Location")).To(Equal(target))
+			}
+		})
+
+		It("should reject files which cannot be read", func() {
+			writer := send("POST", "/api/import?format=csv", "key,target\n")
+			Expect(writer.Code).To(Equal(http.StatusBadRequest))
+			Expect(writer.Body.String()).To(ContainSubstring(`"code":"malformed_request"`))
+		})
+	})
+
+	Describe("commands", func() {
+		var databasePath, directory string
+
+		BeforeEach(func() {
+			databasePath = GinkgoT().TempDir()
+			directory = GinkgoT().TempDir()
+		})
+
+		It("should export links and import them into another database", func() {
+			inputPath := filepath.Join(directory, "links.csv")
+			Expect(os.WriteFile(inputPath, []byte("key,url\ndocs,https://example.com/\nshorten,https://example.com/\n"), 0o644)).To(Succeed())
+			Expect(run([]string{"import", "-database-path", databasePath, inputPath})).To(Equal(0))
+
+			outputPath := filepath.Join(directory, "links.jsonl")
+			Expect(run([]string{"export", "-database-path", databasePath, outputPath})).To(Equal(0))
+
+			output, err := os.ReadFile(outputPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(output)).To(MatchRegexp(`^\{"key":"docs","url":"https://example.com/","created_at":"[^"]+"\}\n$`))
+		})
+
+		It("should not write anything on a dry run", func() {
+			inputPath := filepath.Join(directory, "links.jsonl")
+			Expect(os.WriteFile(inputPath, []byte(`{"key":"docs","url":"https://example.com/"}`), 0o644)).To(Succeed())
+			Expect(run([]string{"import", "-dry-run", "-database-path", databasePath, inputPath})).To(Equal(0))
+
+			levelDB, err := storage.OpenLevelDB(databasePath, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer levelDB.Close()
+			Expect(databaseContent(levelDB)).To(BeEmpty())
+		})
+
+		It("should exit with status 1 when an import fails", func() {
+			inputPath := filepath.Join(directory, "links.jsonl")
+			Expect(os.WriteFile(inputPath, []byte(`{"key":"docs","url":"https://example.com/"}`), 0o644)).To(Succeed())
+			Expect(run([]string{"import", "-database-path", databasePath, inputPath})).To(Equal(0))
+			Expect(run([]string{"import", "-database-path", databasePath, inputPath})).To(Equal(1))
+		})
+
+		It("should exit with status 2 with unknown options", func() {
+			Expect(run([]string{"import", "-conflict", "merge", "-database-path", databasePath, "links.jsonl"})).To(Equal(2))
+			Expect(run([]string{"export", "-format", "xml", "-database-path", databasePath, "links.xml"})).To(Equal(2))
+		})
+	})
+})